@@ -11,35 +11,85 @@ import (
 	"os"
 
 	"github.com/mihailtudos/service3/app/services/sales-api/handlers/debug/checkgr"
+	v1APIKeyGrp "github.com/mihailtudos/service3/app/services/sales-api/handlers/v1/apikeygrp"
+	v1AuditGrp "github.com/mihailtudos/service3/app/services/sales-api/handlers/v1/auditgrp"
+	v1OIDCGrp "github.com/mihailtudos/service3/app/services/sales-api/handlers/v1/oidcgrp"
+	v1ProductGrp "github.com/mihailtudos/service3/app/services/sales-api/handlers/v1/productgrp"
+	v1SaleGrp "github.com/mihailtudos/service3/app/services/sales-api/handlers/v1/salegrp"
 	v1TestGrp "github.com/mihailtudos/service3/app/services/sales-api/handlers/v1/testgrp"
 	v1UserGrp "github.com/mihailtudos/service3/app/services/sales-api/handlers/v1/usergrp"
+	"github.com/mihailtudos/service3/app/services/sales-api/handlers/wellknown/jwksgrp"
+	apikeyCore "github.com/mihailtudos/service3/business/core/apikey"
+	auditCore "github.com/mihailtudos/service3/business/core/audit"
+	productCore "github.com/mihailtudos/service3/business/core/product"
+	saleCore "github.com/mihailtudos/service3/business/core/sale"
 	userCore "github.com/mihailtudos/service3/business/core/user"
+	userStore "github.com/mihailtudos/service3/business/data/store/user"
 	"github.com/mihailtudos/service3/business/sys/auth"
+	"github.com/mihailtudos/service3/business/sys/metrics"
+	"github.com/mihailtudos/service3/business/sys/password"
 	"github.com/mihailtudos/service3/business/web/mid"
+	"github.com/mihailtudos/service3/foundation/mail"
+	"github.com/mihailtudos/service3/foundation/oidc"
 	"github.com/mihailtudos/service3/foundation/web"
 	"go.uber.org/zap"
 )
 
 // APIMuxConfig contains all the mandatory systems required by the handlers.
+// When Policy is nil the default mapping of roles to permissions is used,
+// and when Mailer is nil mail is written to the log. Logging in through an
+// OpenID Connect provider is only available when OIDC is set, users
+// created for new identities get OIDCDefaultRoles and OIDCSecureCookies
+// marks the login cookie secure behind a proxy terminating TLS. When
+// PasswordHasher is nil passwords are hashed with bcrypt, and when
+// PasswordPolicy is nil they are checked against the default policy.
 type APIMuxConfig struct {
-	Shutdown chan os.Signal
-	Log      *zap.SugaredLogger
-	Auth     *auth.Auth
-	DB       *sqlx.DB
+	Shutdown          chan os.Signal
+	Log               *zap.SugaredLogger
+	Auth              *auth.Auth
+	Policy            *auth.Policy
+	Mailer            mail.Mailer
+	OIDC              *oidc.Provider
+	OIDCDefaultRoles  []string
+	OIDCSecureCookies bool
+	PasswordHasher    userStore.PasswordHasher
+	PasswordPolicy    *password.Policy
+	DB                *sqlx.DB
+	LegacyErrors      bool
 }
 
 // APIMux constrcuts an http.Handler with all application routes defined.
 func APIMux(cfg APIMuxConfig) *web.App {
+	if cfg.Policy == nil {
+		cfg.Policy = auth.DefaultPolicy()
+	}
+
+	if cfg.Mailer == nil {
+		cfg.Mailer = mail.NewLog(cfg.Log)
+	}
+
+	if cfg.PasswordHasher == nil {
+		cfg.PasswordHasher = password.NewBcrypt()
+	}
+
+	if cfg.PasswordPolicy == nil {
+		p := password.DefaultPolicy()
+		cfg.PasswordPolicy = &p
+	}
+
 	// Construct the web.App which holds all routes as well as common Middleware.
 	app := web.NewApp(
 		cfg.Shutdown,
 		mid.Logger(cfg.Log),
-		mid.Errors(cfg.Log),
 		mid.Metrics(),
+		mid.Errors(cfg.Log, cfg.LegacyErrors),
 		// Panic and recover from panics need to be at the top of the chain
 		mid.Panics(),
 	)
 
+	// Load the routes that live outside of the API versions.
+	wellKnown(app, cfg)
+
 	// Load the routes for the different versions of the API.
 	v1(app, cfg)
 
@@ -75,27 +125,95 @@ func DebugMux(build string, log *zap.SugaredLogger, db *sqlx.DB) http.Handler {
 
 	mux.HandleFunc("/debug/readiness", cgh.Readiness)
 	mux.HandleFunc("/debug/liveness", cgh.Liveness)
+	mux.Handle("/metrics", metrics.Handler(db.Stats))
 
 	return mux
 }
 
+// wellKnown binds the routes found at well-known locations (RFC 8615).
+func wellKnown(app *web.App, cfg APIMuxConfig) {
+	jgh := jwksgrp.Handlers{Auth: cfg.Auth}
+
+	app.Handle(http.MethodGet, "", "/.well-known/jwks.json", jgh.JWKS)
+}
+
 // v1 binds all version 1 routes.
 func v1(app *web.App, cfg APIMuxConfig) {
 	const version = "v1"
 
+	// Machine clients can authenticate with an API key wherever a token is
+	// accepted.
+	akc := apikeyCore.NewCore(cfg.Log, cfg.DB)
+	authen := mid.Authenticate(cfg.Auth, akc)
+
 	tgh := v1TestGrp.Handlers{
 		Log: cfg.Log,
 	}
 
 	app.Handle(http.MethodGet, version, "/test", tgh.Test)
-	app.Handle(http.MethodGet, version, "/testauth", tgh.Test, mid.Authenticate(cfg.Auth), mid.Authorize("ADMIN"))
+	app.Handle(http.MethodGet, version, "/testauth", tgh.Test, authen, mid.Authorize("ADMIN"))
 
-	ugh := v1UserGrp.Handlers{User: userCore.NewCore(cfg.Log, cfg.DB), Auth: cfg.Auth}
+	usc := userCore.NewCore(cfg.Log, cfg.DB, cfg.Policy, cfg.Mailer)
+	usc = usc.WithPasswords(cfg.PasswordHasher, *cfg.PasswordPolicy)
+	ugh := v1UserGrp.Handlers{User: usc, Auth: cfg.Auth}
 
 	app.Handle(http.MethodGet, version, "/users/token", ugh.Token)
-	app.Handle(http.MethodGet, version, "/users/:page/:rows", ugh.Query, mid.Authenticate(cfg.Auth), mid.Authorize(auth.RoleAdmin))
-	app.Handle(http.MethodGet, version, "/users/:id", ugh.QueryByID, mid.Authenticate(cfg.Auth))
-	app.Handle(http.MethodPost, version, "/users", ugh.Create, mid.Authenticate(cfg.Auth), mid.Authorize(auth.RoleAdmin))
-	app.Handle(http.MethodPut, version, "/users/:id", ugh.Update, mid.Authenticate(cfg.Auth), mid.Authorize(auth.RoleAdmin))
-	app.Handle(http.MethodDelete, version, "/users/:id", ugh.Delete, mid.Authenticate(cfg.Auth), mid.Authorize(auth.RoleAdmin))
+	app.Handle(http.MethodPost, version, "/users/token/refresh", ugh.Refresh)
+	app.Handle(http.MethodPost, version, "/users/token/mfa", ugh.TokenMFA)
+
+	if cfg.OIDC != nil {
+		ogh := v1OIDCGrp.Handlers{
+			Provider:      cfg.OIDC,
+			User:          usc,
+			Auth:          cfg.Auth,
+			DefaultRoles:  cfg.OIDCDefaultRoles,
+			SecureCookies: cfg.OIDCSecureCookies,
+		}
+
+		app.Handle(http.MethodGet, version, "/oidc/login", ogh.Login)
+		app.Handle(http.MethodGet, version, "/oidc/callback", ogh.Callback)
+	}
+
+	app.Handle(http.MethodPost, version, "/users/logout", ugh.Logout, authen)
+	app.Handle(http.MethodPost, version, "/users/password/forgot", ugh.ForgotPassword)
+	app.Handle(http.MethodPost, version, "/users/password/reset", ugh.ResetPassword)
+	app.Handle(http.MethodPost, version, "/users/verify", ugh.VerifyEmail)
+	app.Handle(http.MethodGet, version, "/users", ugh.Query, authen, mid.RequirePermission(cfg.Policy, auth.PermUsersRead))
+	app.Handle(http.MethodGet, version, "/users/export", ugh.Export, authen, mid.RequirePermission(cfg.Policy, auth.PermUsersRead))
+	app.Handle(http.MethodPost, version, "/users/import", ugh.Import, authen, mid.RequirePermission(cfg.Policy, auth.PermUsersWrite))
+	app.Handle(http.MethodGet, version, "/users/:id", ugh.QueryByID, authen)
+	app.Handle(http.MethodPost, version, "/users", ugh.Create, authen, mid.RequirePermission(cfg.Policy, auth.PermUsersWrite))
+	app.Handle(http.MethodPut, version, "/users/:id", ugh.Update, authen, mid.RequirePermission(cfg.Policy, auth.PermUsersWrite))
+	app.Handle(http.MethodDelete, version, "/users/:id", ugh.Delete, authen, mid.RequirePermission(cfg.Policy, auth.PermUsersWrite))
+	app.Handle(http.MethodPost, version, "/users/:id/restore", ugh.Restore, authen, mid.RequirePermission(cfg.Policy, auth.PermUsersWrite))
+	app.Handle(http.MethodDelete, version, "/users/:id/purge", ugh.Purge, authen, mid.RequirePermission(cfg.Policy, auth.PermUsersPurge))
+	app.Handle(http.MethodPost, version, "/users/:id/unlock", ugh.Unlock, authen, mid.RequirePermission(cfg.Policy, auth.PermUsersWrite))
+	app.Handle(http.MethodPost, version, "/users/:id/totp", ugh.EnrollTOTP, authen)
+	app.Handle(http.MethodPost, version, "/users/:id/totp/confirm", ugh.ConfirmTOTP, authen)
+	app.Handle(http.MethodDelete, version, "/users/:id/totp", ugh.DisableTOTP, authen)
+
+	pgh := v1ProductGrp.Handlers{Product: productCore.NewCore(cfg.Log, cfg.DB, cfg.Policy)}
+
+	app.Handle(http.MethodGet, version, "/products/:page/:rows", pgh.Query, authen, mid.RequirePermission(cfg.Policy, auth.PermProductsRead))
+	app.Handle(http.MethodGet, version, "/products/:id", pgh.QueryByID, authen, mid.RequirePermission(cfg.Policy, auth.PermProductsRead))
+	app.Handle(http.MethodGet, version, "/users/:id/products", pgh.QueryByUserID, authen, mid.RequirePermission(cfg.Policy, auth.PermProductsRead))
+	app.Handle(http.MethodPost, version, "/products", pgh.Create, authen, mid.RequirePermission(cfg.Policy, auth.PermProductsCreate))
+	app.Handle(http.MethodPut, version, "/products/:id", pgh.Update, authen)
+	app.Handle(http.MethodDelete, version, "/products/:id", pgh.Delete, authen)
+
+	sgh := v1SaleGrp.Handlers{Sale: saleCore.NewCore(cfg.Log, cfg.DB, cfg.Policy)}
+
+	app.Handle(http.MethodPost, version, "/sales", sgh.Create, authen, mid.RequirePermission(cfg.Policy, auth.PermSalesCreate))
+	app.Handle(http.MethodGet, version, "/users/:id/sales", sgh.QueryByUserID, authen)
+	app.Handle(http.MethodGet, version, "/products/:id/sales", sgh.QueryByProductID, authen)
+
+	agh := v1APIKeyGrp.Handlers{APIKey: akc}
+
+	app.Handle(http.MethodPost, version, "/apikeys", agh.Create, authen, mid.RequirePermission(cfg.Policy, auth.PermAPIKeysWrite))
+	app.Handle(http.MethodDelete, version, "/apikeys/:id", agh.Revoke, authen, mid.RequirePermission(cfg.Policy, auth.PermAPIKeysWrite))
+	app.Handle(http.MethodGet, version, "/users/:id/apikeys", agh.QueryByUserID, authen, mid.RequirePermission(cfg.Policy, auth.PermAPIKeysRead))
+
+	adh := v1AuditGrp.Handlers{Audit: auditCore.NewCore(cfg.Log, cfg.DB)}
+
+	app.Handle(http.MethodGet, version, "/audit", adh.Query, authen, mid.RequirePermission(cfg.Policy, auth.PermAuditRead))
 }
@@ -0,0 +1,73 @@
+// Package apikeygrp maintains the group of handlers for API key access.
+package apikeygrp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/mihailtudos/service3/business/data/store/apikey"
+	"github.com/mihailtudos/service3/foundation/web"
+
+	apikeyCore "github.com/mihailtudos/service3/business/core/apikey"
+)
+
+// Handlers manages the set of API key endpoints.
+type Handlers struct {
+	APIKey apikeyCore.Core
+}
+
+// Create issues a new API key. The response is the only time the key itself
+// is sent back, it can't be recovered later.
+func (h Handlers) Create(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	var nak apikey.NewAPIKey
+	if err := web.Decode(r, &nak); err != nil {
+		return fmt.Errorf("unable to decode payload: %w", err)
+	}
+
+	key, ak, err := h.APIKey.Create(ctx, nak, v.Now)
+	if err != nil {
+		return fmt.Errorf("creating new api key, userID[%s]: %w", nak.UserID, err)
+	}
+
+	resp := struct {
+		Key string `json:"key"`
+		apikey.APIKey
+	}{
+		Key:    key,
+		APIKey: ak,
+	}
+
+	return web.Respond(ctx, w, resp, http.StatusCreated)
+}
+
+// QueryByUserID returns the API keys owned by the specified user.
+func (h Handlers) QueryByUserID(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	id := web.Param(r, "id")
+	keys, err := h.APIKey.QueryByUserID(ctx, id)
+	if err != nil {
+		return fmt.Errorf("userID[%s]: %w", id, err)
+	}
+
+	return web.Respond(ctx, w, keys, http.StatusOK)
+}
+
+// Revoke revokes the specified API key so it can't be used anymore.
+func (h Handlers) Revoke(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	id := web.Param(r, "id")
+	if err := h.APIKey.Revoke(ctx, id, v.Now); err != nil {
+		return fmt.Errorf("ID[%s]: %w", id, err)
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
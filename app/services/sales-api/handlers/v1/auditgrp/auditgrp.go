@@ -0,0 +1,60 @@
+// Package auditgrp maintains the group of handlers for reading the audit log.
+package auditgrp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/mihailtudos/service3/business/sys/validate"
+	"github.com/mihailtudos/service3/foundation/web"
+
+	auditCore "github.com/mihailtudos/service3/business/core/audit"
+)
+
+// Set of limits for the number of events returned in a single page.
+const (
+	defaultRowsPerPage = 50
+	maxRowsPerPage     = 500
+)
+
+// Handlers manages the set of audit endpoints.
+type Handlers struct {
+	Audit auditCore.Core
+}
+
+// Query returns a page of audit events, newest first, matching the filter
+// provided in the query string. The response is a web.Page carrying the
+// cursor for the next page, if any, and the total number of matching events.
+func (h Handlers) Query(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	values := r.URL.Query()
+
+	rowsPerPage := defaultRowsPerPage
+	if rows := values.Get("rows"); rows != "" {
+		n, err := strconv.Atoi(rows)
+		if err != nil || n < 1 || n > maxRowsPerPage {
+			return validate.NewRequestError(fmt.Errorf("invalid rows per page value: [%s]", rows), http.StatusBadRequest)
+		}
+		rowsPerPage = n
+	}
+
+	filter, err := parseFilter(values)
+	if err != nil {
+		return validate.NewRequestError(err, http.StatusBadRequest)
+	}
+
+	events, next, err := h.Audit.Query(ctx, filter, values.Get("cursor"), rowsPerPage)
+	if err != nil {
+		return fmt.Errorf("unable to query for events: %w", err)
+	}
+
+	total, err := h.Audit.Count(ctx, filter)
+	if err != nil {
+		return fmt.Errorf("unable to count events: %w", err)
+	}
+
+	page := web.NewCursorPage(events, rowsPerPage, total, next)
+
+	return web.RespondPage(ctx, w, r, page, http.StatusOK)
+}
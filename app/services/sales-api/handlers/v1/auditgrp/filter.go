@@ -0,0 +1,49 @@
+package auditgrp
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/mihailtudos/service3/business/data/store/audit"
+)
+
+// parseFilter constructs an audit.QueryFilter from the query string.
+// Parameters that are not present are left nil so they are not applied.
+func parseFilter(values url.Values) (audit.QueryFilter, error) {
+	var filter audit.QueryFilter
+
+	if actor := values.Get("actor_id"); actor != "" {
+		filter.ActorID = &actor
+	}
+
+	if action := values.Get("action"); action != "" {
+		filter.Action = &action
+	}
+
+	if targetType := values.Get("target_type"); targetType != "" {
+		filter.TargetType = &targetType
+	}
+
+	if target := values.Get("target_id"); target != "" {
+		filter.TargetID = &target
+	}
+
+	if from := values.Get("from"); from != "" {
+		t, err := time.Parse(time.RFC3339, from)
+		if err != nil {
+			return audit.QueryFilter{}, fmt.Errorf("invalid from value: [%s]", from)
+		}
+		filter.From = &t
+	}
+
+	if to := values.Get("to"); to != "" {
+		t, err := time.Parse(time.RFC3339, to)
+		if err != nil {
+			return audit.QueryFilter{}, fmt.Errorf("invalid to value: [%s]", to)
+		}
+		filter.To = &t
+	}
+
+	return filter, nil
+}
@@ -0,0 +1,146 @@
+// Package oidcgrp maintains the group of handlers for logging in through an
+// OpenID Connect provider.
+package oidcgrp
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mihailtudos/service3/business/sys/auth"
+	"github.com/mihailtudos/service3/business/sys/validate"
+	"github.com/mihailtudos/service3/foundation/oidc"
+	"github.com/mihailtudos/service3/foundation/web"
+
+	userCore "github.com/mihailtudos/service3/business/core/user"
+)
+
+// Set of settings for the cookie carrying the login between the redirect to
+// the provider and the callback.
+const (
+	loginCookie = "oidc_login"
+	loginTTL    = 10 * time.Minute
+)
+
+// Handlers manages the set of OpenID Connect endpoints. SecureCookies marks
+// the login cookie secure even when the request didn't arrive over TLS, for
+// services behind a proxy terminating TLS.
+type Handlers struct {
+	Provider      *oidc.Provider
+	User          userCore.Core
+	Auth          *auth.Auth
+	DefaultRoles  []string
+	SecureCookies bool
+}
+
+// Login sends the user to the provider to log in. The state, nonce and PKCE
+// verifier of the login are kept in a cookie to check the callback against.
+func (h Handlers) Login(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var values [3]string
+	for i := range values {
+		v, err := oidc.RandomString()
+		if err != nil {
+			return err
+		}
+		values[i] = v
+	}
+	state, nonce, verifier := values[0], values[1], values[2]
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     loginCookie,
+		Value:    strings.Join(values[:], "."),
+		Path:     "/v1/oidc",
+		MaxAge:   int(loginTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   h.SecureCookies || r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return web.Redirect(ctx, w, r, h.Provider.AuthCodeURL(state, nonce, verifier), http.StatusFound)
+}
+
+// Callback completes the login once the provider sends the user back, and
+// provides an API token. Users with a second factor only get a challenge to
+// complete with a code, like a login with a password, since the provider
+// can't vouch for the second factor.
+func (h Handlers) Callback(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		err := fmt.Errorf("provider refused the login: %s", e)
+		return validate.NewRequestError(err, http.StatusUnauthorized)
+	}
+
+	cookie, err := r.Cookie(loginCookie)
+	if err != nil {
+		return validate.NewRequestError(errors.New("no login in progress"), http.StatusBadRequest)
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     loginCookie,
+		Path:     "/v1/oidc",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   h.SecureCookies || r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	values := strings.Split(cookie.Value, ".")
+	if len(values) != 3 || subtle.ConstantTimeCompare([]byte(values[0]), []byte(q.Get("state"))) != 1 {
+		return validate.NewRequestError(errors.New("login state doesn't match"), http.StatusBadRequest)
+	}
+	nonce, verifier := values[1], values[2]
+
+	id, err := h.Provider.Exchange(ctx, q.Get("code"), verifier, nonce)
+	if err != nil {
+		return validate.NewRequestError(fmt.Errorf("completing login: %w", err), http.StatusUnauthorized)
+	}
+
+	claims, err := h.User.AuthenticateIdentity(ctx, id, h.DefaultRoles, v.Now)
+	if err != nil {
+		return fmt.Errorf("authenticating identity: %w", err)
+	}
+
+	challenge, err := h.User.Challenge(ctx, claims.Subject, v.Now)
+	if err != nil {
+		return fmt.Errorf("issuing challenge: %w", err)
+	}
+
+	if challenge != "" {
+		resp := struct {
+			Challenge string `json:"challenge"`
+			ExpiresIn int    `json:"expires_in"`
+		}{
+			Challenge: challenge,
+			ExpiresIn: int(userCore.ChallengeTTL.Seconds()),
+		}
+
+		return web.Respond(ctx, w, resp, http.StatusAccepted)
+	}
+
+	refresh, err := h.User.IssueRefreshToken(ctx, claims.Subject, v.Now)
+	if err != nil {
+		return fmt.Errorf("issuing refresh token: %w", err)
+	}
+
+	var tkn struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	tkn.Token, err = h.Auth.GenerateToken(claims)
+	if err != nil {
+		return fmt.Errorf("generating token: %w", err)
+	}
+	tkn.RefreshToken = refresh
+
+	return web.Respond(ctx, w, tkn, http.StatusOK)
+}
@@ -0,0 +1,131 @@
+// Package productgrp maintains the group of handlers for product access.
+package productgrp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/mihailtudos/service3/business/data/store/product"
+	"github.com/mihailtudos/service3/business/sys/validate"
+	"github.com/mihailtudos/service3/foundation/web"
+
+	productCore "github.com/mihailtudos/service3/business/core/product"
+	"github.com/mihailtudos/service3/business/sys/auth"
+)
+
+// Handlers manages the set of product endpoints.
+type Handlers struct {
+	Product productCore.Core
+}
+
+// Query returns a list of products with paging.
+func (h Handlers) Query(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	page := web.Param(r, "page")
+	pageNumber, err := strconv.Atoi(page)
+	if err != nil {
+		return validate.NewRequestError(fmt.Errorf("invalid page number value: [%s]", page), http.StatusBadRequest)
+	}
+
+	rows := web.Param(r, "rows")
+	rowsPerPage, err := strconv.Atoi(rows)
+	if err != nil {
+		return validate.NewRequestError(fmt.Errorf("invalid rows per page value: [%s]", rows), http.StatusBadRequest)
+	}
+
+	prds, err := h.Product.Query(ctx, pageNumber, rowsPerPage)
+	if err != nil {
+		return fmt.Errorf("unable to query for products: %w", err)
+	}
+
+	return web.Respond(ctx, w, prds, http.StatusOK)
+}
+
+// QueryByID returns a product by its ID.
+func (h Handlers) QueryByID(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	id := web.Param(r, "id")
+	prd, err := h.Product.QueryByID(ctx, id)
+	if err != nil {
+		return fmt.Errorf("ID[%s]: %w", id, err)
+	}
+
+	return web.Respond(ctx, w, prd, http.StatusOK)
+}
+
+// QueryByUserID returns the products owned by the specified user.
+func (h Handlers) QueryByUserID(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	id := web.Param(r, "id")
+	prds, err := h.Product.QueryByUserID(ctx, id)
+	if err != nil {
+		return fmt.Errorf("userID[%s]: %w", id, err)
+	}
+
+	return web.Respond(ctx, w, prds, http.StatusOK)
+}
+
+// Create adds a new product to the system.
+func (h Handlers) Create(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, err := auth.GetClaims(ctx)
+	if err != nil {
+		return errors.New("claims missing from context")
+	}
+
+	var np product.NewProduct
+	if err := web.Decode(r, &np); err != nil {
+		return fmt.Errorf("unable to decode payload: %w", err)
+	}
+
+	prd, err := h.Product.Create(ctx, claims, np, v.Now)
+	if err != nil {
+		return fmt.Errorf("creating new product, np[%+v]: %w", np, err)
+	}
+
+	return web.Respond(ctx, w, prd, http.StatusCreated)
+}
+
+// Update updates a product in the system.
+func (h Handlers) Update(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, err := auth.GetClaims(ctx)
+	if err != nil {
+		return errors.New("claims missing from context")
+	}
+
+	var upd product.UpdateProduct
+	if err := web.Decode(r, &upd); err != nil {
+		return fmt.Errorf("unable to decode payload: %w", err)
+	}
+
+	id := web.Param(r, "id")
+	if err := h.Product.Update(ctx, claims, id, upd, v.Now); err != nil {
+		return fmt.Errorf("ID[%s]: Product[%+v]: %w", id, &upd, err)
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// Delete removes a product from the system.
+func (h Handlers) Delete(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	claims, err := auth.GetClaims(ctx)
+	if err != nil {
+		return errors.New("claims missing from context")
+	}
+
+	id := web.Param(r, "id")
+	if err := h.Product.Delete(ctx, claims, id); err != nil {
+		return fmt.Errorf("ID[%s]: %w", id, err)
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
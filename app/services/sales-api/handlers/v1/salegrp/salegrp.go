@@ -0,0 +1,77 @@
+// Package salegrp maintains the group of handlers for sale access.
+package salegrp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/mihailtudos/service3/business/data/store/sale"
+	"github.com/mihailtudos/service3/foundation/web"
+
+	saleCore "github.com/mihailtudos/service3/business/core/sale"
+	"github.com/mihailtudos/service3/business/sys/auth"
+)
+
+// Handlers manages the set of sale endpoints.
+type Handlers struct {
+	Sale saleCore.Core
+}
+
+// Create records a new sale for the authenticated user.
+func (h Handlers) Create(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, err := auth.GetClaims(ctx)
+	if err != nil {
+		return errors.New("claims missing from context")
+	}
+
+	var ns sale.NewSale
+	if err := web.Decode(r, &ns); err != nil {
+		return fmt.Errorf("unable to decode payload: %w", err)
+	}
+
+	sl, err := h.Sale.Create(ctx, claims, ns, v.Now)
+	if err != nil {
+		return fmt.Errorf("creating new sale, ns[%+v]: %w", ns, err)
+	}
+
+	return web.Respond(ctx, w, sl, http.StatusCreated)
+}
+
+// QueryByUserID returns the sales made by the specified user.
+func (h Handlers) QueryByUserID(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	claims, err := auth.GetClaims(ctx)
+	if err != nil {
+		return errors.New("claims missing from context")
+	}
+
+	id := web.Param(r, "id")
+	sales, err := h.Sale.QueryByUserID(ctx, claims, id)
+	if err != nil {
+		return fmt.Errorf("userID[%s]: %w", id, err)
+	}
+
+	return web.Respond(ctx, w, sales, http.StatusOK)
+}
+
+// QueryByProductID returns the sales recorded against the specified product.
+func (h Handlers) QueryByProductID(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	claims, err := auth.GetClaims(ctx)
+	if err != nil {
+		return errors.New("claims missing from context")
+	}
+
+	id := web.Param(r, "id")
+	sales, err := h.Sale.QueryByProductID(ctx, claims, id)
+	if err != nil {
+		return fmt.Errorf("productID[%s]: %w", id, err)
+	}
+
+	return web.Respond(ctx, w, sales, http.StatusOK)
+}
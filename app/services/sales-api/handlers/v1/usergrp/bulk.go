@@ -0,0 +1,255 @@
+package usergrp
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mihailtudos/service3/business/data/store/user"
+	"github.com/mihailtudos/service3/business/sys/auth"
+	"github.com/mihailtudos/service3/business/sys/validate"
+	"github.com/mihailtudos/service3/foundation/web"
+)
+
+// Set of formats users can be exported and imported in.
+const (
+	formatNDJSON = "ndjson"
+	formatCSV    = "csv"
+)
+
+// Set of content types of the formats.
+const (
+	contentTypeNDJSON = "application/x-ndjson"
+	contentTypeCSV    = "text/csv"
+)
+
+// Set of limits on imports. Lines are capped to keep a single row from
+// holding the whole body in memory.
+const (
+	maxImportLine = 64 * 1024
+	maxImportRows = 10_000
+)
+
+// errTooManyRows stops an import with more rows than allowed.
+var errTooManyRows = fmt.Errorf("more than %d rows", maxImportRows)
+
+// csvHeader lists the columns of a CSV export. Roles are joined with ";".
+var csvHeader = []string{"id", "name", "email", "email_verified", "roles", "date_created", "date_updated"}
+
+// Export streams every user matching the filter in the query string as
+// NDJSON, one user per line, or as CSV. The format is picked with the format
+// parameter, or else the Accept header.
+func (h Handlers) Export(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	values := r.URL.Query()
+
+	format, err := parseFormat(values.Get("format"), r.Header.Get("Accept"))
+	if err != nil {
+		return validate.NewRequestError(err, http.StatusBadRequest)
+	}
+
+	filter, err := parseFilter(values)
+	if err != nil {
+		return validate.NewRequestError(err, http.StatusBadRequest)
+	}
+
+	if format == formatCSV {
+		return web.RespondStream(ctx, w, contentTypeCSV, http.StatusOK, func(out io.Writer) error {
+			cw := csv.NewWriter(out)
+			if err := cw.Write(csvHeader); err != nil {
+				return err
+			}
+
+			err := h.User.Export(ctx, filter, func(usr user.User) error {
+				return cw.Write([]string{
+					usr.ID,
+					usr.Name,
+					usr.Email,
+					strconv.FormatBool(usr.EmailVerified),
+					strings.Join(usr.Roles, ";"),
+					usr.DateCreated.Format(time.RFC3339),
+					usr.DateUpdated.Format(time.RFC3339),
+				})
+			})
+			cw.Flush()
+
+			return errors.Join(err, cw.Error())
+		})
+	}
+
+	return web.RespondStream(ctx, w, contentTypeNDJSON, http.StatusOK, func(out io.Writer) error {
+		bw := bufio.NewWriter(out)
+		enc := json.NewEncoder(bw)
+
+		err := h.User.Export(ctx, filter, func(usr user.User) error {
+			return enc.Encode(usr)
+		})
+
+		return errors.Join(err, bw.Flush())
+	})
+}
+
+// Import creates the users streamed in the body as NDJSON, one user per line,
+// or as CSV with a header naming the name, email, roles and password columns.
+// The format is picked with the format parameter, or else the Content-Type
+// header. Rows are checked like a single create and the response reports the
+// outcome of every row. With dry_run=true nothing is saved.
+func (h Handlers) Import(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, err := auth.GetClaims(ctx)
+	if err != nil {
+		return errors.New("claims missing from context")
+	}
+
+	values := r.URL.Query()
+
+	format, err := parseFormat(values.Get("format"), r.Header.Get("Content-Type"))
+	if err != nil {
+		return validate.NewRequestError(err, http.StatusBadRequest)
+	}
+
+	var dryRun bool
+	if s := values.Get("dry_run"); s != "" {
+		if dryRun, err = strconv.ParseBool(s); err != nil {
+			return validate.NewRequestError(fmt.Errorf("invalid dry_run value: [%s]", s), http.StatusBadRequest)
+		}
+	}
+
+	var next func() (user.NewUser, error)
+	switch format {
+	case formatCSV:
+		next, err = csvRows(r.Body)
+	default:
+		next = ndjsonRows(r.Body)
+	}
+	if err != nil {
+		return validate.NewRequestError(err, http.StatusBadRequest)
+	}
+
+	rows := 0
+	limited := func() (user.NewUser, error) {
+		if rows++; rows > maxImportRows {
+			return user.NewUser{}, errTooManyRows
+		}
+		return next()
+	}
+
+	// Batches committed before an import is stopped are kept even though
+	// the request fails.
+	report, err := h.User.Import(ctx, claims, limited, dryRun, v.Now)
+	if err != nil {
+		if errors.Is(err, errTooManyRows) || errors.Is(err, bufio.ErrTooLong) {
+			return validate.NewRequestError(err, http.StatusBadRequest)
+		}
+		return fmt.Errorf("importing users: %w", err)
+	}
+
+	return web.Respond(ctx, w, report, http.StatusOK)
+}
+
+// parseFormat picks the format from the format parameter, falling back on the
+// media type in the header.
+func parseFormat(param string, header string) (string, error) {
+	switch param {
+	case formatNDJSON, formatCSV:
+		return param, nil
+	case "":
+		if strings.Contains(header, contentTypeCSV) {
+			return formatCSV, nil
+		}
+		return formatNDJSON, nil
+	default:
+		return "", fmt.Errorf("invalid format value: [%s]", param)
+	}
+}
+
+// ndjsonRows reads a user from every non-blank line. A line that isn't a
+// valid user fails only that row.
+func ndjsonRows(r io.Reader) func() (user.NewUser, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), maxImportLine)
+
+	return func() (user.NewUser, error) {
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+
+			var nu user.NewUser
+			if err := json.Unmarshal([]byte(line), &nu); err != nil {
+				return user.NewUser{}, validate.FieldErrors{{Field: "row", Error: err.Error()}}
+			}
+			if nu.PasswordConfirm == "" {
+				nu.PasswordConfirm = nu.Password
+			}
+
+			return nu, nil
+		}
+
+		if err := scanner.Err(); err != nil {
+			return user.NewUser{}, err
+		}
+
+		return user.NewUser{}, io.EOF
+	}
+}
+
+// csvRows reads the header and then a user from every record. Roles are
+// separated with ";". A record with the wrong number of fields fails only
+// that row.
+func csvRows(r io.Reader) (func() (user.NewUser, error), error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading csv header: %w", err)
+	}
+
+	cols := make(map[string]int)
+	for i, name := range header {
+		cols[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{"name", "email", "roles", "password"} {
+		if _, exists := cols[name]; !exists {
+			return nil, fmt.Errorf("csv header is missing the %s column", name)
+		}
+	}
+
+	next := func() (user.NewUser, error) {
+		record, err := cr.Read()
+		if err != nil {
+			var perr *csv.ParseError
+			if errors.As(err, &perr) {
+				return user.NewUser{}, validate.FieldErrors{{Field: "row", Error: perr.Err.Error()}}
+			}
+			return user.NewUser{}, err
+		}
+
+		nu := user.NewUser{
+			Name:     record[cols["name"]],
+			Email:    record[cols["email"]],
+			Password: record[cols["password"]],
+		}
+		nu.PasswordConfirm = nu.Password
+		if roles := record[cols["roles"]]; roles != "" {
+			nu.Roles = strings.Split(roles, ";")
+		}
+
+		return nu, nil
+	}
+
+	return next, nil
+}
@@ -0,0 +1,84 @@
+package usergrp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/mihailtudos/service3/business/data/store/user"
+	"github.com/mihailtudos/service3/business/sys/auth"
+	"github.com/mihailtudos/service3/business/sys/database"
+	"github.com/mihailtudos/service3/business/sys/validate"
+)
+
+// etag returns the entity tag for the specified version of a user.
+func etag(version int) string {
+	return strconv.Quote(strconv.Itoa(version))
+}
+
+// ifMatch returns the versions of the user the client expects to change,
+// read from the If-Match header, a comma separated list of tags (RFC 9110
+// section 13.1.1). The header is required so clients can't overwrite changes
+// they haven't seen; "*" explicitly matches any version. Weak tags are
+// accepted since proxies compressing a response weaken its tag. Tags that
+// aren't one of ours can never match, and when none are left the
+// precondition fails.
+func ifMatch(r *http.Request) ([]int, error) {
+	header := strings.TrimSpace(strings.Join(r.Header.Values("If-Match"), ","))
+
+	switch header {
+	case "":
+		err := errors.New("If-Match header is required")
+		return nil, validate.NewRequestError(err, http.StatusPreconditionRequired)
+	case "*":
+		return []int{user.AnyVersion}, nil
+	}
+
+	var versions []int
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+
+		s, err := strconv.Unquote(tag)
+		if err != nil {
+			continue
+		}
+
+		version, err := strconv.Atoi(s)
+		if err != nil || version < 1 {
+			continue
+		}
+
+		versions = append(versions, version)
+	}
+
+	if len(versions) == 0 {
+		return nil, validate.NewRequestError(database.ErrConflict, http.StatusPreconditionFailed)
+	}
+
+	return versions, nil
+}
+
+// matchVersion returns the version of the user to check a change against,
+// from the versions the client listed. A single version is left for the
+// store to check. Out of several, the current version is used when it is one
+// of them, and the store still catches a change made in the meantime.
+func (h Handlers) matchVersion(ctx context.Context, claims auth.Claims, userID string, versions []int) (int, error) {
+	if len(versions) == 1 {
+		return versions[0], nil
+	}
+
+	usr, err := h.User.QueryByID(ctx, claims, userID)
+	if err != nil {
+		return 0, fmt.Errorf("querying user: %w", err)
+	}
+
+	if !slices.Contains(versions, usr.Version) {
+		return 0, validate.NewRequestError(database.ErrConflict, http.StatusPreconditionFailed)
+	}
+
+	return usr.Version, nil
+}
@@ -0,0 +1,45 @@
+package usergrp
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/mihailtudos/service3/business/data/store/user"
+)
+
+// parseFilter constructs a user.QueryFilter from the query string. Parameters
+// that are not present are left nil so they are not applied.
+func parseFilter(values url.Values) (user.QueryFilter, error) {
+	var filter user.QueryFilter
+
+	if name := values.Get("name"); name != "" {
+		filter.Name = &name
+	}
+
+	if email := values.Get("email"); email != "" {
+		filter.Email = &email
+	}
+
+	if role := values.Get("role"); role != "" {
+		filter.Role = &role
+	}
+
+	if after := values.Get("created_after"); after != "" {
+		t, err := time.Parse(time.RFC3339, after)
+		if err != nil {
+			return user.QueryFilter{}, fmt.Errorf("invalid created_after value: [%s]", after)
+		}
+		filter.CreatedAfter = &t
+	}
+
+	if before := values.Get("created_before"); before != "" {
+		t, err := time.Parse(time.RFC3339, before)
+		if err != nil {
+			return user.QueryFilter{}, fmt.Errorf("invalid created_before value: [%s]", before)
+		}
+		filter.CreatedBefore = &t
+	}
+
+	return filter, nil
+}
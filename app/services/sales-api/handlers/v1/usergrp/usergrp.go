@@ -5,10 +5,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/mihailtudos/service3/business/data/order"
 	"github.com/mihailtudos/service3/business/data/store/user"
 	"github.com/mihailtudos/service3/business/sys/database"
 	"github.com/mihailtudos/service3/business/sys/validate"
 	"github.com/mihailtudos/service3/foundation/web"
+	"math"
+	"net"
 	"net/http"
 	"strconv"
 
@@ -16,30 +19,56 @@ import (
 	"github.com/mihailtudos/service3/business/sys/auth"
 )
 
+// Set of limits for the number of users returned in a single page.
+const (
+	defaultRowsPerPage = 20
+	maxRowsPerPage     = 100
+)
+
 // Handlers manages the set of user endpoints.
 type Handlers struct {
 	User userCore.Core
 	Auth *auth.Auth
 }
 
+// Query returns a page of users matching the filter provided in the query
+// string. The response is a web.Page carrying the cursor for the next page,
+// if any, and the total number of matching users.
 func (h Handlers) Query(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
-	page := web.Param(r, "page")
-	pageNumber, err := strconv.Atoi(page)
+	values := r.URL.Query()
+
+	rowsPerPage := defaultRowsPerPage
+	if rows := values.Get("rows"); rows != "" {
+		n, err := strconv.Atoi(rows)
+		if err != nil || n < 1 || n > maxRowsPerPage {
+			return validate.NewRequestError(fmt.Errorf("invalid rows per page value: [%s]", rows), http.StatusBadRequest)
+		}
+		rowsPerPage = n
+	}
+
+	filter, err := parseFilter(values)
 	if err != nil {
-		return validate.NewRequestError(fmt.Errorf("invalid page number value: [%s]", page), http.StatusBadRequest)
+		return validate.NewRequestError(err, http.StatusBadRequest)
 	}
 
-	rows := web.Param(r, "rows")
-	rowsPerPage, err := strconv.Atoi(rows)
+	orderBy, err := order.Parse(values.Get("order_by"), user.OrderByFields, user.DefaultOrderBy)
 	if err != nil {
-		return validate.NewRequestError(fmt.Errorf("invalid rows per page value: [%s]", page), http.StatusBadRequest)
+		return validate.NewRequestError(err, http.StatusBadRequest)
 	}
 
-	users, err := h.User.Query(ctx, pageNumber, rowsPerPage)
+	users, next, err := h.User.Query(ctx, filter, orderBy, values.Get("cursor"), rowsPerPage)
 	if err != nil {
 		return fmt.Errorf("unable to query for users: %w", err)
 	}
-	return web.Respond(ctx, w, users, http.StatusOK)
+
+	total, err := h.User.Count(ctx, filter)
+	if err != nil {
+		return fmt.Errorf("unable to count users: %w", err)
+	}
+
+	page := web.NewCursorPage(users, rowsPerPage, total, next)
+
+	return web.RespondPage(ctx, w, r, page, http.StatusOK)
 }
 
 func (h Handlers) QueryByID(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
@@ -51,18 +80,11 @@ func (h Handlers) QueryByID(ctx context.Context, w http.ResponseWriter, r *http.
 	id := web.Param(r, "id")
 	usr, err := h.User.QueryByID(ctx, claims, id)
 	if err != nil {
-		switch validate.Cause(err) {
-		case database.ErrInvalidID:
-			return validate.NewRequestError(err, http.StatusBadRequest)
-		case database.ErrNotFound:
-			return validate.NewRequestError(err, http.StatusNotFound)
-		case database.ErrForbidden:
-			return validate.NewRequestError(err, http.StatusForbidden)
-		default:
-			return fmt.Errorf("ID[%s]: %w", id, err)
-		}
+		return fmt.Errorf("ID[%s]: %w", id, err)
 	}
 
+	w.Header().Set("ETag", etag(usr.Version))
+
 	return web.Respond(ctx, w, usr, http.StatusOK)
 }
 
@@ -73,16 +95,23 @@ func (h Handlers) Create(ctx context.Context, w http.ResponseWriter, r *http.Req
 		return web.NewShutdownError("web value missing from context")
 	}
 
+	claims, err := auth.GetClaims(ctx)
+	if err != nil {
+		return errors.New("claims missing from context")
+	}
+
 	var nu user.NewUser
 	if err := web.Decode(r, &nu); err != nil {
 		return fmt.Errorf("unable to decode payload: %w", err)
 	}
 
-	usr, err := h.User.Create(ctx, nu, v.Now)
+	usr, err := h.User.Create(ctx, claims, nu, v.Now)
 	if err != nil {
 		return fmt.Errorf("user [%+v]: %w", &usr, err)
 	}
 
+	w.Header().Set("ETag", etag(usr.Version))
+
 	return web.Respond(ctx, w, usr, http.StatusCreated)
 }
 
@@ -103,42 +132,190 @@ func (h Handlers) Update(ctx context.Context, w http.ResponseWriter, r *http.Req
 		return fmt.Errorf("unable to decode payload: %w", err)
 	}
 
+	versions, err := ifMatch(r)
+	if err != nil {
+		return err
+	}
+
 	id := web.Param(r, "id")
 
-	if err := h.User.Update(ctx, claims, id, upd, v.Now); err != nil {
-		switch validate.Cause(err) {
-		case database.ErrInvalidID:
-			return validate.NewRequestError(err, http.StatusBadRequest)
-		case database.ErrNotFound:
-			return validate.NewRequestError(err, http.StatusNotFound)
-		case database.ErrForbidden:
-			return validate.NewRequestError(err, http.StatusForbidden)
-		default:
-			return fmt.Errorf("ID[%s]: User[%+v]: %w", id, &upd, err)
-		}
+	version, err := h.matchVersion(ctx, claims, id, versions)
+	if err != nil {
+		return fmt.Errorf("ID[%s]: %w", id, err)
+	}
+
+	usr, err := h.User.Update(ctx, claims, id, version, upd, v.Now)
+	if err != nil {
+		return fmt.Errorf("ID[%s]: User[%+v]: %w", id, &upd, err)
 	}
 
+	w.Header().Set("ETag", etag(usr.Version))
+
 	return web.Respond(ctx, w, nil, http.StatusCreated)
 }
 
 // Delete removes a user from the system.
 func (h Handlers) Delete(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
 	claims, err := auth.GetClaims(ctx)
 	if err != nil {
 		return errors.New("claims missing from context")
 	}
+	versions, err := ifMatch(r)
+	if err != nil {
+		return err
+	}
+
 	id := web.Param(r, "id")
-	if err := h.User.Delete(ctx, claims, id); err != nil {
-		switch validate.Cause(err) {
-		case database.ErrInvalidID:
-			return validate.NewRequestError(err, http.StatusBadRequest)
-		case database.ErrNotFound:
-			return validate.NewRequestError(err, http.StatusNotFound)
-		case database.ErrForbidden:
-			return validate.NewRequestError(err, http.StatusForbidden)
-		default:
-			return fmt.Errorf("ID[%s]: %w", id, err)
-		}
+
+	version, err := h.matchVersion(ctx, claims, id, versions)
+	if err != nil {
+		return fmt.Errorf("ID[%s]: %w", id, err)
+	}
+
+	if err := h.User.Delete(ctx, claims, id, version, v.Now); err != nil {
+		return fmt.Errorf("ID[%s]: %w", id, err)
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// Restore brings back a deleted user.
+func (h Handlers) Restore(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, err := auth.GetClaims(ctx)
+	if err != nil {
+		return errors.New("claims missing from context")
+	}
+
+	id := web.Param(r, "id")
+	if err := h.User.Restore(ctx, claims, id, v.Now); err != nil {
+		return fmt.Errorf("ID[%s]: %w", id, err)
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// Purge removes a deleted user for good, along with its products and sales.
+func (h Handlers) Purge(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, err := auth.GetClaims(ctx)
+	if err != nil {
+		return errors.New("claims missing from context")
+	}
+
+	id := web.Param(r, "id")
+	if err := h.User.Purge(ctx, claims, id, v.Now); err != nil {
+		return fmt.Errorf("ID[%s]: %w", id, err)
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// Unlock lifts a lockout caused by too many failed logins on the user's
+// account.
+func (h Handlers) Unlock(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, err := auth.GetClaims(ctx)
+	if err != nil {
+		return errors.New("claims missing from context")
+	}
+
+	id := web.Param(r, "id")
+	if err := h.User.Unlock(ctx, claims, id, v.Now); err != nil {
+		return fmt.Errorf("ID[%s]: %w", id, err)
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// ForgotPassword mails a password reset token to the user with the email.
+// The response is the same whether or not the email has an account.
+func (h Handlers) ForgotPassword(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	var req struct {
+		Email string `json:"email" validate:"required,email"`
+	}
+	if err := web.Decode(r, &req); err != nil {
+		return validate.NewRequestError(fmt.Errorf("unable to decode payload: %w", err), http.StatusBadRequest)
+	}
+	if err := validate.Check(req); err != nil {
+		return fmt.Errorf("validating data: %w", err)
+	}
+
+	if err := h.User.ForgotPassword(ctx, req.Email, v.Now); err != nil {
+		return fmt.Errorf("forgot password: %w", err)
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusAccepted)
+}
+
+// ResetPassword sets a new password using a password reset token.
+func (h Handlers) ResetPassword(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	var req struct {
+		Token           string `json:"token" validate:"required"`
+		Password        string `json:"password" validate:"required"`
+		PasswordConfirm string `json:"password_confirm" validate:"eqfield=Password"`
+	}
+	if err := web.Decode(r, &req); err != nil {
+		return validate.NewRequestError(fmt.Errorf("unable to decode payload: %w", err), http.StatusBadRequest)
+	}
+	if err := validate.Check(req); err != nil {
+		return fmt.Errorf("validating data: %w", err)
+	}
+
+	if err := h.User.ResetPassword(ctx, req.Token, req.Password, v.Now); err != nil {
+		return fmt.Errorf("reset password: %w", err)
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// VerifyEmail marks the email of a user as verified using a verification
+// token.
+func (h Handlers) VerifyEmail(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	var req struct {
+		Token string `json:"token" validate:"required"`
+	}
+	if err := web.Decode(r, &req); err != nil {
+		return validate.NewRequestError(fmt.Errorf("unable to decode payload: %w", err), http.StatusBadRequest)
+	}
+	if err := validate.Check(req); err != nil {
+		return fmt.Errorf("validating data: %w", err)
+	}
+
+	if err := h.User.VerifyEmail(ctx, req.Token, v.Now); err != nil {
+		return fmt.Errorf("verify email: %w", err)
 	}
 
 	return web.Respond(ctx, w, nil, http.StatusNoContent)
@@ -157,26 +334,276 @@ func (h Handlers) Token(ctx context.Context, w http.ResponseWriter, r *http.Requ
 		return validate.NewRequestError(err, http.StatusUnauthorized)
 	}
 
-	claims, err := h.User.Authenticate(ctx, v.Now, email, pass)
+	claims, err := h.User.Authenticate(ctx, v.Now, email, pass, sourceIP(r))
+	if err != nil {
+		var locked *userCore.LockedError
+		if errors.As(err, &locked) {
+			return tooManyAttempts(w, locked)
+		}
+
+		// Every way the credentials can be wrong gets the same response so
+		// callers can't tell which accounts exist.
+		if errors.Is(err, database.ErrNotFound) || errors.Is(err, database.ErrInvalidID) {
+			return database.ErrAuthenticationFailed
+		}
+		return fmt.Errorf("authenticating: %w", err)
+	}
+
+	// Users with a second factor only get a challenge to complete with a
+	// code through TokenMFA.
+	challenge, err := h.User.Challenge(ctx, claims.Subject, v.Now)
 	if err != nil {
-		switch validate.Cause(err) {
-		case database.ErrNotFound:
-			return validate.NewRequestError(err, http.StatusNotFound)
-		case database.ErrAuthenticationFailed:
+		return fmt.Errorf("issuing challenge: %w", err)
+	}
 
-			return validate.NewRequestError(err, http.StatusUnauthorized)
-		default:
-			return fmt.Errorf("authenticating: %w", err)
+	if challenge != "" {
+		resp := struct {
+			Challenge string `json:"challenge"`
+			ExpiresIn int    `json:"expires_in"`
+		}{
+			Challenge: challenge,
+			ExpiresIn: int(userCore.ChallengeTTL.Seconds()),
 		}
+
+		return web.Respond(ctx, w, resp, http.StatusAccepted)
+	}
+
+	refresh, err := h.User.IssueRefreshToken(ctx, claims.Subject, v.Now)
+	if err != nil {
+		return fmt.Errorf("issuing refresh token: %w", err)
+	}
+
+	return h.respondToken(ctx, w, claims, refresh)
+}
+
+// TokenMFA completes a login challenge with a TOTP or recovery code and
+// provides an API token.
+func (h Handlers) TokenMFA(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	var req struct {
+		Challenge string `json:"challenge" validate:"required"`
+		Code      string `json:"code" validate:"required"`
+	}
+	if err := web.Decode(r, &req); err != nil {
+		return validate.NewRequestError(fmt.Errorf("unable to decode payload: %w", err), http.StatusBadRequest)
+	}
+	if err := validate.Check(req); err != nil {
+		return fmt.Errorf("validating data: %w", err)
+	}
+
+	claims, err := h.User.CompleteChallenge(ctx, req.Challenge, req.Code, sourceIP(r), v.Now)
+	if err != nil {
+		var locked *userCore.LockedError
+		if errors.As(err, &locked) {
+			return tooManyAttempts(w, locked)
+		}
+		return fmt.Errorf("completing challenge: %w", err)
+	}
+
+	refresh, err := h.User.IssueRefreshToken(ctx, claims.Subject, v.Now)
+	if err != nil {
+		return fmt.Errorf("issuing refresh token: %w", err)
+	}
+
+	return h.respondToken(ctx, w, claims, refresh)
+}
+
+// EnrollTOTP starts enrolling the user in two-factor authentication. The
+// secret has to be confirmed with ConfirmTOTP before it is used.
+func (h Handlers) EnrollTOTP(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, err := auth.GetClaims(ctx)
+	if err != nil {
+		return errors.New("claims missing from context")
+	}
+
+	id := web.Param(r, "id")
+	secret, uri, err := h.User.EnrollTOTP(ctx, claims, id, v.Now)
+	if err != nil {
+		return fmt.Errorf("ID[%s]: %w", id, err)
+	}
+
+	resp := struct {
+		Secret string `json:"secret"`
+		URI    string `json:"uri"`
+	}{
+		Secret: secret,
+		URI:    uri,
+	}
+
+	return web.Respond(ctx, w, resp, http.StatusCreated)
+}
+
+// ConfirmTOTP enables two-factor authentication for the user with a code
+// from their authenticator app, and returns their recovery codes.
+func (h Handlers) ConfirmTOTP(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, err := auth.GetClaims(ctx)
+	if err != nil {
+		return errors.New("claims missing from context")
+	}
+
+	var req struct {
+		Code string `json:"code" validate:"required"`
+	}
+	if err := web.Decode(r, &req); err != nil {
+		return validate.NewRequestError(fmt.Errorf("unable to decode payload: %w", err), http.StatusBadRequest)
+	}
+	if err := validate.Check(req); err != nil {
+		return fmt.Errorf("validating data: %w", err)
+	}
+
+	id := web.Param(r, "id")
+	codes, err := h.User.ConfirmTOTP(ctx, claims, id, req.Code, v.Now)
+	if err != nil {
+		return fmt.Errorf("ID[%s]: %w", id, err)
 	}
 
+	resp := struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}{
+		RecoveryCodes: codes,
+	}
+
+	return web.Respond(ctx, w, resp, http.StatusOK)
+}
+
+// DisableTOTP turns off two-factor authentication for the user. Users turning
+// it off for themselves have to send a code from their authenticator app or
+// a recovery code.
+func (h Handlers) DisableTOTP(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, err := auth.GetClaims(ctx)
+	if err != nil {
+		return errors.New("claims missing from context")
+	}
+
+	// Users allowed to write any user don't have to send a code, so the
+	// body is optional.
+	var req struct {
+		Code string `json:"code"`
+	}
+	if r.ContentLength != 0 {
+		if err := web.Decode(r, &req); err != nil {
+			return validate.NewRequestError(fmt.Errorf("unable to decode payload: %w", err), http.StatusBadRequest)
+		}
+	}
+
+	id := web.Param(r, "id")
+	if err := h.User.DisableTOTP(ctx, claims, id, req.Code, v.Now); err != nil {
+		var locked *userCore.LockedError
+		if errors.As(err, &locked) {
+			return tooManyAttempts(w, locked)
+		}
+		return fmt.Errorf("ID[%s]: %w", id, err)
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// Refresh exchanges a refresh token for a new access token and a new refresh
+// token. The refresh token presented can't be used again.
+func (h Handlers) Refresh(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	var req struct {
+		RefreshToken string `json:"refresh_token" validate:"required"`
+	}
+	if err := web.Decode(r, &req); err != nil {
+		return validate.NewRequestError(fmt.Errorf("unable to decode payload: %w", err), http.StatusBadRequest)
+	}
+	if err := validate.Check(req); err != nil {
+		return fmt.Errorf("validating data: %w", err)
+	}
+
+	claims, refresh, err := h.User.Refresh(ctx, req.RefreshToken, v.Now)
+	if err != nil {
+		return fmt.Errorf("refreshing: %w", err)
+	}
+
+	return h.respondToken(ctx, w, claims, refresh)
+}
+
+// Logout revokes the access token used for the request and, when provided,
+// the refresh token that was issued alongside it.
+func (h Handlers) Logout(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, err := web.GetValues(ctx)
+	if err != nil {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, err := auth.GetClaims(ctx)
+	if err != nil {
+		return errors.New("claims missing from context")
+	}
+
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if r.ContentLength != 0 {
+		if err := web.Decode(r, &req); err != nil {
+			return validate.NewRequestError(fmt.Errorf("unable to decode payload: %w", err), http.StatusBadRequest)
+		}
+	}
+
+	if err := h.User.Logout(ctx, claims, req.RefreshToken, v.Now); err != nil {
+		return fmt.Errorf("logging out: %w", err)
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// respondToken signs the claims and sends the access and refresh tokens back
+// to the client.
+func (h Handlers) respondToken(ctx context.Context, w http.ResponseWriter, claims auth.Claims, refresh string) error {
 	var tkn struct {
-		Token string `json:"token"`
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
 	}
+
+	var err error
 	tkn.Token, err = h.Auth.GenerateToken(claims)
 	if err != nil {
 		return fmt.Errorf("generating token: %w", err)
 	}
+	tkn.RefreshToken = refresh
 
 	return web.Respond(ctx, w, tkn, http.StatusOK)
 }
+
+// sourceIP returns the address of the client a login attempt is counted
+// against. The address is only used to throttle guessing, so the connection
+// address is enough and forwarding headers are not trusted.
+func sourceIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+// tooManyAttempts tells the client when it can try to log in again.
+func tooManyAttempts(w http.ResponseWriter, locked *userCore.LockedError) error {
+	retry := int(math.Ceil(locked.RetryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(retry))
+	return validate.NewRequestError(locked, http.StatusTooManyRequests)
+}
@@ -0,0 +1,32 @@
+// Package jwksgrp maintains the handler publishing the public keys used to
+// sign tokens.
+package jwksgrp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/mihailtudos/service3/business/sys/auth"
+	"github.com/mihailtudos/service3/foundation/jwks"
+	"github.com/mihailtudos/service3/foundation/web"
+)
+
+// Handlers manages the set of key endpoints.
+type Handlers struct {
+	Auth *auth.Auth
+}
+
+// JWKS returns the public keys as a JSON Web Key Set so other services can
+// validate our tokens without holding any private key.
+func (h Handlers) JWKS(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	keys, err := h.Auth.PublicKeys()
+	if err != nil {
+		return fmt.Errorf("listing public keys: %w", err)
+	}
+
+	// Let verifiers cache the set, they refresh on an unknown kid anyway.
+	w.Header().Set("Cache-Control", "public, max-age=300")
+
+	return web.Respond(ctx, w, jwks.NewSet(keys), http.StatusOK)
+}
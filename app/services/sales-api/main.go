@@ -16,9 +16,15 @@ import (
 
 	"github.com/ardanlabs/conf/v3"
 	"github.com/mihailtudos/service3/app/services/sales-api/handlers"
+	"github.com/mihailtudos/service3/business/data/store/token"
+	"github.com/mihailtudos/service3/business/data/store/user"
 	"github.com/mihailtudos/service3/business/sys/auth"
 	"github.com/mihailtudos/service3/business/sys/database"
+	"github.com/mihailtudos/service3/business/sys/metrics"
+	"github.com/mihailtudos/service3/business/sys/password"
 	"github.com/mihailtudos/service3/foundation/keystore"
+	"github.com/mihailtudos/service3/foundation/mail"
+	"github.com/mihailtudos/service3/foundation/oidc"
 	"go.opentelemetry.io/otel/exporters/zipkin"
 	"go.opentelemetry.io/otel/sdk/resource"
 	"go.opentelemetry.io/otel/sdk/trace"
@@ -74,19 +80,73 @@ func run(log *zap.SugaredLogger) error {
 			WriteTimeout    time.Duration `conf:"default:10s"`
 			IdleTimeout     time.Duration `conf:"default:120s"`
 			ShutdownTimeout time.Duration `conf:"default:20s"`
+			// LegacyErrors keeps sending errors in the format used before
+			// problem details, for clients that haven't moved over yet.
+			// Clients can still ask for problem details with the Accept
+			// header. Turn it off once every client has moved over.
+			LegacyErrors bool `conf:"default:true"`
 		}
 		Auth struct {
 			KeysFolder string `conf:"default:zarf/keys/"`
 			ActiveKID  string `conf:"default:456F21BD-1296-449A-9C2E-85A92092E966"`
+			// KeysPollInterval is how often the keys folder is read to pick up
+			// added, removed and scheduled keys.
+			KeysPollInterval time.Duration `conf:"default:30s"`
+			// KeyRetention keeps a removed key valid for validation. It should
+			// be at least the lifetime of an access token.
+			KeyRetention time.Duration `conf:"default:1h"`
+			// PolicyFile is a JSON file mapping roles to permissions. The
+			// default mapping is used when it is empty.
+			PolicyFile string
 		}
 		DB struct {
-			User         string `conf:"default:postgres"`
-			Password     string `conf:"default:password,mask"`
-			Host         string `conf:"default:localhost"`
-			Name         string `conf:"default:postgres"`
-			MaxIdleConns int    `conf:"default:0"`
-			MaxOpenConns int    `conf:"default:0"`
-			DisableTLS   bool   `conf:"default:true"`
+			User               string        `conf:"default:postgres"`
+			Password           string        `conf:"default:password,mask"`
+			Host               string        `conf:"default:localhost"`
+			Name               string        `conf:"default:postgres"`
+			MaxIdleConns       int           `conf:"default:0"`
+			MaxOpenConns       int           `conf:"default:0"`
+			DisableTLS         bool          `conf:"default:true"`
+			SlowQueryThreshold time.Duration `conf:"default:200ms"`
+			ExplainSlowQueries bool          `conf:"default:false"`
+			LogQueries         string        `conf:"default:statement"`
+			RedactColumns      []string      `conf:"default:email;password_hash"`
+		}
+		// Mail is sent through the SMTP server when Host is set, otherwise it
+		// is written to files in Folder, or to the log when that is empty.
+		Mail struct {
+			Host     string
+			Port     int `conf:"default:587"`
+			Username string
+			Password string `conf:"mask"`
+			From     string `conf:"default:no-reply@example.com"`
+			Folder   string
+		}
+		// Password sets how passwords are hashed, bcrypt or argon2id, and the
+		// policy new passwords are checked against. BreachedFile lists
+		// passwords, one per line, that can't be chosen.
+		Password struct {
+			Hasher        string `conf:"default:bcrypt"`
+			BcryptCost    int    `conf:"default:10"`
+			MinLength     int    `conf:"default:8"`
+			MaxLength     int    `conf:"default:72"`
+			RequireUpper  bool
+			RequireLower  bool
+			RequireDigit  bool
+			RequireSymbol bool
+			BreachedFile  string
+		}
+		// OIDC enables logging in through an OpenID Connect provider when
+		// Issuer is set. Users created for new identities get DefaultRoles.
+		OIDC struct {
+			Issuer       string
+			ClientID     string
+			ClientSecret string   `conf:"mask"`
+			RedirectURL  string   `conf:"default:http://localhost:3000/v1/oidc/callback"`
+			DefaultRoles []string `conf:"default:USER"`
+			// SecureCookies marks the login cookie secure, which is needed
+			// when TLS is terminated by a proxy in front of the service.
+			SecureCookies bool `conf:"default:true"`
 		}
 		Zipkin struct {
 			ReporterURI string  `conf:"default:http://localhost:9411/api/v2/spans"`
@@ -126,47 +186,169 @@ func run(log *zap.SugaredLogger) error {
 
 	expvar.NewString("build").Set(build)
 
+	// ==============================
+	// Database Support
+
+	// Create connection pool
+	log.Infow("startup", "status", "initializing database support", "host", cfg.DB.Host)
+
+	db, err := database.Open(database.Config{
+		Host:               cfg.DB.Host,
+		Name:               cfg.DB.Name,
+		User:               cfg.DB.User,
+		Password:           cfg.DB.Password,
+		MaxIdleConns:       cfg.DB.MaxIdleConns,
+		MaxOpenConns:       cfg.DB.MaxOpenConns,
+		DisableTLS:         cfg.DB.DisableTLS,
+		SlowQueryThreshold: cfg.DB.SlowQueryThreshold,
+		ExplainSlowQueries: cfg.DB.ExplainSlowQueries,
+		LogQueries:         cfg.DB.LogQueries,
+		RedactColumns:      cfg.DB.RedactColumns,
+	})
+
+	if err != nil {
+		return fmt.Errorf("connecting to db: %w", err)
+	}
+	defer func() {
+		log.Infow("shutdown", "status", "stopping database support", "host", cfg.DB.Host)
+		if err := db.Close(); err != nil {
+			log.Errorw("closing database connection", "host", cfg.DB.Host, "error", err)
+		}
+	}()
+
 	// ==============================
 	// Initialize authentication support
 
 	log.Infow("startup", "status", "initializing authentication support")
 
 	// Construct a KeyStore from the keys in the keys folder.
-	ks, err := keystore.NewFS(os.DirFS(cfg.Auth.KeysFolder))
+	keysFS := os.DirFS(cfg.Auth.KeysFolder)
+	ks, err := keystore.NewFS(keysFS)
 	if err != nil {
 		return fmt.Errorf("reading keys: %w", err)
 	}
 
-	authorizer, err := auth.New(cfg.Auth.ActiveKID, ks)
+	if err := ks.SetActive(cfg.Auth.ActiveKID); err != nil {
+		return fmt.Errorf("setting active key: %w", err)
+	}
+	metrics.SetActiveKID(cfg.Auth.ActiveKID)
+
+	// Keep polling the keys folder so keys can be rotated without a restart.
+	watchCtx, stopWatch := context.WithCancel(context.Background())
+	defer stopWatch()
+
+	go ks.Watch(watchCtx, keysFS, keystore.WatchConfig{
+		Interval:  cfg.Auth.KeysPollInterval,
+		Retention: cfg.Auth.KeyRetention,
+		OnEvent: func(e keystore.Event) {
+			log.Infow("keystore", "status", "key "+e.Kind, "kid", e.KID)
+			metrics.AddKeyEvent(e.Kind)
+			if e.Kind == keystore.EventActivated {
+				metrics.SetActiveKID(e.KID)
+			}
+		},
+		OnError: func(err error) {
+			log.Errorw("keystore", "status", "syncing keys", "ERROR", err)
+		},
+	})
+
+	// Revoked tokens are tracked in the database so a revocation applies to
+	// every instance of the service.
+	authorizer, err := auth.New(cfg.Auth.ActiveKID, ks, token.NewStore(db, log))
 	if err != nil {
 		return fmt.Errorf("constructing auth: %w", err)
 	}
 
+	policy := auth.DefaultPolicy()
+	if cfg.Auth.PolicyFile != "" {
+		f, err := os.Open(cfg.Auth.PolicyFile)
+		if err != nil {
+			return fmt.Errorf("opening policy file: %w", err)
+		}
+
+		policy, err = auth.ParsePolicy(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("reading policy file: %w", err)
+		}
+	}
+
 	// ==============================
-	// Database Support
+	// Initialize mail support
+
+	var mailer mail.Mailer
+	switch {
+	case cfg.Mail.Host != "":
+		mailer = mail.NewSMTP(mail.SMTPConfig{
+			Host:     cfg.Mail.Host,
+			Port:     cfg.Mail.Port,
+			Username: cfg.Mail.Username,
+			Password: cfg.Mail.Password,
+			From:     cfg.Mail.From,
+		})
+	case cfg.Mail.Folder != "":
+		if err := os.MkdirAll(cfg.Mail.Folder, 0o700); err != nil {
+			return fmt.Errorf("creating mail folder: %w", err)
+		}
+		mailer = mail.NewFile(cfg.Mail.Folder)
+	default:
+		mailer = mail.NewLog(log)
+	}
 
-	// Create connection pool
-	log.Infow("startup", "status", "initializing database support", "host", cfg.DB.Host)
+	// ==============================
+	// Initialize password support
+
+	var hasher user.PasswordHasher
+	switch cfg.Password.Hasher {
+	case "bcrypt":
+		hasher = password.Bcrypt{Cost: cfg.Password.BcryptCost}
+	case "argon2id":
+		hasher = password.NewArgon2id()
+	default:
+		return fmt.Errorf("unknown password hasher %q", cfg.Password.Hasher)
+	}
 
-	db, err := database.Open(database.Config{
-		Host:         cfg.DB.Host,
-		Name:         cfg.DB.Name,
-		User:         cfg.DB.User,
-		Password:     cfg.DB.Password,
-		MaxIdleConns: cfg.DB.MaxIdleConns,
-		MaxOpenConns: cfg.DB.MaxOpenConns,
-		DisableTLS:   cfg.DB.DisableTLS,
-	})
+	passwordPolicy := password.Policy{
+		MinLength:     cfg.Password.MinLength,
+		MaxLength:     cfg.Password.MaxLength,
+		RequireUpper:  cfg.Password.RequireUpper,
+		RequireLower:  cfg.Password.RequireLower,
+		RequireDigit:  cfg.Password.RequireDigit,
+		RequireSymbol: cfg.Password.RequireSymbol,
+	}
 
-	if err != nil {
-		return fmt.Errorf("connecting to db: %w", err)
+	if cfg.Password.BreachedFile != "" {
+		f, err := os.Open(cfg.Password.BreachedFile)
+		if err != nil {
+			return fmt.Errorf("opening breached passwords file: %w", err)
+		}
+
+		err = passwordPolicy.LoadBreached(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
 	}
-	defer func() {
-		log.Infow("shutdown", "status", "stopping database support", "host", cfg.DB.Host)
-		if err := db.Close(); err != nil {
-			log.Errorw("closing database connection", "host", cfg.DB.Host, "error", err)
+
+	// ==============================
+	// Initialize OpenID Connect support
+
+	var provider *oidc.Provider
+	if cfg.OIDC.Issuer != "" {
+		log.Infow("startup", "status", "initializing OIDC support", "issuer", cfg.OIDC.Issuer)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		provider, err = oidc.Discover(ctx, oidc.Config{
+			Issuer:       cfg.OIDC.Issuer,
+			ClientID:     cfg.OIDC.ClientID,
+			ClientSecret: cfg.OIDC.ClientSecret,
+			RedirectURL:  cfg.OIDC.RedirectURL,
+		})
+		cancel()
+		if err != nil {
+			return fmt.Errorf("discovering oidc provider: %w", err)
 		}
-	}()
+	}
 
 	// ==============================
 	// Start Tracing Support
@@ -210,10 +392,18 @@ func run(log *zap.SugaredLogger) error {
 
 	// Construct the mux for the API calls.
 	apiMux := handlers.APIMux(handlers.APIMuxConfig{
-		Shutdown: shutdown,
-		Log:      log,
-		Auth:     authorizer,
-		DB:       db,
+		Shutdown:          shutdown,
+		Log:               log,
+		Auth:              authorizer,
+		Policy:            policy,
+		Mailer:            mailer,
+		OIDC:              provider,
+		OIDCDefaultRoles:  cfg.OIDC.DefaultRoles,
+		OIDCSecureCookies: cfg.OIDC.SecureCookies,
+		PasswordHasher:    hasher,
+		PasswordPolicy:    &passwordPolicy,
+		DB:                db,
+		LegacyErrors:      cfg.Web.LegacyErrors,
 	})
 
 	api := http.Server{
@@ -1,12 +1,16 @@
 package tests
 
 import (
+	"bytes"
 	"encoding/json"
 	"github.com/mihailtudos/service3/app/services/sales-api/handlers"
+	"github.com/mihailtudos/service3/business/data/store/user"
 	"github.com/mihailtudos/service3/business/data/tests"
+	"github.com/mihailtudos/service3/foundation/web"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -45,6 +49,9 @@ func TestUsers(t *testing.T) {
 	}
 
 	t.Run("getToken200", ts.getToken200)
+	t.Run("getUsers200", ts.getUsers200)
+	t.Run("getUsers403", ts.getUsers403)
+	t.Run("refreshToken200", ts.refreshToken200)
 }
 
 func (ut *UserTests) getToken200(t *testing.T) {
@@ -74,3 +81,166 @@ func (ut *UserTests) getToken200(t *testing.T) {
 		}
 	}
 }
+
+func (ut *UserTests) getUsers200(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/v1/users?rows=3&order_by=name,asc", nil)
+	w := httptest.NewRecorder()
+
+	r.Header.Set("Authorization", "Bearer "+ut.adminToken)
+	ut.app.ServeHTTP(w, r)
+
+	t.Log("Given the need to page through users.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen fetching the first page of users as an admin.", testID)
+		{
+			if w.Code != http.StatusOK {
+				t.Fatalf("\t%s\tTest %d:\tShould receive a HTTP 200 status code : %v", tests.Failed, testID, w.Code)
+			}
+			t.Logf("\t%s\tTest %d:\tShould receive a HTTP 200 status code.", tests.Success, testID)
+
+			var got web.Page[user.User]
+			if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to decode the response : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to decode the response.", tests.Success, testID)
+
+			if len(got.Items) != 3 || got.RowsPerPage != 3 {
+				t.Logf("\t\tTest %d:\titems: %d rows_per_page: %d", testID, len(got.Items), got.RowsPerPage)
+				t.Fatalf("\t%s\tTest %d:\tShould get a full page of users.", tests.Failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould get a full page of users.", tests.Success, testID)
+
+			if got.Total <= len(got.Items) || got.NextCursor == "" {
+				t.Logf("\t\tTest %d:\ttotal: %d next_cursor: %q", testID, got.Total, got.NextCursor)
+				t.Fatalf("\t%s\tTest %d:\tShould be told there are more users.", tests.Failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be told there are more users.", tests.Success, testID)
+
+			link := w.Header().Get("Link")
+			if !strings.Contains(link, `rel="next"`) || !strings.Contains(link, "cursor="+got.NextCursor) {
+				t.Logf("\t\tTest %d:\tLink: %s", testID, link)
+				t.Fatalf("\t%s\tTest %d:\tShould get a Link header pointing at the next page.", tests.Failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould get a Link header pointing at the next page.", tests.Success, testID)
+		}
+	}
+}
+
+func (ut *UserTests) getUsers403(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/v1/users", nil)
+	w := httptest.NewRecorder()
+
+	r.Header.Set("Authorization", "Bearer "+ut.userToken)
+	ut.app.ServeHTTP(w, r)
+
+	t.Log("Given the need to restrict listing users to admins.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen fetching users as a regular user.", testID)
+		{
+			if w.Code != http.StatusForbidden {
+				t.Fatalf("\t%s\tTest %d:\tShould receive a HTTP 403 status code : %v", tests.Failed, testID, w.Code)
+			}
+			t.Logf("\t%s\tTest %d:\tShould receive a HTTP 403 status code.", tests.Success, testID)
+		}
+	}
+}
+
+func (ut *UserTests) refreshToken200(t *testing.T) {
+	type tokens struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	refresh := func(refreshToken string) (*httptest.ResponseRecorder, tokens) {
+		body, _ := json.Marshal(map[string]string{"refresh_token": refreshToken})
+		r := httptest.NewRequest(http.MethodPost, "/v1/users/token/refresh", bytes.NewReader(body))
+		w := httptest.NewRecorder()
+		ut.app.ServeHTTP(w, r)
+
+		var got tokens
+		json.NewDecoder(w.Body).Decode(&got)
+		return w, got
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/users/token", nil)
+	w := httptest.NewRecorder()
+
+	r.SetBasicAuth("user@example.com", "gophers")
+	ut.app.ServeHTTP(w, r)
+
+	t.Log("Given the need to refresh access tokens.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen rotating a refresh token.", testID)
+		{
+			var first tokens
+			if err := json.NewDecoder(w.Body).Decode(&first); err != nil || first.RefreshToken == "" {
+				t.Fatalf("\t%s\tTest %d:\tShould receive a refresh token with the access token : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould receive a refresh token with the access token.", tests.Success, testID)
+
+			w, second := refresh(first.RefreshToken)
+			if w.Code != http.StatusOK || second.Token == "" || second.RefreshToken == "" {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to refresh : %v", tests.Failed, testID, w.Code)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to refresh.", tests.Success, testID)
+
+			if second.RefreshToken == first.RefreshToken {
+				t.Fatalf("\t%s\tTest %d:\tShould receive a new refresh token.", tests.Failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould receive a new refresh token.", tests.Success, testID)
+
+			if w, _ := refresh(first.RefreshToken); w.Code != http.StatusUnauthorized {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to reuse a refresh token : %v", tests.Failed, testID, w.Code)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to reuse a refresh token.", tests.Success, testID)
+
+			if w, _ := refresh(second.RefreshToken); w.Code != http.StatusUnauthorized {
+				t.Fatalf("\t%s\tTest %d:\tShould revoke the whole family after reuse : %v", tests.Failed, testID, w.Code)
+			}
+			t.Logf("\t%s\tTest %d:\tShould revoke the whole family after reuse.", tests.Success, testID)
+		}
+
+		testID = 1
+		t.Logf("\tTest %d:\tWhen logging out.", testID)
+		{
+			r := httptest.NewRequest(http.MethodGet, "/v1/users/token", nil)
+			w := httptest.NewRecorder()
+			r.SetBasicAuth("user@example.com", "gophers")
+			ut.app.ServeHTTP(w, r)
+
+			var tkns tokens
+			if err := json.NewDecoder(w.Body).Decode(&tkns); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to decode the response : %v", tests.Failed, testID, err)
+			}
+
+			body, _ := json.Marshal(map[string]string{"refresh_token": tkns.RefreshToken})
+			r = httptest.NewRequest(http.MethodPost, "/v1/users/logout", bytes.NewReader(body))
+			r.Header.Set("Authorization", "Bearer "+tkns.Token)
+			w = httptest.NewRecorder()
+			ut.app.ServeHTTP(w, r)
+
+			if w.Code != http.StatusNoContent {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to log out : %v", tests.Failed, testID, w.Code)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to log out.", tests.Success, testID)
+
+			r = httptest.NewRequest(http.MethodGet, "/v1/users/45b5fbd3-755f-4379-8f07-a58d4a30fa2f", nil)
+			r.Header.Set("Authorization", "Bearer "+tkns.Token)
+			w = httptest.NewRecorder()
+			ut.app.ServeHTTP(w, r)
+
+			if w.Code != http.StatusUnauthorized {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to use a revoked access token : %v", tests.Failed, testID, w.Code)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to use a revoked access token.", tests.Success, testID)
+
+			if w, _ := refresh(tkns.RefreshToken); w.Code != http.StatusUnauthorized {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to use a revoked refresh token : %v", tests.Failed, testID, w.Code)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to use a revoked refresh token.", tests.Success, testID)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+// Package apikey provides the core business API for managing the API keys
+// machine clients authenticate with.
+package apikey
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jmoiron/sqlx"
+	"github.com/mihailtudos/service3/business/data/store/apikey"
+	"github.com/mihailtudos/service3/business/data/store/user"
+	"github.com/mihailtudos/service3/business/sys/auth"
+	"github.com/mihailtudos/service3/business/sys/database"
+	"github.com/mihailtudos/service3/business/sys/validate"
+	"go.uber.org/zap"
+)
+
+// Core manages the set of APIs for API key access.
+type Core struct {
+	apikey apikey.Store
+	user   user.Store
+	log    *zap.SugaredLogger
+}
+
+// NewCore constructs a core for API key api access.
+func NewCore(log *zap.SugaredLogger, db *sqlx.DB) Core {
+	return Core{
+		log:    log,
+		apikey: apikey.NewStore(db, log),
+		user:   user.NewStore(db, log),
+	}
+}
+
+// Create issues a new API key for the user. The key is only returned here.
+func (c Core) Create(ctx context.Context, nak apikey.NewAPIKey, now time.Time) (string, apikey.APIKey, error) {
+	for _, scope := range nak.Scopes {
+		if _, err := auth.ParsePermission(scope); err != nil {
+			return "", apikey.APIKey{}, validate.FieldErrors{
+				{Field: "scopes", Error: err.Error()},
+			}
+		}
+	}
+
+	if nak.DateExpires != nil && !nak.DateExpires.After(now) {
+		return "", apikey.APIKey{}, validate.FieldErrors{
+			{Field: "date_expires", Error: "must be in the future"},
+		}
+	}
+
+	if _, err := c.user.QueryByID(ctx, nak.UserID); err != nil {
+		return "", apikey.APIKey{}, fmt.Errorf("create api key: %w", err)
+	}
+
+	key, ak, err := c.apikey.Create(ctx, nak, now)
+	if err != nil {
+		return "", apikey.APIKey{}, fmt.Errorf("create api key: %w", err)
+	}
+
+	return key, ak, nil
+}
+
+// QueryByUserID gets the API keys owned by the specified user.
+func (c Core) QueryByUserID(ctx context.Context, userID string) ([]apikey.APIKey, error) {
+	keys, err := c.apikey.QueryByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("query api keys: %w", err)
+	}
+
+	return keys, nil
+}
+
+// Revoke revokes the specified API key.
+func (c Core) Revoke(ctx context.Context, keyID string, now time.Time) error {
+	if _, err := c.apikey.QueryByID(ctx, keyID); err != nil {
+		return fmt.Errorf("revoke api key: %w", err)
+	}
+
+	if err := c.apikey.Revoke(ctx, keyID, now); err != nil {
+		return fmt.Errorf("revoke api key: %w", err)
+	}
+
+	return nil
+}
+
+// ValidateAPIKey authenticates a machine client by its API key. The claims
+// carry the roles of the user owning the key, limited to the scopes of the
+// key. It implements the auth.APIKeyValidator interface.
+func (c Core) ValidateAPIKey(ctx context.Context, key string, now time.Time) (auth.Claims, error) {
+	ak, err := c.apikey.QueryByKey(ctx, key)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return auth.Claims{}, fmt.Errorf("unknown api key: %w", database.ErrAuthenticationFailed)
+		}
+		return auth.Claims{}, fmt.Errorf("validate api key: %w", err)
+	}
+
+	switch {
+	case ak.DateRevoked != nil:
+		return auth.Claims{}, fmt.Errorf("api key revoked: %w", database.ErrAuthenticationFailed)
+	case ak.DateExpires != nil && !now.Before(*ak.DateExpires):
+		return auth.Claims{}, fmt.Errorf("api key expired: %w", database.ErrAuthenticationFailed)
+	}
+
+	usr, err := c.user.QueryByID(ctx, ak.UserID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return auth.Claims{}, fmt.Errorf("api key owner not found: %w", database.ErrAuthenticationFailed)
+		}
+		return auth.Claims{}, fmt.Errorf("validate api key: %w", err)
+	}
+
+	if err := c.apikey.UpdateLastUsed(ctx, ak.ID, now); err != nil {
+		return auth.Claims{}, fmt.Errorf("validate api key: %w", err)
+	}
+
+	claims := auth.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:  usr.ID,
+			Issuer:   "service project",
+			IssuedAt: jwt.NewNumericDate(ak.DateCreated),
+		},
+		Roles:  usr.Roles,
+		Scopes: ak.Scopes,
+	}
+
+	if ak.DateExpires != nil {
+		claims.ExpiresAt = jwt.NewNumericDate(*ak.DateExpires)
+	}
+
+	return claims, nil
+}
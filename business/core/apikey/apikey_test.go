@@ -0,0 +1,112 @@
+package apikey_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mihailtudos/service3/business/core/apikey"
+	apikeyStore "github.com/mihailtudos/service3/business/data/store/apikey"
+	"github.com/mihailtudos/service3/business/data/tests"
+	"github.com/mihailtudos/service3/business/sys/auth"
+	"github.com/mihailtudos/service3/business/sys/database"
+)
+
+var dbc = tests.DBContainer{
+	Image: "postgres:17-alpine",
+	Port:  "5432",
+	Args:  []string{"-e", "POSTGRES_PASSWORD=postgres"},
+}
+
+func TestAPIKey(t *testing.T) {
+	log, db, teardown := tests.NewUnit(t, dbc)
+	t.Cleanup(teardown)
+
+	core := apikey.NewCore(log, db)
+
+	t.Log("Given the need to authenticate machine clients with API keys.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen handling a single API key.", testID)
+		{
+			ctx := context.Background()
+			now := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+			expires := now.Add(24 * time.Hour)
+
+			// The seeded "User Gopher" account owns the key.
+			nak := apikeyStore.NewAPIKey{
+				UserID:      "45b5fbd3-755f-4379-8f07-a58d4a30fa2f",
+				Name:        "nightly batch",
+				Scopes:      []string{string(auth.PermProductsRead)},
+				DateExpires: &expires,
+			}
+
+			key, ak, err := core.Create(ctx, nak, now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create an api key : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to create an api key.", tests.Success, testID)
+
+			claims, err := core.ValidateAPIKey(ctx, key, now.Add(time.Hour))
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to validate the api key : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to validate the api key.", tests.Success, testID)
+
+			if claims.Subject != nak.UserID || len(claims.Scopes) != 1 || claims.Scopes[0] != nak.Scopes[0] {
+				t.Fatalf("\t%s\tTest %d:\tShould get claims for the owner limited to the scopes : %+v", tests.Failed, testID, claims)
+			}
+			t.Logf("\t%s\tTest %d:\tShould get claims for the owner limited to the scopes.", tests.Success, testID)
+
+			keys, err := core.QueryByUserID(ctx, nak.UserID)
+			if err != nil || len(keys) != 1 || keys[0].DateLastUsed == nil {
+				t.Fatalf("\t%s\tTest %d:\tShould record when the key was last used : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould record when the key was last used.", tests.Success, testID)
+
+			if _, err := core.ValidateAPIKey(ctx, key, expires); !errors.Is(err, database.ErrAuthenticationFailed) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to use an expired key : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to use an expired key.", tests.Success, testID)
+
+			if err := core.Revoke(ctx, ak.ID, now); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to revoke the api key : %v", tests.Failed, testID, err)
+			}
+
+			if _, err := core.ValidateAPIKey(ctx, key, now); !errors.Is(err, database.ErrAuthenticationFailed) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to use a revoked key : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to use a revoked key.", tests.Success, testID)
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tWhen the owner of an API key is deleted.", testID)
+		{
+			ctx := context.Background()
+			now := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+			// The seeded "Admin Gopher" account owns the key.
+			nak := apikeyStore.NewAPIKey{
+				UserID: "5cf37266-3473-4006-984f-9325122678b7",
+				Name:   "reports",
+				Scopes: []string{string(auth.PermProductsRead)},
+			}
+
+			key, _, err := core.Create(ctx, nak, now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create an api key : %v", tests.Failed, testID, err)
+			}
+
+			const q = `UPDATE users SET date_deleted = $1 WHERE user_id = $2`
+			if _, err := db.ExecContext(ctx, q, now, nak.UserID); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to delete the owner : %v", tests.Failed, testID, err)
+			}
+
+			if _, err := core.ValidateAPIKey(ctx, key, now); !errors.Is(err, database.ErrAuthenticationFailed) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to use the key of a deleted user : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to use the key of a deleted user.", tests.Success, testID)
+		}
+	}
+}
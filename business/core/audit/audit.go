@@ -0,0 +1,44 @@
+// Package audit provides the core business API for reading the audit log.
+package audit
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mihailtudos/service3/business/data/store/audit"
+	"go.uber.org/zap"
+)
+
+// Core manages the set of APIs for audit access.
+type Core struct {
+	audit audit.Store
+}
+
+// NewCore constructs a core for audit api access.
+func NewCore(log *zap.SugaredLogger, db *sqlx.DB) Core {
+	return Core{
+		audit: audit.NewStore(db, log),
+	}
+}
+
+// Query retrieves a page of events from the audit log, newest first, along
+// with the cursor for the next page.
+func (c Core) Query(ctx context.Context, filter audit.QueryFilter, cursor string, rowsPerPage int) ([]audit.Event, string, error) {
+	events, next, err := c.audit.Query(ctx, filter, cursor, rowsPerPage)
+	if err != nil {
+		return nil, "", fmt.Errorf("query events: %w", err)
+	}
+
+	return events, next, nil
+}
+
+// Count returns the total number of events matching the filter.
+func (c Core) Count(ctx context.Context, filter audit.QueryFilter) (int, error) {
+	n, err := c.audit.Count(ctx, filter)
+	if err != nil {
+		return 0, fmt.Errorf("count events: %w", err)
+	}
+
+	return n, nil
+}
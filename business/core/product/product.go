@@ -0,0 +1,118 @@
+// Package product provides an example of a core business API. Right now
+// these calls are just wrapping the data/store layer. But at some point you
+// will want auditing or something that isn't specific to the data/store layer.
+package product
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mihailtudos/service3/business/data/store/product"
+	"github.com/mihailtudos/service3/business/sys/auth"
+	"github.com/mihailtudos/service3/business/sys/database"
+	"go.uber.org/zap"
+)
+
+// Core manages the set of APIs for product access.
+type Core struct {
+	product product.Store
+	policy  *auth.Policy
+	log     *zap.SugaredLogger
+}
+
+// NewCore constructs a core for product api access.
+func NewCore(log *zap.SugaredLogger, db *sqlx.DB, policy *auth.Policy) Core {
+	return Core{
+		log:     log,
+		product: product.NewStore(db, log),
+		policy:  policy,
+	}
+}
+
+// Create adds a Product to the database.
+func (c Core) Create(ctx context.Context, claims auth.Claims, np product.NewProduct, now time.Time) (product.Product, error) {
+	prd, err := c.product.Create(ctx, claims, np, now)
+	if err != nil {
+		return product.Product{}, fmt.Errorf("create product: %w", err)
+	}
+
+	return prd, nil
+}
+
+// Update modifies data about a Product. Only the owner of the product or a
+// user allowed to write any product can update it.
+func (c Core) Update(ctx context.Context, claims auth.Claims, productID string, up product.UpdateProduct, now time.Time) error {
+	if err := c.authorize(ctx, claims, productID); err != nil {
+		return fmt.Errorf("update product: %w", err)
+	}
+
+	if err := c.product.Update(ctx, productID, up, now); err != nil {
+		return fmt.Errorf("update product: %w", err)
+	}
+
+	return nil
+}
+
+// Delete removes the product identified by a given ID. Only the owner of the
+// product or a user allowed to write any product can delete it.
+func (c Core) Delete(ctx context.Context, claims auth.Claims, productID string) error {
+	if err := c.authorize(ctx, claims, productID); err != nil {
+		return fmt.Errorf("delete product: %w", err)
+	}
+
+	if err := c.product.Delete(ctx, productID); err != nil {
+		return fmt.Errorf("delete product: %w", err)
+	}
+
+	return nil
+}
+
+// authorize checks the claims hold the permission to write the product.
+func (c Core) authorize(ctx context.Context, claims auth.Claims, productID string) error {
+	if c.policy.Allowed(claims, auth.PermProductsWrite) {
+		return nil
+	}
+
+	prd, err := c.product.QueryByID(ctx, productID)
+	if err != nil {
+		return err
+	}
+
+	if !c.policy.AllowedOwner(claims, auth.PermProductsWrite, prd.UserID) {
+		return database.ErrForbidden
+	}
+
+	return nil
+}
+
+// Query gets all Products from the database.
+func (c Core) Query(ctx context.Context, pageNumber int, rowsPerPage int) ([]product.Product, error) {
+	prds, err := c.product.Query(ctx, pageNumber, rowsPerPage)
+	if err != nil {
+		return nil, fmt.Errorf("query products: %w", err)
+	}
+
+	return prds, nil
+}
+
+// QueryByID finds the product identified by a given ID.
+func (c Core) QueryByID(ctx context.Context, productID string) (product.Product, error) {
+	prd, err := c.product.QueryByID(ctx, productID)
+	if err != nil {
+		return product.Product{}, fmt.Errorf("query product: %w", err)
+	}
+
+	return prd, nil
+}
+
+// QueryByUserID finds the products owned by the specified user.
+func (c Core) QueryByUserID(ctx context.Context, userID string) ([]product.Product, error) {
+	prds, err := c.product.QueryByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("query products: %w", err)
+	}
+
+	return prds, nil
+}
@@ -0,0 +1,84 @@
+package product_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/mihailtudos/service3/business/core/product"
+	productStore "github.com/mihailtudos/service3/business/data/store/product"
+	"github.com/mihailtudos/service3/business/data/tests"
+	"github.com/mihailtudos/service3/business/sys/auth"
+	"github.com/mihailtudos/service3/business/sys/database"
+)
+
+var dbc = tests.DBContainer{
+	Image: "postgres:17-alpine",
+	Port:  "5432",
+	Args:  []string{"-e", "POSTGRES_PASSWORD=postgres"},
+}
+
+func TestProductOwnership(t *testing.T) {
+	log, db, teardown := tests.NewUnit(t, dbc)
+	t.Cleanup(teardown)
+
+	core := product.NewCore(log, db, auth.DefaultPolicy())
+
+	t.Log("Given the need to restrict changes to a Product to its owner.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen another user changes a Product.", testID)
+		{
+			ctx := context.Background()
+			now := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+			// The seeded "User Gopher" account owns the product.
+			claims := auth.Claims{
+				RegisteredClaims: jwt.RegisteredClaims{
+					Subject:   "45b5fbd3-755f-4379-8f07-a58d4a30fa2f",
+					Issuer:    "service project",
+					ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+					IssuedAt:  jwt.NewNumericDate(time.Now()),
+				},
+				Roles: []string{auth.RoleUser},
+			}
+
+			np := productStore.NewProduct{
+				Name:     "Comic Books",
+				Cost:     10,
+				Quantity: 55,
+			}
+
+			prd, err := core.Create(ctx, claims, np, now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create a product : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to create a product.", tests.Success, testID)
+
+			other := claims
+			other.Subject = "28e57012-fbbb-4f67-854f-d15f8b9462c7"
+
+			upd := productStore.UpdateProduct{
+				Name: tests.StringPointer("Comics"),
+			}
+			if err := core.Update(ctx, other, prd.ID, upd, now); !errors.Is(err, database.ErrForbidden) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to update another user's product : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to update another user's product.", tests.Success, testID)
+
+			if err := core.Delete(ctx, other, prd.ID); !errors.Is(err, database.ErrForbidden) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to delete another user's product : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to delete another user's product.", tests.Success, testID)
+
+			admin := other
+			admin.Roles = []string{auth.RoleAdmin}
+			if err := core.Delete(ctx, admin, prd.ID); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to delete any product as an admin : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to delete any product as an admin.", tests.Success, testID)
+		}
+	}
+}
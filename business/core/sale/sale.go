@@ -0,0 +1,99 @@
+// Package sale provides the core business API for recording and reporting
+// on sales.
+package sale
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mihailtudos/service3/business/data/store/product"
+	"github.com/mihailtudos/service3/business/data/store/sale"
+	"github.com/mihailtudos/service3/business/sys/auth"
+	"github.com/mihailtudos/service3/business/sys/database"
+	"github.com/mihailtudos/service3/business/sys/validate"
+	"go.uber.org/zap"
+)
+
+// Core manages the set of APIs for sale access.
+type Core struct {
+	db      *sqlx.DB
+	sale    sale.Store
+	product product.Store
+	policy  *auth.Policy
+	log     *zap.SugaredLogger
+}
+
+// NewCore constructs a core for sale api access.
+func NewCore(log *zap.SugaredLogger, db *sqlx.DB, policy *auth.Policy) Core {
+	return Core{
+		db:      db,
+		log:     log,
+		sale:    sale.NewStore(db, log),
+		product: product.NewStore(db, log),
+		policy:  policy,
+	}
+}
+
+// Create records a sale of a product for the authenticated user. The stock
+// is taken from the product and the sale is inserted inside one transaction
+// so a sale can never be recorded against stock that doesn't exist.
+func (c Core) Create(ctx context.Context, claims auth.Claims, ns sale.NewSale, now time.Time) (sale.Sale, error) {
+	if err := validate.Check(ns); err != nil {
+		return sale.Sale{}, fmt.Errorf("validating data: %w", err)
+	}
+
+	var sl sale.Sale
+	f := func(tx sqlx.ExtContext) error {
+		prd, err := c.product.Tran(tx).Reserve(ctx, ns.ProductID, ns.Quantity, now)
+		if err != nil {
+			return err
+		}
+
+		sl, err = c.sale.Tran(tx).Create(ctx, claims, ns, prd.Cost*ns.Quantity, now)
+		return err
+	}
+
+	if err := database.WithinTran(ctx, c.log, c.db, f); err != nil {
+		return sale.Sale{}, fmt.Errorf("create sale: %w", err)
+	}
+
+	return sl, nil
+}
+
+// QueryByUserID gets the sales made by the specified user. Only that user or
+// a user allowed to read any sale can see them.
+func (c Core) QueryByUserID(ctx context.Context, claims auth.Claims, userID string) ([]sale.Sale, error) {
+	if !c.policy.AllowedOwner(claims, auth.PermSalesRead, userID) {
+		return nil, database.ErrForbidden
+	}
+
+	sales, err := c.sale.QueryByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("query sales: %w", err)
+	}
+
+	return sales, nil
+}
+
+// QueryByProductID gets the sales recorded against the specified product.
+// Only the owner of the product or a user allowed to read any sale can see
+// its sales.
+func (c Core) QueryByProductID(ctx context.Context, claims auth.Claims, productID string) ([]sale.Sale, error) {
+	prd, err := c.product.QueryByID(ctx, productID)
+	if err != nil {
+		return nil, fmt.Errorf("query product: %w", err)
+	}
+
+	if !c.policy.AllowedOwner(claims, auth.PermSalesRead, prd.UserID) {
+		return nil, database.ErrForbidden
+	}
+
+	sales, err := c.sale.QueryByProductID(ctx, productID)
+	if err != nil {
+		return nil, fmt.Errorf("query sales: %w", err)
+	}
+
+	return sales, nil
+}
@@ -0,0 +1,117 @@
+package sale_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/mihailtudos/service3/business/core/sale"
+	"github.com/mihailtudos/service3/business/data/store/product"
+	saleStore "github.com/mihailtudos/service3/business/data/store/sale"
+	"github.com/mihailtudos/service3/business/data/tests"
+	"github.com/mihailtudos/service3/business/sys/auth"
+)
+
+var dbc = tests.DBContainer{
+	Image: "postgres:17-alpine",
+	Port:  "5432",
+	Args:  []string{"-e", "POSTGRES_PASSWORD=postgres"},
+}
+
+func TestSale(t *testing.T) {
+	log, db, teardown := tests.NewUnit(t, dbc)
+	t.Cleanup(teardown)
+
+	core := sale.NewCore(log, db, auth.DefaultPolicy())
+	productStore := product.NewStore(db, log)
+
+	t.Log("Given the need to work with Sale records.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen recording a single Sale.", testID)
+		{
+			ctx := context.Background()
+			now := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+			// The seeded "User Gopher" account buys the seeded "Board Game".
+			const productID = "ccfb2ff4-3a1b-4373-9991-7dfb29471f99"
+			claims := auth.Claims{
+				RegisteredClaims: jwt.RegisteredClaims{
+					Subject:   "45b5fbd3-755f-4379-8f07-a58d4a30fa2f",
+					Issuer:    "service project",
+					ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+					IssuedAt:  jwt.NewNumericDate(time.Now()),
+				},
+				Roles: []string{auth.RoleUser},
+			}
+
+			before, err := productStore.QueryByID(ctx, productID)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to retrieve the product : %v", tests.Failed, testID, err)
+			}
+
+			ns := saleStore.NewSale{
+				ProductID: productID,
+				Quantity:  3,
+			}
+
+			sl, err := core.Create(ctx, claims, ns, now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to record a sale : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to record a sale.", tests.Success, testID)
+
+			if exp := before.Cost * ns.Quantity; sl.Paid != exp {
+				t.Logf("\t\tTest %d:\texp: %v", testID, exp)
+				t.Logf("\t\tTest %d:\tgot: %v", testID, sl.Paid)
+				t.Fatalf("\t%s\tTest %d:\tShould calculate the amount paid from the cost.", tests.Failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould calculate the amount paid from the cost.", tests.Success, testID)
+
+			after, err := productStore.QueryByID(ctx, productID)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to retrieve the product : %v", tests.Failed, testID, err)
+			}
+
+			if exp := before.Quantity - ns.Quantity; after.Quantity != exp {
+				t.Logf("\t\tTest %d:\texp: %v", testID, exp)
+				t.Logf("\t\tTest %d:\tgot: %v", testID, after.Quantity)
+				t.Fatalf("\t%s\tTest %d:\tShould decrement the product stock.", tests.Failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould decrement the product stock.", tests.Success, testID)
+
+			ns.Quantity = after.Quantity + 1
+			if _, err := core.Create(ctx, claims, ns, now); !errors.Is(err, product.ErrInsufficientStock) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to oversell a product : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to oversell a product.", tests.Success, testID)
+
+			unchanged, err := productStore.QueryByID(ctx, productID)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to retrieve the product : %v", tests.Failed, testID, err)
+			}
+			if unchanged.Quantity != after.Quantity {
+				t.Fatalf("\t%s\tTest %d:\tShould leave the stock untouched on a failed sale.", tests.Failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould leave the stock untouched on a failed sale.", tests.Success, testID)
+
+			sales, err := core.QueryByUserID(ctx, claims, claims.Subject)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to retrieve sales by user : %v", tests.Failed, testID, err)
+			}
+
+			var found bool
+			for _, s := range sales {
+				if s.ID == sl.ID {
+					found = true
+				}
+			}
+			if !found {
+				t.Fatalf("\t%s\tTest %d:\tShould find the sale in the user's sales.", tests.Failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould find the sale in the user's sales.", tests.Success, testID)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mihailtudos/service3/business/data/store/audit"
+	"github.com/mihailtudos/service3/business/data/store/user"
+	"github.com/mihailtudos/service3/business/sys/auth"
+	"github.com/mihailtudos/service3/business/sys/database"
+	"github.com/mihailtudos/service3/business/sys/validate"
+	"github.com/mihailtudos/service3/foundation/web"
+)
+
+// Set of sizes used to move users in bulk. Exports read the users a page at a
+// time and imports insert them a batch per transaction.
+const (
+	exportPageSize  = 500
+	importBatchSize = 100
+)
+
+// errDryRun rolls back the transaction of a dry run import.
+var errDryRun = errors.New("dry run")
+
+// ImportResult reports what happened to a single row of an import. Rows are
+// numbered from 1.
+type ImportResult struct {
+	Row    int                  `json:"row"`
+	ID     string               `json:"id,omitempty"`
+	Email  string               `json:"email,omitempty"`
+	Errors validate.FieldErrors `json:"errors,omitempty"`
+}
+
+// ImportReport reports the outcome of an import row by row. In a dry run
+// Created counts the users that would have been created.
+type ImportReport struct {
+	DryRun  bool           `json:"dry_run"`
+	Created int            `json:"created"`
+	Failed  int            `json:"failed"`
+	Rows    []ImportResult `json:"rows"`
+}
+
+// Export calls fn for every user matching the filter in the default order,
+// reading them a page at a time.
+func (c Core) Export(ctx context.Context, filter user.QueryFilter, fn func(user.User) error) error {
+	var cursor string
+	for {
+		users, next, err := c.user.Query(ctx, filter, user.DefaultOrderBy, cursor, exportPageSize)
+		if err != nil {
+			if errors.Is(err, database.ErrNotFound) {
+				return nil
+			}
+			return fmt.Errorf("export users: %w", err)
+		}
+
+		for _, usr := range users {
+			if err := fn(usr); err != nil {
+				return fmt.Errorf("export users: %w", err)
+			}
+		}
+
+		if next == "" {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// Import creates the users returned by next until it returns io.EOF. next
+// reports a malformed row with validate.FieldErrors, which fails that row
+// only; any other error stops the import. Each row is validated like a single
+// create, and the users are inserted a batch per transaction so a row that
+// fails doesn't undo the others. A dry run does all the same work and rolls
+// every batch back. Batches committed before an import is stopped are kept.
+func (c Core) Import(ctx context.Context, claims auth.Claims, next func() (user.NewUser, error), dryRun bool, now time.Time) (ImportReport, error) {
+	report := ImportReport{
+		DryRun: dryRun,
+		Rows:   []ImportResult{},
+	}
+
+	// Malformed rows go through the batch too, so the report stays in row
+	// order.
+	type row struct {
+		num    int
+		nu     user.NewUser
+		errors validate.FieldErrors
+	}
+	batch := make([]row, 0, importBatchSize)
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+
+		var results []ImportResult
+		var created []user.User
+
+		f := func(tx sqlx.ExtContext) error {
+			results, created = results[:0], created[:0]
+
+			for _, r := range batch {
+				res := ImportResult{Row: r.num, Email: r.nu.Email}
+				if r.errors != nil {
+					res.Errors = r.errors
+					results = append(results, res)
+					continue
+				}
+
+				var usr user.User
+				err := database.WithinSavepoint(ctx, c.log, tx, func(tx sqlx.ExtContext) error {
+					var err error
+					if usr, err = c.user.Tran(tx).Create(ctx, r.nu, now); err != nil {
+						return err
+					}
+
+					return c.record(ctx, tx, claims.Subject, audit.ActionUserCreate, usr.ID, nil, usr, now)
+				})
+
+				var fields validate.FieldErrors
+				switch {
+				case err == nil:
+					res.ID = usr.ID
+					created = append(created, usr)
+				case errors.As(err, &fields):
+					res.Errors = fields
+				default:
+					return fmt.Errorf("row %d: %w", r.num, err)
+				}
+
+				results = append(results, res)
+			}
+
+			if dryRun {
+				return errDryRun
+			}
+			return nil
+		}
+
+		if err := database.WithinTran(ctx, c.log, c.db, f); err != nil && !errors.Is(err, errDryRun) {
+			return err
+		}
+
+		for _, res := range results {
+			if res.Errors != nil {
+				report.Failed++
+				continue
+			}
+			report.Created++
+		}
+		report.Rows = append(report.Rows, results...)
+		batch = batch[:0]
+
+		if !dryRun {
+			for _, usr := range created {
+				if err := c.sendVerification(ctx, usr, now); err != nil {
+					c.log.Errorw("import users", "traceID", web.GetTraceID(ctx), "userID", usr.ID, "ERROR", err)
+				}
+			}
+		}
+
+		return nil
+	}
+
+	for num := 1; ; num++ {
+		nu, err := next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		var fields validate.FieldErrors
+		if err != nil && !errors.As(err, &fields) {
+			return report, fmt.Errorf("import users: row %d: %w", num, err)
+		}
+
+		batch = append(batch, row{num: num, nu: nu, errors: fields})
+		if len(batch) == importBatchSize {
+			if err := flush(); err != nil {
+				return report, fmt.Errorf("import users: %w", err)
+			}
+		}
+	}
+
+	if err := flush(); err != nil {
+		return report, fmt.Errorf("import users: %w", err)
+	}
+
+	return report, nil
+}
@@ -6,70 +6,377 @@ package user
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/jmoiron/sqlx"
+	"github.com/mihailtudos/service3/business/data/order"
+	"github.com/mihailtudos/service3/business/data/store/attempt"
+	"github.com/mihailtudos/service3/business/data/store/audit"
+	"github.com/mihailtudos/service3/business/data/store/identity"
+	"github.com/mihailtudos/service3/business/data/store/mfa"
+	"github.com/mihailtudos/service3/business/data/store/token"
 	"github.com/mihailtudos/service3/business/data/store/user"
 	"github.com/mihailtudos/service3/business/sys/auth"
+	"github.com/mihailtudos/service3/business/sys/database"
+	"github.com/mihailtudos/service3/business/sys/password"
+	"github.com/mihailtudos/service3/business/sys/validate"
+	"github.com/mihailtudos/service3/foundation/mail"
+	"github.com/mihailtudos/service3/foundation/oidc"
+	"github.com/mihailtudos/service3/foundation/totp"
+	"github.com/mihailtudos/service3/foundation/web"
 	"go.uber.org/zap"
+	"strings"
 	"time"
 )
 
+// RefreshTokenTTL is how long a refresh token can be used before the user
+// has to authenticate again.
+const RefreshTokenTTL = 30 * 24 * time.Hour
+
+// Set of lifetimes for the tokens mailed to users.
+const (
+	ResetTokenTTL  = time.Hour
+	VerifyTokenTTL = 24 * time.Hour
+)
+
+// Set of settings for two-factor authentication. A password login for a user
+// with TOTP enabled only gets a challenge, which has to be completed with a
+// code within ChallengeTTL.
+const (
+	ChallengeTTL  = 5 * time.Minute
+	totpIssuer    = "Sales API"
+	totpSkew      = 1
+	recoveryCodes = 10
+)
+
+// ErrNotDeleted is returned when purging a user that hasn't been deleted.
+var ErrNotDeleted = validate.NewError(validate.KindConflict, validate.CodeNotDeleted, "user has to be deleted before it is purged")
+
+// ErrEmailInUse is returned when restoring a user whose email was taken by
+// another user since it was deleted.
+var ErrEmailInUse = validate.NewError(validate.KindConflict, validate.CodeEmailInUse, "email is used by another user")
+
+// ErrTOTPEnabled is returned when enrolling a user that already has TOTP
+// enabled.
+var ErrTOTPEnabled = validate.NewError(validate.KindConflict, validate.CodeTOTPEnabled, "two-factor authentication already enabled")
+
+// Set of mails sent to users, formatted with the name of the user, the token
+// and how long the token is valid for.
+const (
+	resetSubject = "Reset your password"
+	resetBody    = `Hello %s,
+
+Someone asked to reset the password of your account. If it was you, use this
+token to choose a new password:
+
+%s
+
+The token expires in %s. If you didn't ask for it you can ignore this email.
+`
+
+	verifySubject = "Verify your email"
+	verifyBody    = `Hello %s,
+
+Use this token to verify your email address:
+
+%s
+
+The token expires in %s.
+`
+)
+
+// Set of limits applied to failed logins. Failures are counted per account
+// and per source address over a sliding window. After a few free failures
+// each new failure on an account delays the next attempt, doubling every
+// time, until the account is locked. Source addresses are only locked, and
+// at a higher count, since many users can share one.
+const (
+	loginWindow            = 15 * time.Minute
+	loginFreeFailures      = 3
+	loginMaxDelay          = time.Minute
+	accountLockoutFailures = 10
+	sourceLockoutFailures  = 50
+	lockoutDuration        = 15 * time.Minute
+)
+
+// LockedError is returned when login attempts are blocked after too many
+// failures.
+type LockedError struct {
+	RetryAfter time.Duration
+}
+
+// Error implements the error interface.
+func (e *LockedError) Error() string {
+	return "too many failed login attempts"
+}
+
+// Core manages the set of APIs for user access.
 type Core struct {
-	user user.Store
-	log  *zap.SugaredLogger
+	db       *sqlx.DB
+	user     user.Store
+	token    token.Store
+	attempt  attempt.Store
+	mfa      mfa.Store
+	identity identity.Store
+	audit    audit.Store
+	policy   *auth.Policy
+	mailer   mail.Mailer
+	log      *zap.SugaredLogger
 }
 
-func NewCore(log *zap.SugaredLogger, db *sqlx.DB) Core {
+// NewCore constructs a core for user api access.
+func NewCore(log *zap.SugaredLogger, db *sqlx.DB, policy *auth.Policy, mailer mail.Mailer) Core {
 	return Core{
-		log:  log,
-		user: user.NewStore(db, log),
+		db:       db,
+		log:      log,
+		user:     user.NewStore(db, log),
+		token:    token.NewStore(db, log),
+		attempt:  attempt.NewStore(db, log),
+		mfa:      mfa.NewStore(db, log),
+		identity: identity.NewStore(db, log),
+		audit:    audit.NewStore(db, log),
+		policy:   policy,
+		mailer:   mailer,
 	}
 }
 
-func (c Core) Create(ctx context.Context, nu user.NewUser, now time.Time) (user.User, error) {
+// WithPasswords returns a new Core hashing passwords with the hasher and
+// checking new passwords against the policy.
+func (c Core) WithPasswords(hasher user.PasswordHasher, policy password.Policy) Core {
+	c.user = c.user.WithPasswords(hasher, policy)
+	return c
+}
 
-	u, err := c.user.Create(ctx, nu, now)
-	if err != nil {
+// Create adds a new user and mails them a token to verify their email. The
+// user is created even when the mail can't be sent.
+func (c Core) Create(ctx context.Context, claims auth.Claims, nu user.NewUser, now time.Time) (user.User, error) {
+	var u user.User
+
+	f := func(tx sqlx.ExtContext) error {
+		var err error
+		u, err = c.user.Tran(tx).Create(ctx, nu, now)
+		if err != nil {
+			return err
+		}
+
+		return c.record(ctx, tx, claims.Subject, audit.ActionUserCreate, u.ID, nil, u, now)
+	}
+
+	if err := database.WithinTran(ctx, c.log, c.db, f); err != nil {
 		return user.User{}, fmt.Errorf("create user: %w", err)
 	}
 
+	if err := c.sendVerification(ctx, u, now); err != nil {
+		c.log.Errorw("create user", "traceID", web.GetTraceID(ctx), "userID", u.ID, "ERROR", err)
+	}
+
 	return u, nil
 }
 
-func (c Core) Update(ctx context.Context, claims auth.Claims, userID string, uu user.UpdateUser, now time.Time) error {
+// Update modifies a user. Only that user or a user allowed to write any user
+// can update it. The version the caller last saw has to be provided, or
+// user.AnyVersion, and database.ErrConflict is returned when it is stale.
+// The updated user is returned, carrying its new version.
+func (c Core) Update(ctx context.Context, claims auth.Claims, userID string, version int, uu user.UpdateUser, now time.Time) (user.User, error) {
+	if !c.policy.AllowedOwner(claims, auth.PermUsersWrite, userID) {
+		return user.User{}, database.ErrForbidden
+	}
 
-	err := c.user.Update(ctx, claims, userID, uu, now)
-	if err != nil {
-		return fmt.Errorf("update user: %w", err)
+	var u user.User
+
+	f := func(tx sqlx.ExtContext) error {
+		us := c.user.Tran(tx)
+
+		before, err := us.QueryByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+
+		if err := us.Update(ctx, userID, version, uu, now); err != nil {
+			return err
+		}
+
+		u, err = us.QueryByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+
+		return c.record(ctx, tx, claims.Subject, audit.ActionUserUpdate, userID, before, u, now)
 	}
 
-	return nil
+	if err := database.WithinTran(ctx, c.log, c.db, f); err != nil {
+		return user.User{}, fmt.Errorf("update user: %w", err)
+	}
+
+	// A changed email has to be verified again.
+	if uu.Email != nil && !u.EmailVerified {
+		if err := c.sendVerification(ctx, u, now); err != nil {
+			c.log.Errorw("update user", "traceID", web.GetTraceID(ctx), "userID", u.ID, "ERROR", err)
+		}
+	}
+
+	return u, nil
 }
 
-// Delete removes a user from the database.
-func (c Core) Delete(ctx context.Context, claims auth.Claims, userID string) error {
+// Delete marks a user as deleted and revokes its refresh tokens. Its access
+// tokens are refused from then on since the revocation list checks whether
+// the user still exists. Only that user or a user allowed to write any user
+// can delete it. The version the
+// caller last saw has to be provided, or user.AnyVersion, and
+// database.ErrConflict is returned when it is stale.
+func (c Core) Delete(ctx context.Context, claims auth.Claims, userID string, version int, now time.Time) error {
+	if !c.policy.AllowedOwner(claims, auth.PermUsersWrite, userID) {
+		return database.ErrForbidden
+	}
 
-	err := c.user.Delete(ctx, claims, userID)
-	if err != nil {
+	f := func(tx sqlx.ExtContext) error {
+		us := c.user.Tran(tx)
+
+		before, err := us.QueryByID(ctx, userID)
+		if err != nil {
+			// Deleting a user that doesn't exist is not an error.
+			if errors.Is(err, database.ErrNotFound) {
+				return nil
+			}
+			return err
+		}
+
+		if err := us.Delete(ctx, userID, version, now); err != nil {
+			return err
+		}
+
+		if err := c.token.Tran(tx).RevokeUser(ctx, userID, now); err != nil {
+			return err
+		}
+
+		after, err := us.QueryDeletedByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+
+		return c.record(ctx, tx, claims.Subject, audit.ActionUserDelete, userID, before, after, now)
+	}
+
+	if err := database.WithinTran(ctx, c.log, c.db, f); err != nil {
 		return fmt.Errorf("delete user: %w", err)
 	}
 
 	return nil
 }
 
-// Query retrieves a list of existing users from the
-func (c Core) Query(ctx context.Context, pageNumber int, rowsPerPage int) ([]user.User, error) {
-	users, err := c.user.Query(ctx, pageNumber, rowsPerPage)
+// Restore brings back a deleted user. Emails are only unique among users
+// that aren't deleted, so when another user has taken the email since the
+// user was deleted ErrEmailInUse is returned, and the other user's email has
+// to be changed first.
+func (c Core) Restore(ctx context.Context, claims auth.Claims, userID string, now time.Time) error {
+	f := func(tx sqlx.ExtContext) error {
+		us := c.user.Tran(tx)
+
+		before, err := us.QueryDeletedByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+
+		if err := us.Restore(ctx, userID, now); err != nil {
+			if errors.Is(err, database.ErrDuplicate) {
+				return ErrEmailInUse
+			}
+			return err
+		}
+
+		after, err := us.QueryByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+
+		return c.record(ctx, tx, claims.Subject, audit.ActionUserRestore, userID, before, after, now)
+	}
+
+	if err := database.WithinTran(ctx, c.log, c.db, f); err != nil {
+		return fmt.Errorf("restore user: %w", err)
+	}
+
+	return nil
+}
+
+// Purge removes a deleted user from the database for good, along with its
+// products and sales. Users have to be deleted before they can be purged.
+func (c Core) Purge(ctx context.Context, claims auth.Claims, userID string, now time.Time) error {
+	f := func(tx sqlx.ExtContext) error {
+		us := c.user.Tran(tx)
+
+		before, err := us.QueryDeletedByID(ctx, userID)
+		if err != nil {
+			if errors.Is(err, database.ErrNotFound) {
+				if _, err := us.QueryByID(ctx, userID); err == nil {
+					return ErrNotDeleted
+				}
+			}
+			return err
+		}
+
+		if err := us.Purge(ctx, userID); err != nil {
+			return err
+		}
+
+		return c.record(ctx, tx, claims.Subject, audit.ActionUserPurge, userID, before, nil, now)
+	}
+
+	if err := database.WithinTran(ctx, c.log, c.db, f); err != nil {
+		return fmt.Errorf("purge user: %w", err)
+	}
+
+	return nil
+}
+
+// record adds an event for a change made to a user to the audit log, within
+// the transaction making the change.
+func (c Core) record(ctx context.Context, tx sqlx.ExtContext, actorID string, action string, userID string, before any, after any, now time.Time) error {
+	ne := audit.NewEvent{
+		ActorID:    actorID,
+		Action:     action,
+		TargetType: audit.TargetUser,
+		TargetID:   userID,
+		Diff:       audit.NewDiff(before, after),
+		TraceID:    web.GetTraceID(ctx),
+	}
+
+	if _, err := c.audit.Tran(tx).Create(ctx, ne, now); err != nil {
+		return fmt.Errorf("recording %s: %w", action, err)
+	}
+
+	return nil
+}
+
+// Query retrieves a page of existing users from the database along with the
+// cursor for the next page.
+func (c Core) Query(ctx context.Context, filter user.QueryFilter, orderBy order.By, cursor string, rowsPerPage int) ([]user.User, string, error) {
+	users, next, err := c.user.Query(ctx, filter, orderBy, cursor, rowsPerPage)
 	if err != nil {
-		return nil, fmt.Errorf("query users: %w", err)
+		return nil, "", fmt.Errorf("query users: %w", err)
 	}
 
-	return users, nil
+	return users, next, nil
 }
 
-// QueryByID gets the specified user from the database.
+// Count returns the total number of users matching the filter.
+func (c Core) Count(ctx context.Context, filter user.QueryFilter) (int, error) {
+	n, err := c.user.Count(ctx, filter)
+	if err != nil {
+		return 0, fmt.Errorf("count users: %w", err)
+	}
+
+	return n, nil
+}
+
+// QueryByID gets the specified user from the database. Only that user or a
+// user allowed to read any user can see it.
 func (c Core) QueryByID(ctx context.Context, claims auth.Claims, userID string) (user.User, error) {
-	u, err := c.user.QueryByID(ctx, claims, userID)
+	if !c.policy.AllowedOwner(claims, auth.PermUsersRead, userID) {
+		return user.User{}, database.ErrForbidden
+	}
+
+	u, err := c.user.QueryByID(ctx, userID)
 	if err != nil {
 		return user.User{}, fmt.Errorf("query user: %w", err)
 	}
@@ -77,22 +384,848 @@ func (c Core) QueryByID(ctx context.Context, claims auth.Claims, userID string)
 }
 
 // QueryByEmail gets the specified user from the database by email address.
+// Only that user or a user allowed to read any user can see it.
 func (c Core) QueryByEmail(ctx context.Context, claims auth.Claims, email string) (user.User, error) {
-	u, err := c.user.QueryByEmail(ctx, claims, email)
+	u, err := c.user.QueryByEmail(ctx, email)
 	if err != nil {
 		return user.User{}, fmt.Errorf("query user: %w", err)
 	}
+
+	if !c.policy.AllowedOwner(claims, auth.PermUsersRead, u.ID) {
+		return user.User{}, database.ErrForbidden
+	}
+
 	return u, nil
 }
 
 // Authenticate finds a user by their email and verifies their password. On
 // success it returns a Claims User representing this user. The claims can be
-// used to generate a token for future authentication.
-func (c Core) Authenticate(ctx context.Context, now time.Time, email, password string) (auth.Claims, error) {
+// used to generate a token for future authentication. Attempts are tracked
+// for the email and the source address of the request, and once there are
+// too many failures a LockedError is returned without checking the password.
+// The attempts on the account are only forgotten once the user is fully
+// logged in, so for users with a second factor that waits for the challenge
+// to be completed.
+func (c Core) Authenticate(ctx context.Context, now time.Time, email, password string, sourceIP string) (auth.Claims, error) {
+	keys := loginKeys(email, sourceIP)
+
+	// The attempt is counted as a failure before the password is checked,
+	// so parallel guesses can't all get through before the first failure
+	// is recorded.
+	f := func(tx sqlx.ExtContext) error {
+		return c.countAttempt(ctx, c.attempt.Tran(tx), now, keys)
+	}
+	if err := database.WithinTran(ctx, c.log, c.db, f); err != nil {
+		var locked *LockedError
+		if errors.As(err, &locked) {
+			return auth.Claims{}, locked
+		}
+		return auth.Claims{}, fmt.Errorf("authenticate: %w", err)
+	}
+
 	claims, err := c.user.Authenticate(ctx, now, email, password)
 	if err != nil {
 		return auth.Claims{}, fmt.Errorf("authenticate: %w", err)
 	}
 
+	enabled, err := mfaEnabled(ctx, c.mfa, claims.Subject)
+	if err != nil {
+		return auth.Claims{}, fmt.Errorf("authenticate: %w", err)
+	}
+
+	if !enabled {
+		if err := c.attempt.Reset(ctx, keys[0]); err != nil {
+			return auth.Claims{}, fmt.Errorf("authenticate: %w", err)
+		}
+	}
+	for _, key := range keys[1:] {
+		if err := c.attempt.Forgive(ctx, key); err != nil {
+			return auth.Claims{}, fmt.Errorf("authenticate: %w", err)
+		}
+	}
+
 	return claims, nil
 }
+
+// loginKeys returns the keys login attempts are tracked under, the account
+// first and then the source address when it is known.
+func loginKeys(email string, sourceIP string) []string {
+	keys := []string{attempt.AccountKey(email)}
+	if sourceIP != "" {
+		keys = append(keys, attempt.SourceKey(sourceIP))
+	}
+	return keys
+}
+
+// countAttempt locks the attempts of the keys, fails with a LockedError when
+// one of them is blocked and otherwise counts the attempt as a failure
+// against every key, blocking them when they reach the limits. The store has
+// to be bound to a transaction so the keys stay locked until the attempt is
+// counted.
+func (c Core) countAttempt(ctx context.Context, store attempt.Store, now time.Time, keys []string) error {
+	for _, key := range keys {
+		a, err := store.Lock(ctx, key, now)
+		if err != nil {
+			return err
+		}
+
+		if a.DateBlockedUntil != nil && now.Before(*a.DateBlockedUntil) {
+			return &LockedError{RetryAfter: a.DateBlockedUntil.Sub(now)}
+		}
+	}
+
+	for i, key := range keys {
+		a, err := store.RecordFailure(ctx, key, now, loginWindow)
+		if err != nil {
+			return err
+		}
+
+		var block time.Duration
+		switch account := i == 0; {
+		case account && a.Failures >= accountLockoutFailures:
+			block = lockoutDuration
+		case account && a.Failures >= loginFreeFailures:
+			block = loginMaxDelay
+			if n := a.Failures - loginFreeFailures; n < 6 {
+				block = min(time.Second<<n, loginMaxDelay)
+			}
+		case !account && a.Failures >= sourceLockoutFailures:
+			block = lockoutDuration
+		}
+
+		if block > 0 {
+			if err := store.Block(ctx, key, now.Add(block)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Unlock forgets the failed login attempts made against the user's account,
+// lifting any lockout.
+func (c Core) Unlock(ctx context.Context, claims auth.Claims, userID string, now time.Time) error {
+	f := func(tx sqlx.ExtContext) error {
+		usr, err := c.user.Tran(tx).QueryByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+
+		as := c.attempt.Tran(tx)
+		key := attempt.AccountKey(usr.Email)
+
+		var before any
+		a, err := as.QueryByKey(ctx, key)
+		switch {
+		case err == nil:
+			before = a
+		case !errors.Is(err, database.ErrNotFound):
+			return err
+		}
+
+		if err := as.Reset(ctx, key); err != nil {
+			return err
+		}
+
+		return c.record(ctx, tx, claims.Subject, audit.ActionUserUnlock, userID, before, nil, now)
+	}
+
+	if err := database.WithinTran(ctx, c.log, c.db, f); err != nil {
+		return fmt.Errorf("unlock user: %w", err)
+	}
+
+	return nil
+}
+
+// ForgotPassword mails a token to reset the password of the user with the
+// email. When there is no such user nothing is sent and no error is returned,
+// so callers can't tell which emails have an account.
+func (c Core) ForgotPassword(ctx context.Context, email string, now time.Time) error {
+	usr, err := c.user.QueryByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return nil
+		}
+		return fmt.Errorf("forgot password: %w", err)
+	}
+
+	tkn, _, err := c.token.CreateAction(ctx, usr.ID, token.PurposeResetPassword, usr.Email, now, ResetTokenTTL)
+	if err != nil {
+		return fmt.Errorf("forgot password: %w", err)
+	}
+
+	msg := mail.Message{
+		To:      usr.Email,
+		Subject: resetSubject,
+		Body:    fmt.Sprintf(resetBody, usr.Name, tkn, formatTTL(ResetTokenTTL)),
+	}
+
+	if err := c.mailer.Send(ctx, msg); err != nil {
+		return fmt.Errorf("forgot password: %w", err)
+	}
+
+	return nil
+}
+
+// ResetPassword sets a new password for the user the reset token was mailed
+// to. Using the token proves the user owns the email, so it is marked as
+// verified too. Every refresh token of the user is revoked and any lockout
+// is lifted.
+func (c Core) ResetPassword(ctx context.Context, resetToken string, password string, now time.Time) error {
+	var email string
+
+	f := func(tx sqlx.ExtContext) error {
+		usr, err := c.useAction(ctx, tx, token.PurposeResetPassword, resetToken, now)
+		if err != nil {
+			return err
+		}
+		email = usr.Email
+
+		us := c.user.Tran(tx)
+		if err := us.Update(ctx, usr.ID, user.AnyVersion, user.UpdateUser{Password: &password}, now); err != nil {
+			return err
+		}
+
+		if err := us.VerifyEmail(ctx, usr.ID, usr.Email, now); err != nil {
+			return err
+		}
+
+		after, err := us.QueryByID(ctx, usr.ID)
+		if err != nil {
+			return err
+		}
+
+		// The token proves who the user is, so they are the actor.
+		if err := c.record(ctx, tx, usr.ID, audit.ActionUserResetPassword, usr.ID, usr, after, now); err != nil {
+			return err
+		}
+
+		return c.token.Tran(tx).RevokeUser(ctx, usr.ID, now)
+	}
+
+	if err := database.WithinTran(ctx, c.log, c.db, f); err != nil {
+		return fmt.Errorf("reset password: %w", err)
+	}
+
+	if err := c.attempt.Reset(ctx, attempt.AccountKey(email)); err != nil {
+		return fmt.Errorf("reset password: %w", err)
+	}
+
+	return nil
+}
+
+// VerifyEmail marks the email the verification token was mailed to as
+// verified.
+func (c Core) VerifyEmail(ctx context.Context, verifyToken string, now time.Time) error {
+	f := func(tx sqlx.ExtContext) error {
+		usr, err := c.useAction(ctx, tx, token.PurposeVerifyEmail, verifyToken, now)
+		if err != nil {
+			return err
+		}
+
+		return c.user.Tran(tx).VerifyEmail(ctx, usr.ID, usr.Email, now)
+	}
+
+	if err := database.WithinTran(ctx, c.log, c.db, f); err != nil {
+		return fmt.Errorf("verify email: %w", err)
+	}
+
+	return nil
+}
+
+// useAction checks the action token and uses up every token the user has for
+// the same purpose. It returns the user the token was mailed to. A token is
+// rejected once the user's email is no longer the one it was mailed to.
+func (c Core) useAction(ctx context.Context, tx sqlx.ExtContext, purpose string, tkn string, now time.Time) (user.User, error) {
+	ts := c.token.Tran(tx)
+
+	at, err := ts.QueryActionByToken(ctx, purpose, tkn)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return user.User{}, fmt.Errorf("unknown token: %w", database.ErrAuthenticationFailed)
+		}
+		return user.User{}, err
+	}
+
+	switch {
+	case at.DateUsed != nil:
+		return user.User{}, fmt.Errorf("token already used: %w", database.ErrAuthenticationFailed)
+	case !now.Before(at.DateExpires):
+		return user.User{}, fmt.Errorf("token expired: %w", database.ErrAuthenticationFailed)
+	}
+
+	usr, err := c.user.Tran(tx).QueryByID(ctx, at.UserID)
+	if err != nil {
+		return user.User{}, err
+	}
+
+	if usr.Email != at.Email {
+		return user.User{}, fmt.Errorf("token for a previous email: %w", database.ErrAuthenticationFailed)
+	}
+
+	if err := ts.UseAction(ctx, at.UserID, purpose, now); err != nil {
+		return user.User{}, err
+	}
+
+	return usr, nil
+}
+
+// sendVerification mails the user a token to verify their email.
+func (c Core) sendVerification(ctx context.Context, usr user.User, now time.Time) error {
+	tkn, _, err := c.token.CreateAction(ctx, usr.ID, token.PurposeVerifyEmail, usr.Email, now, VerifyTokenTTL)
+	if err != nil {
+		return err
+	}
+
+	msg := mail.Message{
+		To:      usr.Email,
+		Subject: verifySubject,
+		Body:    fmt.Sprintf(verifyBody, usr.Name, tkn, formatTTL(VerifyTokenTTL)),
+	}
+
+	return c.mailer.Send(ctx, msg)
+}
+
+// formatTTL renders the lifetime of a token without trailing zero units, so
+// an hour reads as 1h rather than 1h0m0s.
+func formatTTL(ttl time.Duration) string {
+	s := ttl.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
+
+// AuthenticateIdentity finds the user linked to an identity at an external
+// provider and returns a Claims User representing them. An identity seen for
+// the first time is linked to the user with the same email, or to a new user
+// with the default roles, but only when the provider verified the email.
+func (c Core) AuthenticateIdentity(ctx context.Context, id oidc.Identity, defaultRoles []string, now time.Time) (auth.Claims, error) {
+	var claims auth.Claims
+
+	f := func(tx sqlx.ExtContext) error {
+		is := c.identity.Tran(tx)
+		us := c.user.Tran(tx)
+
+		link, err := is.QueryBySubject(ctx, id.Issuer, id.Subject)
+		switch {
+		case err == nil:
+			// A deleted user can't log in, even with a linked identity.
+			claims, err = us.Claims(ctx, link.UserID, now)
+			if errors.Is(err, database.ErrNotFound) {
+				return fmt.Errorf("linked user is deleted: %w", database.ErrAuthenticationFailed)
+			}
+			return err
+		case !errors.Is(err, database.ErrNotFound):
+			return err
+		}
+
+		if id.Email == "" || !id.EmailVerified {
+			return fmt.Errorf("identity has no verified email: %w", database.ErrAuthenticationFailed)
+		}
+
+		usr, err := us.QueryByEmail(ctx, id.Email)
+		if err != nil {
+			if !errors.Is(err, database.ErrNotFound) {
+				return err
+			}
+
+			if usr, err = createFromIdentity(ctx, us, id, defaultRoles, now); err != nil {
+				return err
+			}
+
+			// Users signing up through a provider create themselves.
+			if err := c.record(ctx, tx, usr.ID, audit.ActionUserCreate, usr.ID, nil, usr, now); err != nil {
+				return err
+			}
+		}
+
+		if _, err := is.Create(ctx, id.Issuer, id.Subject, usr.ID, now); err != nil {
+			return err
+		}
+
+		if err := us.VerifyEmail(ctx, usr.ID, usr.Email, now); err != nil {
+			return err
+		}
+
+		claims, err = us.Claims(ctx, usr.ID, now)
+		return err
+	}
+
+	if err := database.WithinTran(ctx, c.log, c.db, f); err != nil {
+		return auth.Claims{}, fmt.Errorf("authenticate identity: %w", err)
+	}
+
+	return claims, nil
+}
+
+// createFromIdentity adds a user for an identity at an external provider. The
+// user gets a random password nobody knows, so they can only log in through
+// the provider until they reset it.
+func createFromIdentity(ctx context.Context, us user.Store, id oidc.Identity, roles []string, now time.Time) (user.User, error) {
+	random, err := oidc.RandomString()
+	if err != nil {
+		return user.User{}, err
+	}
+
+	// The prefix makes sure the password has every class of characters a
+	// policy can ask for.
+	pw := "Aa1-" + random
+
+	name := id.Name
+	if name == "" {
+		name = id.Email
+	}
+
+	nu := user.NewUser{
+		Name:            name,
+		Email:           id.Email,
+		Roles:           roles,
+		Password:        pw,
+		PasswordConfirm: pw,
+	}
+
+	return us.Create(ctx, nu, now)
+}
+
+// EnrollTOTP generates a new TOTP secret for the user, pending until it is
+// confirmed with a code. It returns the secret and the otpauth URI to add it
+// to an authenticator app. Users can only enrol themselves.
+func (c Core) EnrollTOTP(ctx context.Context, claims auth.Claims, userID string, now time.Time) (string, string, error) {
+	if claims.Subject != userID || !c.policy.AllowedOwner(claims, auth.PermUsersWrite, userID) {
+		return "", "", database.ErrForbidden
+	}
+
+	var secret, uri string
+
+	f := func(tx sqlx.ExtContext) error {
+		usr, err := c.user.Tran(tx).QueryByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+
+		ms := c.mfa.Tran(tx)
+
+		var before any
+		t, err := ms.QueryByUserID(ctx, userID)
+		switch {
+		case err == nil && t.DateEnabled != nil:
+			return ErrTOTPEnabled
+		case err == nil:
+			before = t
+		case !errors.Is(err, database.ErrNotFound):
+			return err
+		}
+
+		secret, err = totp.GenerateSecret()
+		if err != nil {
+			return err
+		}
+
+		if err := ms.SetSecret(ctx, userID, secret, now); err != nil {
+			return err
+		}
+
+		after, err := ms.QueryByUserID(ctx, userID)
+		if err != nil {
+			return err
+		}
+
+		uri = totp.URI(totpIssuer, usr.Email, secret)
+
+		return c.record(ctx, tx, claims.Subject, audit.ActionUserEnrollTOTP, userID, before, after, now)
+	}
+
+	if err := database.WithinTran(ctx, c.log, c.db, f); err != nil {
+		return "", "", fmt.Errorf("enroll totp: %w", err)
+	}
+
+	return secret, uri, nil
+}
+
+// ConfirmTOTP enables the pending TOTP secret of the user once they prove
+// their authenticator app generates the right codes. It returns a new set of
+// recovery codes, which are only ever returned here.
+func (c Core) ConfirmTOTP(ctx context.Context, claims auth.Claims, userID string, code string, now time.Time) ([]string, error) {
+	if claims.Subject != userID || !c.policy.AllowedOwner(claims, auth.PermUsersWrite, userID) {
+		return nil, database.ErrForbidden
+	}
+
+	var codes []string
+
+	f := func(tx sqlx.ExtContext) error {
+		ms := c.mfa.Tran(tx)
+
+		t, err := ms.QueryByUserID(ctx, userID)
+		if err != nil {
+			return err
+		}
+
+		if t.DateEnabled != nil {
+			return ErrTOTPEnabled
+		}
+
+		step, ok, err := totp.Validate(t.Secret, code, now, totpSkew)
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			return validate.FieldErrors{{Field: "code", Error: "invalid code"}}
+		}
+
+		if err := ms.Enable(ctx, userID, step, now); err != nil {
+			return err
+		}
+
+		codes, err = ms.CreateRecoveryCodes(ctx, userID, recoveryCodes, now)
+		if err != nil {
+			return err
+		}
+
+		after, err := ms.QueryByUserID(ctx, userID)
+		if err != nil {
+			return err
+		}
+
+		return c.record(ctx, tx, claims.Subject, audit.ActionUserConfirmTOTP, userID, t, after, now)
+	}
+
+	if err := database.WithinTran(ctx, c.log, c.db, f); err != nil {
+		return nil, fmt.Errorf("confirm totp: %w", err)
+	}
+
+	return codes, nil
+}
+
+// DisableTOTP removes the TOTP secret and recovery codes of the user. Only
+// that user or a user allowed to write any user can disable it. Users have to
+// prove they still hold the second factor with a TOTP or unused recovery
+// code, so a stolen token isn't enough to turn it off. Wrong codes count
+// against the account like wrong passwords. A user allowed to write any user
+// doesn't need a code, to help users who lost their second factor.
+func (c Core) DisableTOTP(ctx context.Context, claims auth.Claims, userID string, code string, now time.Time) error {
+	if !c.policy.AllowedOwner(claims, auth.PermUsersWrite, userID) {
+		return database.ErrForbidden
+	}
+
+	admin := c.policy.Allowed(claims, auth.PermUsersWrite)
+	var rejected bool
+
+	f := func(tx sqlx.ExtContext) error {
+		ms := c.mfa.Tran(tx)
+
+		t, err := ms.QueryByUserID(ctx, userID)
+		if err != nil {
+			// Disabling a second factor that isn't there is not an error.
+			if errors.Is(err, database.ErrNotFound) {
+				return nil
+			}
+			return err
+		}
+
+		if t.DateEnabled != nil && !admin {
+			usr, err := c.user.Tran(tx).QueryByID(ctx, userID)
+			if err != nil {
+				return err
+			}
+
+			as := c.attempt.Tran(tx)
+			keys := loginKeys(usr.Email, "")
+
+			if err := c.countAttempt(ctx, as, now, keys); err != nil {
+				return err
+			}
+
+			ok, err := c.checkSecondFactor(ctx, ms, userID, code, now)
+			if err != nil {
+				return err
+			}
+
+			// Counting the failure has to be committed, so a wrong code
+			// isn't reported as an error until the transaction is complete.
+			if !ok {
+				rejected = true
+				return nil
+			}
+
+			if err := as.Reset(ctx, keys[0]); err != nil {
+				return err
+			}
+		}
+
+		if err := ms.Delete(ctx, userID); err != nil {
+			return err
+		}
+
+		return c.record(ctx, tx, claims.Subject, audit.ActionUserDisableTOTP, userID, t, nil, now)
+	}
+
+	if err := database.WithinTran(ctx, c.log, c.db, f); err != nil {
+		var locked *LockedError
+		if errors.As(err, &locked) {
+			return locked
+		}
+		return fmt.Errorf("disable totp: %w", err)
+	}
+
+	if rejected {
+		return fmt.Errorf("disable totp: %w", validate.FieldErrors{{Field: "code", Error: "invalid code"}})
+	}
+
+	return nil
+}
+
+// Challenge returns a challenge the user has to complete with a second factor
+// before getting a token. It returns an empty challenge when the user has no
+// second factor enabled.
+func (c Core) Challenge(ctx context.Context, userID string, now time.Time) (string, error) {
+	enabled, err := mfaEnabled(ctx, c.mfa, userID)
+	if err != nil {
+		return "", fmt.Errorf("challenge: %w", err)
+	}
+
+	if !enabled {
+		return "", nil
+	}
+
+	usr, err := c.user.QueryByID(ctx, userID)
+	if err != nil {
+		return "", fmt.Errorf("challenge: %w", err)
+	}
+
+	tkn, _, err := c.token.CreateAction(ctx, usr.ID, token.PurposeMFAChallenge, usr.Email, now, ChallengeTTL)
+	if err != nil {
+		return "", fmt.Errorf("challenge: %w", err)
+	}
+
+	return tkn, nil
+}
+
+// CompleteChallenge checks the TOTP or recovery code for the challenge and
+// returns the claims of the user. A challenge can only be tried once, a wrong
+// code means logging in with the password again. Codes are counted against
+// the same limits as passwords, for the account and the source address, and
+// once there are too many failures a LockedError is returned without checking
+// the code.
+func (c Core) CompleteChallenge(ctx context.Context, challenge string, code string, sourceIP string, now time.Time) (auth.Claims, error) {
+	var (
+		claims   auth.Claims
+		rejected bool
+	)
+
+	f := func(tx sqlx.ExtContext) error {
+		usr, err := c.useAction(ctx, tx, token.PurposeMFAChallenge, challenge, now)
+		if err != nil {
+			return err
+		}
+
+		as := c.attempt.Tran(tx)
+		keys := loginKeys(usr.Email, sourceIP)
+
+		// Like a password, the code is counted as a failure before it is
+		// checked.
+		if err := c.countAttempt(ctx, as, now, keys); err != nil {
+			return err
+		}
+
+		ok, err := c.checkSecondFactor(ctx, c.mfa.Tran(tx), usr.ID, code, now)
+		if err != nil {
+			return err
+		}
+
+		// Using up the challenge and counting the failure have to be
+		// committed, so a wrong code isn't reported as an error until the
+		// transaction is complete.
+		if !ok {
+			rejected = true
+			return nil
+		}
+
+		if err := as.Reset(ctx, keys[0]); err != nil {
+			return err
+		}
+		for _, key := range keys[1:] {
+			if err := as.Forgive(ctx, key); err != nil {
+				return err
+			}
+		}
+
+		claims, err = c.user.Tran(tx).Claims(ctx, usr.ID, now)
+		return err
+	}
+
+	if err := database.WithinTran(ctx, c.log, c.db, f); err != nil {
+		var locked *LockedError
+		if errors.As(err, &locked) {
+			return auth.Claims{}, locked
+		}
+		return auth.Claims{}, fmt.Errorf("complete challenge: %w", err)
+	}
+
+	if rejected {
+		return auth.Claims{}, fmt.Errorf("complete challenge: invalid code: %w", database.ErrAuthenticationFailed)
+	}
+
+	return claims, nil
+}
+
+// mfaEnabled reports whether the user has a second factor enabled.
+func mfaEnabled(ctx context.Context, ms mfa.Store, userID string) (bool, error) {
+	t, err := ms.QueryByUserID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return t.DateEnabled != nil, nil
+}
+
+// checkSecondFactor accepts a TOTP code not used before or an unused recovery
+// code of the user.
+func (c Core) checkSecondFactor(ctx context.Context, ms mfa.Store, userID string, code string, now time.Time) (bool, error) {
+	t, err := ms.QueryByUserID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if t.DateEnabled == nil {
+		return false, nil
+	}
+
+	step, ok, err := totp.Validate(t.Secret, code, now, totpSkew)
+	if err != nil {
+		return false, err
+	}
+
+	if ok && step > t.LastStep {
+		return true, ms.UpdateLastStep(ctx, userID, step)
+	}
+
+	if err := ms.UseRecoveryCode(ctx, userID, code, now); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// IssueRefreshToken starts a new refresh token family for the user and
+// returns the opaque token to hand to the client.
+func (c Core) IssueRefreshToken(ctx context.Context, userID string, now time.Time) (string, error) {
+	tkn, _, err := c.token.Create(ctx, userID, "", now, RefreshTokenTTL)
+	if err != nil {
+		return "", fmt.Errorf("issue refresh token: %w", err)
+	}
+
+	return tkn, nil
+}
+
+// Refresh exchanges a refresh token for a new set of claims and a new refresh
+// token. The presented token is retired so each refresh token can only be
+// used once. Presenting a token that was already retired is treated as theft
+// and revokes every token in its family.
+func (c Core) Refresh(ctx context.Context, refreshToken string, now time.Time) (auth.Claims, string, error) {
+	var (
+		claims auth.Claims
+		next   string
+		reused bool
+	)
+
+	f := func(tx sqlx.ExtContext) error {
+		ts := c.token.Tran(tx)
+
+		rt, err := ts.QueryByToken(ctx, refreshToken)
+		if err != nil {
+			return err
+		}
+
+		// The revocation of the family has to be committed, so this isn't
+		// reported as an error until the transaction is complete.
+		if rt.DateRevoked != nil {
+			reused = true
+			return ts.RevokeFamily(ctx, rt.FamilyID, now)
+		}
+
+		if !now.Before(rt.DateExpires) {
+			return fmt.Errorf("refresh token expired: %w", database.ErrAuthenticationFailed)
+		}
+
+		claims, err = c.user.Tran(tx).Claims(ctx, rt.UserID, now)
+		if err != nil {
+			return err
+		}
+
+		tkn, nrt, err := ts.Create(ctx, rt.UserID, rt.FamilyID, now, RefreshTokenTTL)
+		if err != nil {
+			return err
+		}
+		next = tkn
+
+		return ts.Revoke(ctx, rt.ID, nrt.ID, now)
+	}
+
+	if err := database.WithinTran(ctx, c.log, c.db, f); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return auth.Claims{}, "", fmt.Errorf("refresh: unknown refresh token: %w", database.ErrAuthenticationFailed)
+		}
+		return auth.Claims{}, "", fmt.Errorf("refresh: %w", err)
+	}
+
+	if reused {
+		c.log.Warnw("refresh token reuse detected", "traceID", web.GetTraceID(ctx))
+		return auth.Claims{}, "", fmt.Errorf("refresh: refresh token reused: %w", database.ErrAuthenticationFailed)
+	}
+
+	return claims, next, nil
+}
+
+// Logout revokes the access token described by the claims and, when one is
+// provided, the family of the refresh token issued alongside it.
+func (c Core) Logout(ctx context.Context, claims auth.Claims, refreshToken string, now time.Time) error {
+	f := func(tx sqlx.ExtContext) error {
+		ts := c.token.Tran(tx)
+
+		if claims.ID != "" && claims.ExpiresAt != nil {
+			if err := ts.RevokeAccess(ctx, claims.ID, claims.ExpiresAt.Time, now); err != nil {
+				return err
+			}
+		}
+
+		if refreshToken == "" {
+			return nil
+		}
+
+		rt, err := ts.QueryByToken(ctx, refreshToken)
+		if err != nil {
+			if errors.Is(err, database.ErrNotFound) {
+				return nil
+			}
+			return err
+		}
+
+		// You can only log out your own sessions.
+		if rt.UserID != claims.Subject {
+			return database.ErrForbidden
+		}
+
+		return ts.RevokeFamily(ctx, rt.FamilyID, now)
+	}
+
+	if err := database.WithinTran(ctx, c.log, c.db, f); err != nil {
+		return fmt.Errorf("logout: %w", err)
+	}
+
+	return nil
+}
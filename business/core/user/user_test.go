@@ -0,0 +1,628 @@
+package user_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	auditCore "github.com/mihailtudos/service3/business/core/audit"
+	"github.com/mihailtudos/service3/business/core/user"
+	"github.com/mihailtudos/service3/business/data/store/audit"
+	"github.com/mihailtudos/service3/business/data/store/token"
+	userStore "github.com/mihailtudos/service3/business/data/store/user"
+	"github.com/mihailtudos/service3/business/data/tests"
+	"github.com/mihailtudos/service3/business/sys/auth"
+	"github.com/mihailtudos/service3/business/sys/database"
+	"github.com/mihailtudos/service3/business/sys/validate"
+	"github.com/mihailtudos/service3/foundation/mail"
+	"github.com/mihailtudos/service3/foundation/oidc"
+	"github.com/mihailtudos/service3/foundation/totp"
+)
+
+var dbc = tests.DBContainer{
+	Image: "postgres:17-alpine",
+	Port:  "5432",
+	Args:  []string{"-e", "POSTGRES_PASSWORD=postgres"},
+}
+
+func TestLockout(t *testing.T) {
+	log, db, teardown := tests.NewUnit(t, dbc)
+	t.Cleanup(teardown)
+
+	core := user.NewCore(log, db, auth.DefaultPolicy(), &mailbox{})
+
+	t.Log("Given the need to stop password guessing.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen logins to an account keep failing.", testID)
+		{
+			ctx := context.Background()
+			now := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+			// The seeded "User Gopher" account.
+			const userID = "45b5fbd3-755f-4379-8f07-a58d4a30fa2f"
+			const email = "user@example.com"
+
+			for i := 0; i < 3; i++ {
+				if _, err := core.Authenticate(ctx, now, email, "wrong", "10.0.0.1"); !errors.Is(err, database.ErrAuthenticationFailed) {
+					t.Fatalf("\t%s\tTest %d:\tShould fail with the wrong password : %v", tests.Failed, testID, err)
+				}
+			}
+			t.Logf("\t%s\tTest %d:\tShould fail with the wrong password.", tests.Success, testID)
+
+			var locked *user.LockedError
+			if _, err := core.Authenticate(ctx, now, email, "gophers", "10.0.0.1"); !errors.As(err, &locked) {
+				t.Fatalf("\t%s\tTest %d:\tShould delay the next attempt : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould delay the next attempt.", tests.Success, testID)
+
+			for i := 0; i < 7; i++ {
+				now = now.Add(time.Minute)
+				if _, err := core.Authenticate(ctx, now, email, "wrong", "10.0.0.1"); err == nil {
+					t.Fatalf("\t%s\tTest %d:\tShould fail with the wrong password.", tests.Failed, testID)
+				}
+			}
+
+			now = now.Add(time.Minute)
+			if _, err := core.Authenticate(ctx, now, email, "gophers", "10.0.0.1"); !errors.As(err, &locked) || locked.RetryAfter < 10*time.Minute {
+				t.Fatalf("\t%s\tTest %d:\tShould lock the account : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould lock the account.", tests.Success, testID)
+
+			// The seeded "Admin Gopher" account unlocks it.
+			admin := auth.Claims{
+				RegisteredClaims: jwt.RegisteredClaims{Subject: "5cf37266-3473-4006-984f-9325122678b7"},
+				Roles:            []string{auth.RoleAdmin},
+			}
+			if err := core.Unlock(ctx, admin, userID, now); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to unlock the account : %v", tests.Failed, testID, err)
+			}
+
+			if _, err := core.Authenticate(ctx, now, email, "gophers", "10.0.0.1"); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould authenticate once unlocked : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould authenticate once unlocked.", tests.Success, testID)
+		}
+
+		testID = 1
+		t.Logf("\tTest %d:\tWhen logging in to an unknown account.", testID)
+		{
+			ctx := context.Background()
+			now := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+			if _, err := core.Authenticate(ctx, now, "nobody@example.com", "gophers", ""); !errors.Is(err, database.ErrAuthenticationFailed) {
+				t.Fatalf("\t%s\tTest %d:\tShould fail like a wrong password : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould fail like a wrong password.", tests.Success, testID)
+		}
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	log, db, teardown := tests.NewUnit(t, dbc)
+	t.Cleanup(teardown)
+
+	mb := &mailbox{}
+	core := user.NewCore(log, db, auth.DefaultPolicy(), mb)
+
+	t.Log("Given the need to reset passwords and verify emails.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen a user forgot their password.", testID)
+		{
+			ctx := context.Background()
+			now := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+			if err := core.ForgotPassword(ctx, "nobody@example.com", now); err != nil || len(mb.msgs) != 0 {
+				t.Fatalf("\t%s\tTest %d:\tShould silently ignore an unknown email : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould silently ignore an unknown email.", tests.Success, testID)
+
+			if err := core.ForgotPassword(ctx, "user@example.com", now); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to ask for a reset : %v", tests.Failed, testID, err)
+			}
+			tkn := mb.token(t)
+			t.Logf("\t%s\tTest %d:\tShould mail a reset token.", tests.Success, testID)
+
+			if err := core.ResetPassword(ctx, tkn, "gophers2", now.Add(2*user.ResetTokenTTL)); !errors.Is(err, database.ErrAuthenticationFailed) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to use an expired token : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to use an expired token.", tests.Success, testID)
+
+			if err := core.ResetPassword(ctx, tkn, "gophers2", now); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to reset the password : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to reset the password.", tests.Success, testID)
+
+			if _, err := core.Authenticate(ctx, now, "user@example.com", "gophers2", ""); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould authenticate with the new password : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould authenticate with the new password.", tests.Success, testID)
+
+			if err := core.ResetPassword(ctx, tkn, "gophers3", now); !errors.Is(err, database.ErrAuthenticationFailed) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to use the token twice : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to use the token twice.", tests.Success, testID)
+		}
+
+		testID = 1
+		t.Logf("\tTest %d:\tWhen a new user verifies their email.", testID)
+		{
+			ctx := context.Background()
+			now := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+			nu := userStore.NewUser{
+				Name:            "Bill Kennedy",
+				Email:           "bill@ardanlabs.com",
+				Roles:           []string{auth.RoleUser},
+				Password:        "gophers1",
+				PasswordConfirm: "gophers1",
+			}
+
+			claims := auth.Claims{Roles: []string{auth.RoleAdmin}}
+			usr, err := core.Create(ctx, claims, nu, now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create a user : %v", tests.Failed, testID, err)
+			}
+			tkn := mb.token(t)
+			t.Logf("\t%s\tTest %d:\tShould mail a verification token.", tests.Success, testID)
+
+			if err := core.VerifyEmail(ctx, tkn, now); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to verify the email : %v", tests.Failed, testID, err)
+			}
+
+			saved, err := core.QueryByID(ctx, claims, usr.ID)
+			if err != nil || !saved.EmailVerified {
+				t.Fatalf("\t%s\tTest %d:\tShould mark the email as verified : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould mark the email as verified.", tests.Success, testID)
+		}
+	}
+}
+
+func TestTOTP(t *testing.T) {
+	log, db, teardown := tests.NewUnit(t, dbc)
+	t.Cleanup(teardown)
+
+	core := user.NewCore(log, db, auth.DefaultPolicy(), &mailbox{})
+
+	t.Log("Given the need to require a second factor to log in.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen a user enrols in two-factor authentication.", testID)
+		{
+			ctx := context.Background()
+			now := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+			// The seeded "User Gopher" account.
+			const userID = "45b5fbd3-755f-4379-8f07-a58d4a30fa2f"
+			claims := auth.Claims{
+				RegisteredClaims: jwt.RegisteredClaims{Subject: userID},
+				Roles:            []string{auth.RoleUser},
+			}
+
+			secret, _, err := core.EnrollTOTP(ctx, claims, userID, now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to enrol : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to enrol.", tests.Success, testID)
+
+			code, err := totp.Code(secret, totp.Step(now))
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to generate a code : %v", tests.Failed, testID, err)
+			}
+
+			recovery, err := core.ConfirmTOTP(ctx, claims, userID, code, now)
+			if err != nil || len(recovery) == 0 {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to confirm with a code : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to confirm with a code.", tests.Success, testID)
+
+			challenge, err := core.Challenge(ctx, userID, now)
+			if err != nil || challenge == "" {
+				t.Fatalf("\t%s\tTest %d:\tShould get a challenge : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould get a challenge.", tests.Success, testID)
+
+			if _, err := core.CompleteChallenge(ctx, challenge, code, "10.0.0.1", now); !errors.Is(err, database.ErrAuthenticationFailed) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT accept a code twice : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT accept a code twice.", tests.Success, testID)
+
+			now = now.Add(totp.Period)
+			code, _ = totp.Code(secret, totp.Step(now))
+
+			if _, err := core.CompleteChallenge(ctx, challenge, code, "10.0.0.1", now); !errors.Is(err, database.ErrAuthenticationFailed) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT accept a challenge twice : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT accept a challenge twice.", tests.Success, testID)
+
+			challenge, _ = core.Challenge(ctx, userID, now)
+			got, err := core.CompleteChallenge(ctx, challenge, code, "10.0.0.1", now)
+			if err != nil || got.Subject != userID {
+				t.Fatalf("\t%s\tTest %d:\tShould complete the challenge with a new code : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould complete the challenge with a new code.", tests.Success, testID)
+
+			challenge, _ = core.Challenge(ctx, userID, now)
+			if _, err := core.CompleteChallenge(ctx, challenge, strings.ToLower(recovery[0]), "10.0.0.1", now); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould complete the challenge with a recovery code : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould complete the challenge with a recovery code.", tests.Success, testID)
+
+			challenge, _ = core.Challenge(ctx, userID, now)
+			if _, err := core.CompleteChallenge(ctx, challenge, recovery[0], "10.0.0.1", now); !errors.Is(err, database.ErrAuthenticationFailed) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT accept a recovery code twice : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT accept a recovery code twice.", tests.Success, testID)
+
+			// Every correct password gets a new challenge, so guessing codes
+			// has to count against the account like guessing passwords.
+			var locked *user.LockedError
+			for i := 0; i < 10 && locked == nil; i++ {
+				now = now.Add(time.Minute)
+				if _, err := core.Authenticate(ctx, now, "user@example.com", "gophers", "10.0.0.1"); err != nil {
+					if !errors.As(err, &locked) {
+						t.Fatalf("\t%s\tTest %d:\tShould authenticate with the password : %v", tests.Failed, testID, err)
+					}
+					continue
+				}
+
+				challenge, _ = core.Challenge(ctx, userID, now)
+				if _, err := core.CompleteChallenge(ctx, challenge, "000000", "10.0.0.1", now); !errors.As(err, &locked) && !errors.Is(err, database.ErrAuthenticationFailed) {
+					t.Fatalf("\t%s\tTest %d:\tShould NOT accept a wrong code : %v", tests.Failed, testID, err)
+				}
+			}
+
+			if locked == nil {
+				t.Fatalf("\t%s\tTest %d:\tShould lock the account after wrong codes.", tests.Failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould lock the account after wrong codes.", tests.Success, testID)
+
+			if err := core.Unlock(ctx, claims, userID, now); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to unlock the account : %v", tests.Failed, testID, err)
+			}
+
+			var fields validate.FieldErrors
+			if err := core.DisableTOTP(ctx, claims, userID, "", now); !errors.As(err, &fields) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to disable without a code : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to disable without a code.", tests.Success, testID)
+
+			code, _ = totp.Code(secret, totp.Step(now))
+			if err := core.DisableTOTP(ctx, claims, userID, code, now); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to disable with a code : %v", tests.Failed, testID, err)
+			}
+
+			if challenge, err := core.Challenge(ctx, userID, now); err != nil || challenge != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould no longer get a challenge : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to disable with a code.", tests.Success, testID)
+		}
+	}
+}
+
+func TestIdentity(t *testing.T) {
+	log, db, teardown := tests.NewUnit(t, dbc)
+	t.Cleanup(teardown)
+
+	core := user.NewCore(log, db, auth.DefaultPolicy(), &mailbox{})
+
+	t.Log("Given the need to log users in through an identity provider.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen handling identities from a provider.", testID)
+		{
+			ctx := context.Background()
+			now := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+			roles := []string{auth.RoleUser}
+
+			id := oidc.Identity{
+				Issuer:  "https://idp.example.com",
+				Subject: "subject1",
+				Email:   "user@example.com",
+			}
+
+			if _, err := core.AuthenticateIdentity(ctx, id, roles, now); !errors.Is(err, database.ErrAuthenticationFailed) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT link an unverified email : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT link an unverified email.", tests.Success, testID)
+
+			// The seeded "User Gopher" account has the same email.
+			id.EmailVerified = true
+			claims, err := core.AuthenticateIdentity(ctx, id, roles, now)
+			if err != nil || claims.Subject != "45b5fbd3-755f-4379-8f07-a58d4a30fa2f" {
+				t.Fatalf("\t%s\tTest %d:\tShould link the user with the same email : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould link the user with the same email.", tests.Success, testID)
+
+			// Once linked the email at the provider doesn't matter anymore.
+			id.Email = "changed@example.com"
+			id.EmailVerified = false
+			if again, err := core.AuthenticateIdentity(ctx, id, roles, now); err != nil || again.Subject != claims.Subject {
+				t.Fatalf("\t%s\tTest %d:\tShould find the linked user : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould find the linked user.", tests.Success, testID)
+
+			id = oidc.Identity{
+				Issuer:        "https://idp.example.com",
+				Subject:       "subject2",
+				Email:         "new@example.com",
+				EmailVerified: true,
+				Name:          "New Gopher",
+			}
+
+			claims, err = core.AuthenticateIdentity(ctx, id, roles, now)
+			if err != nil || len(claims.Roles) != 1 || claims.Roles[0] != auth.RoleUser {
+				t.Fatalf("\t%s\tTest %d:\tShould create a user with the default roles : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould create a user with the default roles.", tests.Success, testID)
+
+			if err := core.Delete(ctx, claims, claims.Subject, userStore.AnyVersion, now); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to delete the user : %v", tests.Failed, testID, err)
+			}
+
+			if _, err := core.AuthenticateIdentity(ctx, id, roles, now); !errors.Is(err, database.ErrAuthenticationFailed) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT log in a deleted user : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT log in a deleted user.", tests.Success, testID)
+
+			revoked, err := token.NewStore(db, log).IsRevoked(ctx, claims.ID, claims.Subject)
+			if err != nil || !revoked {
+				t.Fatalf("\t%s\tTest %d:\tShould refuse the access tokens of a deleted user : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould refuse the access tokens of a deleted user.", tests.Success, testID)
+		}
+	}
+}
+
+func TestAudit(t *testing.T) {
+	log, db, teardown := tests.NewUnit(t, dbc)
+	t.Cleanup(teardown)
+
+	core := user.NewCore(log, db, auth.DefaultPolicy(), &mailbox{})
+	ac := auditCore.NewCore(log, db)
+
+	t.Log("Given the need to know who changed a user.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen an admin creates, updates and deletes a user.", testID)
+		{
+			ctx := context.Background()
+			now := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+			// The seeded "Admin Gopher" account.
+			const adminID = "5cf37266-3473-4006-984f-9325122678b7"
+			claims := auth.Claims{
+				RegisteredClaims: jwt.RegisteredClaims{Subject: adminID},
+				Roles:            []string{auth.RoleAdmin},
+			}
+
+			nu := userStore.NewUser{
+				Name:            "Bill Kennedy",
+				Email:           "bill@ardanlabs.com",
+				Roles:           []string{auth.RoleUser},
+				Password:        "gophers1",
+				PasswordConfirm: "gophers1",
+			}
+
+			usr, err := core.Create(ctx, claims, nu, now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create a user : %v", tests.Failed, testID, err)
+			}
+
+			name, pw := "Jacob Walker", "gophers2"
+			uu := userStore.UpdateUser{Name: &name, Password: &pw, PasswordConfirm: &pw}
+			if _, err := core.Update(ctx, claims, usr.ID, usr.Version, uu, now.Add(time.Second)); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to update the user : %v", tests.Failed, testID, err)
+			}
+
+			if err := core.Delete(ctx, claims, usr.ID, usr.Version, now.Add(2*time.Second)); !errors.Is(err, database.ErrConflict) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to delete a stale version : %v", tests.Failed, testID, err)
+			}
+
+			if err := core.Delete(ctx, claims, usr.ID, usr.Version+1, now.Add(2*time.Second)); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to delete the user : %v", tests.Failed, testID, err)
+			}
+
+			filter := audit.QueryFilter{TargetID: &usr.ID}
+			events, _, err := ac.Query(ctx, filter, "", 10)
+			if err != nil || len(events) != 3 {
+				t.Fatalf("\t%s\tTest %d:\tShould record every change : %d : %v", tests.Failed, testID, len(events), err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould record every change.", tests.Success, testID)
+
+			actions := []string{audit.ActionUserDelete, audit.ActionUserUpdate, audit.ActionUserCreate}
+			for i, ev := range events {
+				if ev.Action != actions[i] || ev.ActorID != adminID {
+					t.Fatalf("\t%s\tTest %d:\tShould record the action and actor newest first : %+v", tests.Failed, testID, ev)
+				}
+			}
+			t.Logf("\t%s\tTest %d:\tShould record the action and actor newest first.", tests.Success, testID)
+
+			upd := events[1].Diff
+			if upd["name"].Before != nu.Name || upd["name"].After != name {
+				t.Fatalf("\t%s\tTest %d:\tShould record the changed name : %+v", tests.Failed, testID, upd)
+			}
+			if upd["password_hash"].After != audit.Redacted {
+				t.Fatalf("\t%s\tTest %d:\tShould redact the password hash : %+v", tests.Failed, testID, upd)
+			}
+			if _, exists := upd["email"]; exists {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT record unchanged fields : %+v", tests.Failed, testID, upd)
+			}
+			t.Logf("\t%s\tTest %d:\tShould record only the changed fields, redacted.", tests.Success, testID)
+
+			events, next, err := ac.Query(ctx, filter, "", 2)
+			if err != nil || len(events) != 2 || next == "" {
+				t.Fatalf("\t%s\tTest %d:\tShould get the first page : %v", tests.Failed, testID, err)
+			}
+			events, next, err = ac.Query(ctx, filter, next, 2)
+			if err != nil || len(events) != 1 || next != "" || events[0].Action != audit.ActionUserCreate {
+				t.Fatalf("\t%s\tTest %d:\tShould get the last page : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould page through the events.", tests.Success, testID)
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tWhen a user sets up two-factor authentication.", testID)
+		{
+			ctx := context.Background()
+			now := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+			// The seeded "User Gopher" account.
+			const userID = "45b5fbd3-755f-4379-8f07-a58d4a30fa2f"
+			claims := auth.Claims{
+				RegisteredClaims: jwt.RegisteredClaims{Subject: userID},
+				Roles:            []string{auth.RoleUser},
+			}
+
+			secret, _, err := core.EnrollTOTP(ctx, claims, userID, now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to enrol : %v", tests.Failed, testID, err)
+			}
+
+			code, _ := totp.Code(secret, totp.Step(now))
+			if _, err := core.ConfirmTOTP(ctx, claims, userID, code, now.Add(time.Second)); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to confirm with a code : %v", tests.Failed, testID, err)
+			}
+
+			now = now.Add(totp.Period)
+			code, _ = totp.Code(secret, totp.Step(now))
+			if err := core.DisableTOTP(ctx, claims, userID, code, now); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to disable with a code : %v", tests.Failed, testID, err)
+			}
+
+			if err := core.Unlock(ctx, claims, userID, now.Add(time.Second)); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to unlock the account : %v", tests.Failed, testID, err)
+			}
+
+			id := userID
+			filter := audit.QueryFilter{TargetID: &id}
+			events, _, err := ac.Query(ctx, filter, "", 10)
+			if err != nil || len(events) != 4 {
+				t.Fatalf("\t%s\tTest %d:\tShould record every change : %d : %v", tests.Failed, testID, len(events), err)
+			}
+
+			actions := []string{audit.ActionUserUnlock, audit.ActionUserDisableTOTP, audit.ActionUserConfirmTOTP, audit.ActionUserEnrollTOTP}
+			for i, ev := range events {
+				if ev.Action != actions[i] || ev.ActorID != userID {
+					t.Fatalf("\t%s\tTest %d:\tShould record the action and actor newest first : %+v", tests.Failed, testID, ev)
+				}
+			}
+			t.Logf("\t%s\tTest %d:\tShould record every change.", tests.Success, testID)
+
+			if events[3].Diff["secret"].After != audit.Redacted {
+				t.Fatalf("\t%s\tTest %d:\tShould redact the secret : %+v", tests.Failed, testID, events[3].Diff)
+			}
+			t.Logf("\t%s\tTest %d:\tShould redact the secret.", tests.Success, testID)
+		}
+	}
+}
+
+func TestImport(t *testing.T) {
+	log, db, teardown := tests.NewUnit(t, dbc)
+	t.Cleanup(teardown)
+
+	core := user.NewCore(log, db, auth.DefaultPolicy(), &mailbox{})
+
+	// The seeded "Admin Gopher" account.
+	claims := auth.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{Subject: "5cf37266-3473-4006-984f-9325122678b7"},
+		Roles:            []string{auth.RoleAdmin},
+	}
+
+	newRows := func() func() (userStore.NewUser, error) {
+		rows := []userStore.NewUser{
+			{Name: "Bill Kennedy", Email: "bill@ardanlabs.com", Roles: []string{auth.RoleUser}, Password: "gophers1", PasswordConfirm: "gophers1"},
+			{Name: "Bad Email", Email: "not an email", Roles: []string{auth.RoleUser}, Password: "gophers1", PasswordConfirm: "gophers1"},
+			{},
+			{Name: "Bill Again", Email: "bill@ardanlabs.com", Roles: []string{auth.RoleUser}, Password: "gophers1", PasswordConfirm: "gophers1"},
+		}
+
+		i := 0
+		return func() (userStore.NewUser, error) {
+			if i == len(rows) {
+				return userStore.NewUser{}, io.EOF
+			}
+			i++
+
+			// The third row stands for a line that couldn't be parsed.
+			if i == 3 {
+				return userStore.NewUser{}, validate.FieldErrors{{Field: "row", Error: "malformed"}}
+			}
+			return rows[i-1], nil
+		}
+	}
+
+	t.Log("Given the need to import users in bulk.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen importing a mix of good and bad rows.", testID)
+		{
+			ctx := context.Background()
+			now := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+			report, err := core.Import(ctx, claims, newRows(), true, now)
+			if err != nil || report.Created != 1 || report.Failed != 3 {
+				t.Fatalf("\t%s\tTest %d:\tShould report every row on a dry run : %+v : %v", tests.Failed, testID, report, err)
+			}
+
+			if _, err := core.QueryByEmail(ctx, claims, "bill@ardanlabs.com"); !errors.Is(err, database.ErrNotFound) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT save anything on a dry run : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould report every row without saving on a dry run.", tests.Success, testID)
+
+			report, err = core.Import(ctx, claims, newRows(), false, now)
+			if err != nil || report.Created != 1 || report.Failed != 3 || len(report.Rows) != 4 {
+				t.Fatalf("\t%s\tTest %d:\tShould report every row : %+v : %v", tests.Failed, testID, report, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould report every row.", tests.Success, testID)
+
+			for i, res := range report.Rows {
+				if res.Row != i+1 || (i == 0) != (res.Errors == nil) {
+					t.Fatalf("\t%s\tTest %d:\tShould report the rows in order : %+v", tests.Failed, testID, report.Rows)
+				}
+			}
+			if report.Rows[3].Errors[0].Field != "email" {
+				t.Fatalf("\t%s\tTest %d:\tShould reject a duplicate email : %+v", tests.Failed, testID, report.Rows[3])
+			}
+			t.Logf("\t%s\tTest %d:\tShould report the rows in order.", tests.Success, testID)
+
+			usr, err := core.QueryByEmail(ctx, claims, "bill@ardanlabs.com")
+			if err != nil || usr.ID != report.Rows[0].ID {
+				t.Fatalf("\t%s\tTest %d:\tShould save the good rows : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould save the good rows.", tests.Success, testID)
+		}
+	}
+}
+
+// mailbox keeps the messages sent so the tokens in them can be used.
+type mailbox struct {
+	msgs []mail.Message
+}
+
+func (m *mailbox) Send(ctx context.Context, msg mail.Message) error {
+	m.msgs = append(m.msgs, msg)
+	return nil
+}
+
+// token returns the token from the last message sent.
+func (m *mailbox) token(t *testing.T) string {
+	if len(m.msgs) == 0 {
+		t.Fatalf("\t%s\tShould have sent a message.", tests.Failed)
+	}
+
+	for _, line := range strings.Split(m.msgs[len(m.msgs)-1].Body, "\n") {
+		if len(line) == 43 && !strings.Contains(line, " ") {
+			return line
+		}
+	}
+
+	t.Fatalf("\t%s\tShould find a token in the message.", tests.Failed)
+	return ""
+}
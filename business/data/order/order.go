@@ -0,0 +1,70 @@
+// Package order provides support for describing the ordering of data.
+package order
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mihailtudos/service3/business/sys/validate"
+)
+
+// Set of directions for data ordering.
+const (
+	ASC  = "ASC"
+	DESC = "DESC"
+)
+
+var directions = map[string]string{
+	ASC:  "ASC",
+	DESC: "DESC",
+}
+
+// ErrInvalidOrder occurs when an order by value can't be parsed or references
+// a field that is not supported.
+var ErrInvalidOrder = validate.NewError(validate.KindInvalid, validate.CodeInvalidOrder, "order by is not in its proper form")
+
+// By represents a field used to order by and direction.
+type By struct {
+	Field     string
+	Direction string
+}
+
+// NewBy constructs a new By value with no checks.
+func NewBy(field string, direction string) By {
+	return By{
+		Field:     field,
+		Direction: direction,
+	}
+}
+
+// Parse constructs a By value by parsing a string in the form of
+// "field,direction" ie "name,desc". Only fields found in the provided set of
+// allowed fields are accepted. When the string is empty the default value is
+// returned.
+func Parse(orderBy string, allowed map[string]string, defaultOrder By) (By, error) {
+	if orderBy == "" {
+		return defaultOrder, nil
+	}
+
+	orderParts := strings.Split(orderBy, ",")
+
+	field := strings.TrimSpace(orderParts[0])
+	if _, exists := allowed[field]; !exists {
+		return By{}, fmt.Errorf("%w: unknown field %q", ErrInvalidOrder, field)
+	}
+
+	switch len(orderParts) {
+	case 1:
+		return NewBy(field, ASC), nil
+
+	case 2:
+		dir, exists := directions[strings.ToUpper(strings.TrimSpace(orderParts[1]))]
+		if !exists {
+			return By{}, fmt.Errorf("%w: unknown direction %q", ErrInvalidOrder, orderParts[1])
+		}
+		return NewBy(field, dir), nil
+
+	default:
+		return By{}, fmt.Errorf("%w: %q", ErrInvalidOrder, orderBy)
+	}
+}
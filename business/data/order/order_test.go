@@ -0,0 +1,51 @@
+package order_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mihailtudos/service3/business/data/order"
+)
+
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+func TestParse(t *testing.T) {
+	allowed := map[string]string{"name": "name", "date_created": "date_created"}
+	def := order.NewBy("date_created", order.ASC)
+
+	tt := []struct {
+		name    string
+		orderBy string
+		exp     order.By
+		err     error
+	}{
+		{"empty", "", def, nil},
+		{"field", "name", order.NewBy("name", order.ASC), nil},
+		{"direction", "name,desc", order.NewBy("name", order.DESC), nil},
+		{"unknown field", "password_hash", order.By{}, order.ErrInvalidOrder},
+		{"unknown direction", "name,sideways", order.By{}, order.ErrInvalidOrder},
+		{"too many parts", "name,asc,desc", order.By{}, order.ErrInvalidOrder},
+	}
+
+	t.Log("Given the need to parse order by values.")
+	{
+		for testID, tst := range tt {
+			t.Logf("\tTest %d:\tWhen parsing %q.", testID, tst.orderBy)
+			{
+				got, err := order.Parse(tst.orderBy, allowed, def)
+				if !errors.Is(err, tst.err) {
+					t.Fatalf("\t%s\tTest %d:\tShould get the expected error : %v", failed, testID, err)
+				}
+				if got != tst.exp {
+					t.Logf("\t\tTest %d:\texp: %v", testID, tst.exp)
+					t.Logf("\t\tTest %d:\tgot: %v", testID, got)
+					t.Fatalf("\t%s\tTest %d:\tShould get the expected order.", failed, testID)
+				}
+				t.Logf("\t%s\tTest %d:\tShould get the expected order for %s.", success, testID, tst.name)
+			}
+		}
+	}
+}
@@ -1,3 +1,12 @@
+DELETE FROM audit_events;
+DELETE FROM user_identities;
+DELETE FROM recovery_codes;
+DELETE FROM user_totp;
+DELETE FROM action_tokens;
+DELETE FROM login_attempts;
+DELETE FROM api_keys;
+DELETE FROM revoked_tokens;
+DELETE FROM refresh_tokens;
 DELETE FROM sales;
 DELETE FROM products;
 DELETE FROM users;
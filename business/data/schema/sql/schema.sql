@@ -41,3 +41,147 @@ CREATE TABLE IF NOT EXISTS sales (
        FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE,
        FOREIGN KEY (product_id) REFERENCES products(product_id) ON DELETE CASCADE
 );
+
+-- Version: 1.4
+-- Description: Create tables refresh_tokens and revoked_tokens
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+       token_id UUID,
+       family_id UUID,
+       user_id UUID,
+       token_hash TEXT UNIQUE,
+       replaced_by UUID NULL,
+       date_created TIMESTAMP,
+       date_expires TIMESTAMP,
+       date_revoked TIMESTAMP NULL,
+
+       PRIMARY KEY (token_id),
+       FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS refresh_tokens_family_id_idx ON refresh_tokens (family_id);
+
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+       jti TEXT,
+       date_expires TIMESTAMP,
+       date_created TIMESTAMP,
+
+       PRIMARY KEY (jti)
+);
+
+-- Version: 1.5
+-- Description: Create table api_keys
+CREATE TABLE IF NOT EXISTS api_keys (
+       key_id UUID,
+       user_id UUID,
+       name TEXT,
+       key_hash TEXT UNIQUE,
+       scopes TEXT[],
+       date_created TIMESTAMP,
+       date_expires TIMESTAMP NULL,
+       date_last_used TIMESTAMP NULL,
+       date_revoked TIMESTAMP NULL,
+
+       PRIMARY KEY (key_id),
+       FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS api_keys_user_id_idx ON api_keys (user_id);
+
+-- Version: 1.6
+-- Description: Create table login_attempts
+CREATE TABLE IF NOT EXISTS login_attempts (
+       attempt_key TEXT,
+       failures INT,
+       date_last_failure TIMESTAMP,
+       date_blocked_until TIMESTAMP NULL,
+
+       PRIMARY KEY (attempt_key)
+);
+
+-- Version: 1.7
+-- Description: Add email verification and create table action_tokens
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE IF NOT EXISTS action_tokens (
+       token_id UUID,
+       user_id UUID,
+       purpose TEXT,
+       email TEXT,
+       token_hash TEXT UNIQUE,
+       date_created TIMESTAMP,
+       date_expires TIMESTAMP,
+       date_used TIMESTAMP NULL,
+
+       PRIMARY KEY (token_id),
+       FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+-- Version: 1.8
+-- Description: Create tables user_totp and recovery_codes
+CREATE TABLE IF NOT EXISTS user_totp (
+       user_id UUID,
+       secret TEXT,
+       last_step BIGINT NOT NULL DEFAULT 0,
+       date_created TIMESTAMP,
+       date_enabled TIMESTAMP NULL,
+
+       PRIMARY KEY (user_id),
+       FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS recovery_codes (
+       code_id UUID,
+       user_id UUID,
+       code_hash TEXT,
+       date_created TIMESTAMP,
+       date_used TIMESTAMP NULL,
+
+       PRIMARY KEY (code_id),
+       FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS recovery_codes_user_id_idx ON recovery_codes (user_id);
+
+-- Version: 1.9
+-- Description: Create table user_identities
+CREATE TABLE IF NOT EXISTS user_identities (
+       issuer TEXT,
+       subject TEXT,
+       user_id UUID,
+       date_created TIMESTAMP,
+
+       PRIMARY KEY (issuer, subject),
+       FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+-- Version: 1.10
+-- Description: Create table audit_events
+CREATE TABLE IF NOT EXISTS audit_events (
+       event_id UUID,
+       actor_id TEXT,
+       action TEXT,
+       target_type TEXT,
+       target_id TEXT,
+       diff JSONB,
+       trace_id TEXT,
+       date_created TIMESTAMP,
+
+       PRIMARY KEY (event_id)
+);
+
+CREATE INDEX IF NOT EXISTS audit_events_date_created_idx ON audit_events (date_created DESC, event_id DESC);
+CREATE INDEX IF NOT EXISTS audit_events_target_idx ON audit_events (target_type, target_id);
+CREATE INDEX IF NOT EXISTS audit_events_actor_id_idx ON audit_events (actor_id);
+
+-- Version: 1.11
+-- Description: Add soft deletes to users
+ALTER TABLE users ADD COLUMN IF NOT EXISTS date_deleted TIMESTAMP NULL;
+
+-- Version: 1.12
+-- Description: Add a version to users for optimistic concurrency
+ALTER TABLE users ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1;
+
+-- Version: 1.13
+-- Description: Only keep emails unique among users that aren't deleted
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_email_key;
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_idx ON users (email) WHERE date_deleted IS NULL;
@@ -0,0 +1,236 @@
+// Package apikey contains the storage for API keys used by machine to
+// machine clients.
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mihailtudos/service3/business/sys/database"
+	"github.com/mihailtudos/service3/business/sys/validate"
+	"go.uber.org/zap"
+)
+
+// Prefix starts every API key so they are easy to recognise, for example by
+// secret scanners.
+const Prefix = "sk_"
+
+// Store manages the set of APIs for API key access.
+type Store struct {
+	db  sqlx.ExtContext
+	log *zap.SugaredLogger
+}
+
+// NewStore constructs an API key store for api access.
+func NewStore(db *sqlx.DB, log *zap.SugaredLogger) Store {
+	return Store{
+		db:  db,
+		log: log,
+	}
+}
+
+// Tran returns a new Store bound to the specified transaction.
+func (s Store) Tran(tx sqlx.ExtContext) Store {
+	return Store{
+		db:  tx,
+		log: s.log,
+	}
+}
+
+// Create generates a new API key and stores its hash. The key itself is
+// only returned here and can't be recovered later.
+func (s Store) Create(ctx context.Context, nak NewAPIKey, now time.Time) (string, APIKey, error) {
+	if err := validate.Check(nak); err != nil {
+		return "", APIKey{}, fmt.Errorf("validating data: %w", err)
+	}
+
+	if err := validate.CheckID(nak.UserID); err != nil {
+		return "", APIKey{}, database.ErrInvalidID
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", APIKey{}, fmt.Errorf("generating api key: %w", err)
+	}
+	key := Prefix + base64.RawURLEncoding.EncodeToString(b)
+
+	ak := APIKey{
+		ID:          validate.GenerateID(),
+		UserID:      nak.UserID,
+		Name:        nak.Name,
+		KeyHash:     hash(key),
+		Scopes:      nak.Scopes,
+		DateCreated: now,
+		DateExpires: nak.DateExpires,
+	}
+
+	const q = `
+	INSERT INTO api_keys
+		(key_id, user_id, name, key_hash, scopes, date_created, date_expires)
+	VALUES
+		(:key_id, :user_id, :name, :key_hash, :scopes, :date_created, :date_expires)`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, ak); err != nil {
+		return "", APIKey{}, fmt.Errorf("inserting api key: %w", err)
+	}
+
+	return key, ak, nil
+}
+
+// QueryByID gets the specified API key from the database.
+func (s Store) QueryByID(ctx context.Context, keyID string) (APIKey, error) {
+	if err := validate.CheckID(keyID); err != nil {
+		return APIKey{}, database.ErrInvalidID
+	}
+
+	data := struct {
+		KeyID string `db:"key_id"`
+	}{
+		KeyID: keyID,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		api_keys
+	WHERE
+		key_id = :key_id`
+
+	var ak APIKey
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &ak); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return APIKey{}, database.ErrNotFound
+		}
+		return APIKey{}, fmt.Errorf("selecting api key keyID[%s]: %w", keyID, err)
+	}
+
+	return ak, nil
+}
+
+// QueryByKey finds the API key matching the key string.
+func (s Store) QueryByKey(ctx context.Context, key string) (APIKey, error) {
+	data := struct {
+		KeyHash string `db:"key_hash" log:"redact"`
+	}{
+		KeyHash: hash(key),
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		api_keys
+	WHERE
+		key_hash = :key_hash`
+
+	var ak APIKey
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &ak); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return APIKey{}, database.ErrNotFound
+		}
+		return APIKey{}, fmt.Errorf("selecting api key: %w", err)
+	}
+
+	return ak, nil
+}
+
+// QueryByUserID gets the API keys owned by the specified user, including the
+// revoked and expired ones.
+func (s Store) QueryByUserID(ctx context.Context, userID string) ([]APIKey, error) {
+	if err := validate.CheckID(userID); err != nil {
+		return nil, database.ErrInvalidID
+	}
+
+	data := struct {
+		UserID string `db:"user_id"`
+	}{
+		UserID: userID,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		api_keys
+	WHERE
+		user_id = :user_id
+	ORDER BY
+		date_created DESC`
+
+	var keys []APIKey
+	if err := database.NamedQuerySlice(ctx, s.log, s.db, q, data, &keys); err != nil {
+		return nil, fmt.Errorf("selecting api keys userID[%s]: %w", userID, err)
+	}
+
+	return keys, nil
+}
+
+// Revoke marks the API key as revoked so it can't be used anymore.
+func (s Store) Revoke(ctx context.Context, keyID string, now time.Time) error {
+	if err := validate.CheckID(keyID); err != nil {
+		return database.ErrInvalidID
+	}
+
+	data := struct {
+		KeyID       string    `db:"key_id"`
+		DateRevoked time.Time `db:"date_revoked"`
+	}{
+		KeyID:       keyID,
+		DateRevoked: now,
+	}
+
+	const q = `
+	UPDATE
+		api_keys
+	SET
+		"date_revoked" = :date_revoked
+	WHERE
+		key_id = :key_id AND
+		date_revoked IS NULL`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, data); err != nil {
+		return fmt.Errorf("revoking api key keyID[%s]: %w", keyID, err)
+	}
+
+	return nil
+}
+
+// UpdateLastUsed records when the API key was last used to authenticate.
+func (s Store) UpdateLastUsed(ctx context.Context, keyID string, now time.Time) error {
+	data := struct {
+		KeyID        string    `db:"key_id"`
+		DateLastUsed time.Time `db:"date_last_used"`
+	}{
+		KeyID:        keyID,
+		DateLastUsed: now,
+	}
+
+	const q = `
+	UPDATE
+		api_keys
+	SET
+		"date_last_used" = :date_last_used
+	WHERE
+		key_id = :key_id`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, data); err != nil {
+		return fmt.Errorf("updating api key last used keyID[%s]: %w", keyID, err)
+	}
+
+	return nil
+}
+
+// hash returns the hex encoded SHA-256 of the key. API keys carry 256 bits
+// of randomness so a fast hash is enough to make a leaked table useless.
+func hash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,31 @@
+package apikey
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// APIKey represents a long lived key a machine client authenticates with on
+// behalf of a user. Only a hash of the key is stored.
+type APIKey struct {
+	ID           string         `db:"key_id" json:"id"`
+	UserID       string         `db:"user_id" json:"user_id"`
+	Name         string         `db:"name" json:"name"`
+	KeyHash      string         `db:"key_hash" log:"redact" json:"-"`
+	Scopes       pq.StringArray `db:"scopes" json:"scopes"`
+	DateCreated  time.Time      `db:"date_created" json:"date_created"`
+	DateExpires  *time.Time     `db:"date_expires" json:"date_expires,omitempty"`
+	DateLastUsed *time.Time     `db:"date_last_used" json:"date_last_used,omitempty"`
+	DateRevoked  *time.Time     `db:"date_revoked" json:"date_revoked,omitempty"`
+}
+
+// NewAPIKey contains information needed to create a new APIKey. The scopes
+// are the permissions the key is limited to. A key without an expiry is
+// valid until it is revoked.
+type NewAPIKey struct {
+	UserID      string     `json:"user_id" validate:"required"`
+	Name        string     `json:"name" validate:"required"`
+	Scopes      []string   `json:"scopes" validate:"required,min=1"`
+	DateExpires *time.Time `json:"date_expires"`
+}
@@ -0,0 +1,210 @@
+// Package attempt contains the storage for failed login attempts, used to
+// throttle and lock out password guessing.
+package attempt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mihailtudos/service3/business/sys/database"
+	"go.uber.org/zap"
+)
+
+// AccountKey returns the key attempts against the account are tracked by.
+// The email doesn't have to belong to an existing user, so unknown accounts
+// are throttled exactly like known ones.
+func AccountKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// SourceKey returns the key attempts from the source address are tracked by.
+func SourceKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Store manages the set of APIs for login attempt access.
+type Store struct {
+	db  sqlx.ExtContext
+	log *zap.SugaredLogger
+}
+
+// NewStore constructs a login attempt store for api access.
+func NewStore(db *sqlx.DB, log *zap.SugaredLogger) Store {
+	return Store{
+		db:  db,
+		log: log,
+	}
+}
+
+// Tran returns a new Store bound to the specified transaction.
+func (s Store) Tran(tx sqlx.ExtContext) Store {
+	return Store{
+		db:  tx,
+		log: s.log,
+	}
+}
+
+// QueryByKey gets the attempts recorded for the specified key.
+func (s Store) QueryByKey(ctx context.Context, key string) (Attempt, error) {
+	data := struct {
+		Key string `db:"attempt_key" log:"redact"`
+	}{
+		Key: key,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		login_attempts
+	WHERE
+		attempt_key = :attempt_key`
+
+	var a Attempt
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &a); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return Attempt{}, database.ErrNotFound
+		}
+		return Attempt{}, fmt.Errorf("selecting login attempts: %w", err)
+	}
+
+	return a, nil
+}
+
+// Lock gets the attempts recorded for the key, creating an empty record when
+// there is none, and locks the record. The store must be bound to a
+// transaction so attempts against the key are checked and counted one at a
+// time.
+func (s Store) Lock(ctx context.Context, key string, now time.Time) (Attempt, error) {
+	data := struct {
+		Key string    `db:"attempt_key" log:"redact"`
+		Now time.Time `db:"now"`
+	}{
+		Key: key,
+		Now: now,
+	}
+
+	const q = `
+	INSERT INTO login_attempts AS la
+		(attempt_key, failures, date_last_failure)
+	VALUES
+		(:attempt_key, 0, :now)
+	ON CONFLICT (attempt_key) DO UPDATE SET
+		"attempt_key" = la.attempt_key
+	RETURNING
+		*`
+
+	var a Attempt
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &a); err != nil {
+		return Attempt{}, fmt.Errorf("locking login attempts: %w", err)
+	}
+
+	return a, nil
+}
+
+// Forgive takes back one failed attempt for the key, for an attempt that was
+// counted before it was known to succeed.
+func (s Store) Forgive(ctx context.Context, key string) error {
+	data := struct {
+		Key string `db:"attempt_key" log:"redact"`
+	}{
+		Key: key,
+	}
+
+	const q = `
+	UPDATE
+		login_attempts
+	SET
+		"failures" = GREATEST("failures" - 1, 0)
+	WHERE
+		attempt_key = :attempt_key`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, data); err != nil {
+		return fmt.Errorf("forgiving login attempt: %w", err)
+	}
+
+	return nil
+}
+
+// RecordFailure adds a failed attempt for the key and returns the updated
+// record. Failures older than the window are forgotten so the count starts
+// over.
+func (s Store) RecordFailure(ctx context.Context, key string, now time.Time, window time.Duration) (Attempt, error) {
+	data := struct {
+		Key         string    `db:"attempt_key" log:"redact"`
+		Now         time.Time `db:"now"`
+		WindowStart time.Time `db:"window_start"`
+	}{
+		Key:         key,
+		Now:         now,
+		WindowStart: now.Add(-window),
+	}
+
+	const q = `
+	INSERT INTO login_attempts AS la
+		(attempt_key, failures, date_last_failure)
+	VALUES
+		(:attempt_key, 1, :now)
+	ON CONFLICT (attempt_key) DO UPDATE SET
+		"failures" = CASE WHEN la.date_last_failure < :window_start THEN 1 ELSE la.failures + 1 END,
+		"date_last_failure" = :now
+	RETURNING
+		*`
+
+	var a Attempt
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &a); err != nil {
+		return Attempt{}, fmt.Errorf("recording login failure: %w", err)
+	}
+
+	return a, nil
+}
+
+// Block prevents any attempt for the key until the specified time.
+func (s Store) Block(ctx context.Context, key string, until time.Time) error {
+	data := struct {
+		Key              string    `db:"attempt_key" log:"redact"`
+		DateBlockedUntil time.Time `db:"date_blocked_until"`
+	}{
+		Key:              key,
+		DateBlockedUntil: until,
+	}
+
+	const q = `
+	UPDATE
+		login_attempts
+	SET
+		"date_blocked_until" = :date_blocked_until
+	WHERE
+		attempt_key = :attempt_key`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, data); err != nil {
+		return fmt.Errorf("blocking login: %w", err)
+	}
+
+	return nil
+}
+
+// Reset forgets the failed attempts recorded for the key, lifting any block.
+func (s Store) Reset(ctx context.Context, key string) error {
+	data := struct {
+		Key string `db:"attempt_key" log:"redact"`
+	}{
+		Key: key,
+	}
+
+	const q = `
+	DELETE FROM
+		login_attempts
+	WHERE
+		attempt_key = :attempt_key`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, data); err != nil {
+		return fmt.Errorf("resetting login: %w", err)
+	}
+
+	return nil
+}
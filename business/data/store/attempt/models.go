@@ -0,0 +1,14 @@
+package attempt
+
+import (
+	"time"
+)
+
+// Attempt represents the failed login attempts made against an account or
+// from a source address, identified by its key.
+type Attempt struct {
+	Key              string     `db:"attempt_key" log:"redact"`
+	Failures         int        `db:"failures"`
+	DateLastFailure  time.Time  `db:"date_last_failure"`
+	DateBlockedUntil *time.Time `db:"date_blocked_until"`
+}
@@ -0,0 +1,201 @@
+// Package audit contains the storage for the log of changes made to records
+// and who made them.
+package audit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mihailtudos/service3/business/sys/database"
+	"github.com/mihailtudos/service3/business/sys/validate"
+	"go.uber.org/zap"
+)
+
+// Store manages the set of APIs for audit access.
+type Store struct {
+	db  sqlx.ExtContext
+	log *zap.SugaredLogger
+}
+
+// NewStore constructs an audit store for api access.
+func NewStore(db *sqlx.DB, log *zap.SugaredLogger) Store {
+	return Store{
+		db:  db,
+		log: log,
+	}
+}
+
+// Tran returns a new Store bound to the specified transaction. Events should
+// be recorded in the same transaction as the change they describe.
+func (s Store) Tran(tx sqlx.ExtContext) Store {
+	return Store{
+		db:  tx,
+		log: s.log,
+	}
+}
+
+// Create records a new event.
+func (s Store) Create(ctx context.Context, ne NewEvent, now time.Time) (Event, error) {
+	if err := validate.Check(ne); err != nil {
+		return Event{}, fmt.Errorf("validating data: %w", err)
+	}
+
+	ev := Event{
+		ID:          validate.GenerateID(),
+		ActorID:     ne.ActorID,
+		Action:      ne.Action,
+		TargetType:  ne.TargetType,
+		TargetID:    ne.TargetID,
+		Diff:        ne.Diff,
+		TraceID:     ne.TraceID,
+		DateCreated: now,
+	}
+
+	const q = `
+	INSERT INTO audit_events
+		(event_id, actor_id, action, target_type, target_id, diff, trace_id, date_created)
+	VALUES
+		(:event_id, :actor_id, :action, :target_type, :target_id, :diff, :trace_id, :date_created)`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, ev); err != nil {
+		return Event{}, fmt.Errorf("inserting event: %w", err)
+	}
+
+	return ev, nil
+}
+
+// Query retrieves a page of events matching the filter, newest first. The
+// returned cursor is empty when there are no more pages.
+func (s Store) Query(ctx context.Context, filter QueryFilter, cursorStr string, rowsPerPage int) ([]Event, string, error) {
+	if err := validate.Check(filter); err != nil {
+		return nil, "", fmt.Errorf("validating filter: %w", err)
+	}
+
+	data := map[string]any{
+		"rows_per_page": rowsPerPage + 1,
+	}
+	wc := applyFilter(filter, data)
+
+	if cursorStr != "" {
+		cur, err := decodeCursor(cursorStr)
+		if err != nil {
+			return nil, "", err
+		}
+		data["cursor_date_created"] = cur.DateCreated
+		data["cursor_event_id"] = cur.EventID
+
+		wc = append(wc, "(date_created, event_id) < (:cursor_date_created, :cursor_event_id)")
+	}
+
+	var buf strings.Builder
+	buf.WriteString(`
+	SELECT
+		*
+	FROM
+		audit_events`)
+	writeWhere(&buf, wc)
+	buf.WriteString(`
+	ORDER BY
+		date_created DESC, event_id DESC
+	FETCH FIRST :rows_per_page ROWS ONLY`)
+
+	var events []Event
+	if err := database.NamedQuerySlice(ctx, s.log, s.db, buf.String(), data, &events); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return nil, "", database.ErrNotFound
+		}
+		return nil, "", fmt.Errorf("selecting events: %w", err)
+	}
+
+	// We asked for one more row than the page size to find out if there is a
+	// next page without another round trip.
+	var next string
+	if len(events) > rowsPerPage {
+		events = events[:rowsPerPage]
+		last := events[len(events)-1]
+		next = cursor{DateCreated: last.DateCreated, EventID: last.ID}.encode()
+	}
+
+	return events, next, nil
+}
+
+// Count returns the total number of events matching the filter.
+func (s Store) Count(ctx context.Context, filter QueryFilter) (int, error) {
+	if err := validate.Check(filter); err != nil {
+		return 0, fmt.Errorf("validating filter: %w", err)
+	}
+
+	data := map[string]any{}
+	wc := applyFilter(filter, data)
+
+	var buf strings.Builder
+	buf.WriteString(`
+	SELECT
+		count(1)
+	FROM
+		audit_events`)
+	writeWhere(&buf, wc)
+
+	var count struct {
+		Count int `db:"count"`
+	}
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, buf.String(), data, &count); err != nil {
+		return 0, fmt.Errorf("counting events: %w", err)
+	}
+
+	return count.Count, nil
+}
+
+// applyFilter adds the named parameters for the filter into data and returns
+// the matching where clauses.
+func applyFilter(filter QueryFilter, data map[string]any) []string {
+	var wc []string
+
+	if filter.ActorID != nil {
+		data["actor_id"] = *filter.ActorID
+		wc = append(wc, "actor_id = :actor_id")
+	}
+
+	if filter.Action != nil {
+		data["action"] = *filter.Action
+		wc = append(wc, "action = :action")
+	}
+
+	if filter.TargetType != nil {
+		data["target_type"] = *filter.TargetType
+		wc = append(wc, "target_type = :target_type")
+	}
+
+	if filter.TargetID != nil {
+		data["target_id"] = *filter.TargetID
+		wc = append(wc, "target_id = :target_id")
+	}
+
+	if filter.From != nil {
+		data["from"] = *filter.From
+		wc = append(wc, "date_created >= :from")
+	}
+
+	if filter.To != nil {
+		data["to"] = *filter.To
+		wc = append(wc, "date_created < :to")
+	}
+
+	return wc
+}
+
+// writeWhere writes the where clauses joined with AND into the query.
+func writeWhere(buf *strings.Builder, wc []string) {
+	if len(wc) == 0 {
+		return
+	}
+
+	buf.WriteString(`
+	WHERE
+		`)
+	buf.WriteString(strings.Join(wc, " AND\n\t\t"))
+}
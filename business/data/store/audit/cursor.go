@@ -0,0 +1,45 @@
+package audit
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"time"
+
+	"github.com/mihailtudos/service3/business/sys/validate"
+)
+
+// ErrInvalidCursor occurs when a cursor can't be decoded.
+var ErrInvalidCursor = validate.NewError(validate.KindInvalid, validate.CodeInvalidCursor, "cursor is not in its proper form")
+
+// cursor marks the position of the last event on a page. Events are always
+// listed newest first, so the cursor carries the creation date plus the event
+// id as a tie-breaker.
+type cursor struct {
+	DateCreated time.Time `json:"t"`
+	EventID     string    `json:"id"`
+}
+
+// encode returns the opaque string form of the cursor handed to clients.
+func (c cursor) encode() string {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return ""
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor parses a cursor previously returned to a client.
+func decodeCursor(s string) (cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor{}, ErrInvalidCursor
+	}
+
+	var c cursor
+	if err := json.Unmarshal(data, &c); err != nil || c.EventID == "" {
+		return cursor{}, ErrInvalidCursor
+	}
+
+	return c, nil
+}
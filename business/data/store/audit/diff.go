@@ -0,0 +1,125 @@
+package audit
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+)
+
+// Redacted replaces the value of sensitive fields in a diff.
+const Redacted = "[REDACTED]"
+
+// redacted is the set of columns whose values never make it into a diff. A
+// change to one is still recorded, with both values redacted.
+var redacted = map[string]bool{
+	"password_hash": true,
+	"secret":        true,
+	"attempt_key":   true,
+}
+
+// Change holds the value of a field before and after a change. Before is nil
+// when the record was created and After is nil when it was deleted.
+type Change struct {
+	Before any `json:"before"`
+	After  any `json:"after"`
+}
+
+// Diff holds the changed fields of a record keyed by column name.
+type Diff map[string]Change
+
+// NewDiff compares two versions of a record and returns the fields that
+// differ. The record is a struct, or a pointer to one, and fields are named
+// by their db tag. Either version can be nil to record a creation or a
+// deletion.
+func NewDiff(before any, after any) Diff {
+	b := columns(before)
+	a := columns(after)
+
+	d := make(Diff)
+	for name, bv := range b {
+		av, ok := a[name]
+		if ok && reflect.DeepEqual(bv, av) {
+			continue
+		}
+		d[name] = Change{Before: bv, After: av}
+	}
+	for name, av := range a {
+		if _, ok := b[name]; !ok {
+			d[name] = Change{After: av}
+		}
+	}
+
+	for name, c := range d {
+		if !redacted[name] {
+			continue
+		}
+		if c.Before != nil {
+			c.Before = Redacted
+		}
+		if c.After != nil {
+			c.After = Redacted
+		}
+		d[name] = c
+	}
+
+	return d
+}
+
+// columns returns the values of the db tagged fields of the struct.
+func columns(v any) map[string]any {
+	if v == nil {
+		return nil
+	}
+
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return nil
+	}
+
+	cols := make(map[string]any)
+	rt := rv.Type()
+	for i := range rt.NumField() {
+		name, _, _ := strings.Cut(rt.Field(i).Tag.Get("db"), ",")
+		if name == "" || name == "-" || !rt.Field(i).IsExported() {
+			continue
+		}
+		cols[name] = rv.Field(i).Interface()
+	}
+
+	return cols
+}
+
+// Value implements the driver.Valuer interface so a Diff is stored as JSON.
+// The JSON is passed as a string since byte slices are sent as bytea.
+func (d Diff) Value() (driver.Value, error) {
+	if d == nil {
+		return "{}", nil
+	}
+
+	data, err := json.Marshal(d)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(data), nil
+}
+
+// Scan implements the sql.Scanner interface to read a Diff stored as JSON.
+func (d *Diff) Scan(src any) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		*d = nil
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return errors.New("unsupported type for diff")
+	}
+
+	return json.Unmarshal(data, d)
+}
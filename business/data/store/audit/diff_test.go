@@ -0,0 +1,59 @@
+package audit_test
+
+import (
+	"testing"
+
+	"github.com/mihailtudos/service3/business/data/store/audit"
+)
+
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+type record struct {
+	ID           string `db:"id"`
+	Name         string `db:"name"`
+	PasswordHash []byte `db:"password_hash"`
+	note         string
+}
+
+func TestDiff(t *testing.T) {
+	before := record{ID: "1", Name: "Bill", PasswordHash: []byte("old"), note: "a"}
+	after := record{ID: "1", Name: "William", PasswordHash: []byte("new"), note: "b"}
+
+	t.Log("Given the need to record what changed in a record.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen a record is updated.", testID)
+		{
+			d := audit.NewDiff(before, &after)
+
+			if len(d) != 2 {
+				t.Fatalf("\t%s\tTest %d:\tShould only hold the changed fields : %v", failed, testID, d)
+			}
+			t.Logf("\t%s\tTest %d:\tShould only hold the changed fields.", success, testID)
+
+			if d["name"].Before != "Bill" || d["name"].After != "William" {
+				t.Fatalf("\t%s\tTest %d:\tShould hold both values : %v", failed, testID, d["name"])
+			}
+			t.Logf("\t%s\tTest %d:\tShould hold both values.", success, testID)
+
+			if d["password_hash"].Before != audit.Redacted || d["password_hash"].After != audit.Redacted {
+				t.Fatalf("\t%s\tTest %d:\tShould redact the password hash : %v", failed, testID, d["password_hash"])
+			}
+			t.Logf("\t%s\tTest %d:\tShould redact the password hash.", success, testID)
+		}
+
+		testID = 1
+		t.Logf("\tTest %d:\tWhen a record is deleted.", testID)
+		{
+			d := audit.NewDiff(before, nil)
+
+			if len(d) != 3 || d["id"].Before != "1" || d["id"].After != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould hold every field with no value after : %v", failed, testID, d)
+			}
+			t.Logf("\t%s\tTest %d:\tShould hold every field with no value after.", success, testID)
+		}
+	}
+}
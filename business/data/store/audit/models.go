@@ -0,0 +1,57 @@
+package audit
+
+import (
+	"time"
+)
+
+// Set of kinds of records the audit log tracks changes to.
+const (
+	TargetUser = "user"
+)
+
+// Set of actions recorded in the audit log.
+const (
+	ActionUserCreate        = "user.create"
+	ActionUserUpdate        = "user.update"
+	ActionUserDelete        = "user.delete"
+	ActionUserRestore       = "user.restore"
+	ActionUserPurge         = "user.purge"
+	ActionUserResetPassword = "user.reset_password"
+	ActionUserUnlock        = "user.unlock"
+	ActionUserEnrollTOTP    = "user.enroll_totp"
+	ActionUserConfirmTOTP   = "user.confirm_totp"
+	ActionUserDisableTOTP   = "user.disable_totp"
+)
+
+// Event represents a change made to a record, who made it and when.
+type Event struct {
+	ID          string    `db:"event_id" json:"id"`
+	ActorID     string    `db:"actor_id" json:"actor_id"`
+	Action      string    `db:"action" json:"action"`
+	TargetType  string    `db:"target_type" json:"target_type"`
+	TargetID    string    `db:"target_id" json:"target_id"`
+	Diff        Diff      `db:"diff" log:"redact" json:"diff"`
+	TraceID     string    `db:"trace_id" json:"trace_id"`
+	DateCreated time.Time `db:"date_created" json:"date_created"`
+}
+
+// NewEvent contains information needed to record a change.
+type NewEvent struct {
+	ActorID    string `validate:"required"`
+	Action     string `validate:"required"`
+	TargetType string `validate:"required"`
+	TargetID   string `validate:"required"`
+	Diff       Diff
+	TraceID    string
+}
+
+// QueryFilter holds the available fields a query can be filtered on. Nil
+// fields are not applied to the query.
+type QueryFilter struct {
+	ActorID    *string    `validate:"omitempty,min=1"`
+	Action     *string    `validate:"omitempty,min=1"`
+	TargetType *string    `validate:"omitempty,min=1"`
+	TargetID   *string    `validate:"omitempty,min=1"`
+	From       *time.Time `validate:"omitempty"`
+	To         *time.Time `validate:"omitempty"`
+}
@@ -0,0 +1,93 @@
+// Package identity contains the storage for the links between users and
+// their accounts at external identity providers.
+package identity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mihailtudos/service3/business/sys/database"
+	"github.com/mihailtudos/service3/business/sys/validate"
+	"go.uber.org/zap"
+)
+
+// Store manages the set of APIs for identity access.
+type Store struct {
+	db  sqlx.ExtContext
+	log *zap.SugaredLogger
+}
+
+// NewStore constructs an identity store for api access.
+func NewStore(db *sqlx.DB, log *zap.SugaredLogger) Store {
+	return Store{
+		db:  db,
+		log: log,
+	}
+}
+
+// Tran returns a new Store bound to the specified transaction.
+func (s Store) Tran(tx sqlx.ExtContext) Store {
+	return Store{
+		db:  tx,
+		log: s.log,
+	}
+}
+
+// Create links the identity at the issuer to the user.
+func (s Store) Create(ctx context.Context, issuer string, subject string, userID string, now time.Time) (Identity, error) {
+	if err := validate.CheckID(userID); err != nil {
+		return Identity{}, database.ErrInvalidID
+	}
+
+	id := Identity{
+		Issuer:      issuer,
+		Subject:     subject,
+		UserID:      userID,
+		DateCreated: now,
+	}
+
+	const q = `
+	INSERT INTO user_identities
+		(issuer, subject, user_id, date_created)
+	VALUES
+		(:issuer, :subject, :user_id, :date_created)`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, id); err != nil {
+		return Identity{}, fmt.Errorf("inserting identity: %w", err)
+	}
+
+	return id, nil
+}
+
+// QueryBySubject gets the identity for the subject at the issuer.
+func (s Store) QueryBySubject(ctx context.Context, issuer string, subject string) (Identity, error) {
+	data := struct {
+		Issuer  string `db:"issuer"`
+		Subject string `db:"subject"`
+	}{
+		Issuer:  issuer,
+		Subject: subject,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		user_identities
+	WHERE
+		issuer = :issuer AND
+		subject = :subject`
+
+	var id Identity
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &id); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return Identity{}, database.ErrNotFound
+		}
+		return Identity{}, fmt.Errorf("selecting identity issuer[%s] subject[%s]: %w", issuer, subject, err)
+	}
+
+	return id, nil
+}
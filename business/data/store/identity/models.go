@@ -0,0 +1,14 @@
+package identity
+
+import (
+	"time"
+)
+
+// Identity links a user of an external identity provider, known by the
+// issuer and subject of its ID tokens, to a user of the service.
+type Identity struct {
+	Issuer      string    `db:"issuer"`
+	Subject     string    `db:"subject"`
+	UserID      string    `db:"user_id"`
+	DateCreated time.Time `db:"date_created"`
+}
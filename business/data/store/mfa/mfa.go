@@ -0,0 +1,306 @@
+// Package mfa contains the storage for the second factors users authenticate
+// with: TOTP secrets and recovery codes.
+package mfa
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mihailtudos/service3/business/sys/database"
+	"github.com/mihailtudos/service3/business/sys/validate"
+	"go.uber.org/zap"
+)
+
+// Store manages the set of APIs for second factor access.
+type Store struct {
+	db  sqlx.ExtContext
+	log *zap.SugaredLogger
+}
+
+// NewStore constructs a second factor store for api access.
+func NewStore(db *sqlx.DB, log *zap.SugaredLogger) Store {
+	return Store{
+		db:  db,
+		log: log,
+	}
+}
+
+// Tran returns a new Store bound to the specified transaction.
+func (s Store) Tran(tx sqlx.ExtContext) Store {
+	return Store{
+		db:  tx,
+		log: s.log,
+	}
+}
+
+// SetSecret stores a new pending TOTP secret for the user, replacing any
+// secret the user had.
+func (s Store) SetSecret(ctx context.Context, userID string, secret string, now time.Time) error {
+	if err := validate.CheckID(userID); err != nil {
+		return database.ErrInvalidID
+	}
+
+	t := TOTP{
+		UserID:      userID,
+		Secret:      secret,
+		DateCreated: now,
+	}
+
+	const q = `
+	INSERT INTO user_totp
+		(user_id, secret, last_step, date_created, date_enabled)
+	VALUES
+		(:user_id, :secret, :last_step, :date_created, :date_enabled)
+	ON CONFLICT (user_id) DO UPDATE SET
+		"secret" = :secret,
+		"last_step" = :last_step,
+		"date_created" = :date_created,
+		"date_enabled" = :date_enabled`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, t); err != nil {
+		return fmt.Errorf("setting totp secret userID[%s]: %w", userID, err)
+	}
+
+	return nil
+}
+
+// QueryByUserID gets the TOTP secret of the user. The row is locked so a
+// step can only be accepted once when the store is bound to a transaction.
+func (s Store) QueryByUserID(ctx context.Context, userID string) (TOTP, error) {
+	if err := validate.CheckID(userID); err != nil {
+		return TOTP{}, database.ErrInvalidID
+	}
+
+	data := struct {
+		UserID string `db:"user_id"`
+	}{
+		UserID: userID,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		user_totp
+	WHERE
+		user_id = :user_id
+	FOR UPDATE`
+
+	var t TOTP
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &t); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return TOTP{}, database.ErrNotFound
+		}
+		return TOTP{}, fmt.Errorf("selecting totp userID[%s]: %w", userID, err)
+	}
+
+	return t, nil
+}
+
+// Enable marks the TOTP secret of the user as confirmed, recording the step
+// of the code it was confirmed with.
+func (s Store) Enable(ctx context.Context, userID string, step int64, now time.Time) error {
+	data := struct {
+		UserID      string    `db:"user_id"`
+		LastStep    int64     `db:"last_step"`
+		DateEnabled time.Time `db:"date_enabled"`
+	}{
+		UserID:      userID,
+		LastStep:    step,
+		DateEnabled: now,
+	}
+
+	const q = `
+	UPDATE
+		user_totp
+	SET
+		"last_step" = :last_step,
+		"date_enabled" = :date_enabled
+	WHERE
+		user_id = :user_id`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, data); err != nil {
+		return fmt.Errorf("enabling totp userID[%s]: %w", userID, err)
+	}
+
+	return nil
+}
+
+// UpdateLastStep records the step of the last code accepted for the user.
+func (s Store) UpdateLastStep(ctx context.Context, userID string, step int64) error {
+	data := struct {
+		UserID   string `db:"user_id"`
+		LastStep int64  `db:"last_step"`
+	}{
+		UserID:   userID,
+		LastStep: step,
+	}
+
+	const q = `
+	UPDATE
+		user_totp
+	SET
+		"last_step" = :last_step
+	WHERE
+		user_id = :user_id`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, data); err != nil {
+		return fmt.Errorf("updating totp step userID[%s]: %w", userID, err)
+	}
+
+	return nil
+}
+
+// Delete removes the TOTP secret and the recovery codes of the user.
+func (s Store) Delete(ctx context.Context, userID string) error {
+	if err := validate.CheckID(userID); err != nil {
+		return database.ErrInvalidID
+	}
+
+	data := struct {
+		UserID string `db:"user_id"`
+	}{
+		UserID: userID,
+	}
+
+	const qt = `
+	DELETE FROM
+		user_totp
+	WHERE
+		user_id = :user_id`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, qt, data); err != nil {
+		return fmt.Errorf("deleting totp userID[%s]: %w", userID, err)
+	}
+
+	const qr = `
+	DELETE FROM
+		recovery_codes
+	WHERE
+		user_id = :user_id`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, qr, data); err != nil {
+		return fmt.Errorf("deleting recovery codes userID[%s]: %w", userID, err)
+	}
+
+	return nil
+}
+
+// CreateRecoveryCodes replaces the recovery codes of the user with n new
+// ones. The codes themselves are only returned here and can't be recovered
+// later.
+func (s Store) CreateRecoveryCodes(ctx context.Context, userID string, n int, now time.Time) ([]string, error) {
+	if err := validate.CheckID(userID); err != nil {
+		return nil, database.ErrInvalidID
+	}
+
+	data := struct {
+		UserID string `db:"user_id"`
+	}{
+		UserID: userID,
+	}
+
+	const qd = `
+	DELETE FROM
+		recovery_codes
+	WHERE
+		user_id = :user_id`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, qd, data); err != nil {
+		return nil, fmt.Errorf("deleting recovery codes userID[%s]: %w", userID, err)
+	}
+
+	const qi = `
+	INSERT INTO recovery_codes
+		(code_id, user_id, code_hash, date_created)
+	VALUES
+		(:code_id, :user_id, :code_hash, :date_created)`
+
+	codes := make([]string, n)
+	for i := range codes {
+		code, err := generateCode()
+		if err != nil {
+			return nil, fmt.Errorf("generating recovery code: %w", err)
+		}
+
+		rc := RecoveryCode{
+			ID:          validate.GenerateID(),
+			UserID:      userID,
+			CodeHash:    hash(code),
+			DateCreated: now,
+		}
+
+		if err := database.NamedExecContext(ctx, s.log, s.db, qi, rc); err != nil {
+			return nil, fmt.Errorf("inserting recovery code: %w", err)
+		}
+
+		codes[i] = code
+	}
+
+	return codes, nil
+}
+
+// UseRecoveryCode marks the unused recovery code of the user as used. It
+// returns database.ErrNotFound when the user has no such unused code.
+func (s Store) UseRecoveryCode(ctx context.Context, userID string, code string, now time.Time) error {
+	data := struct {
+		UserID   string    `db:"user_id"`
+		CodeHash string    `db:"code_hash" log:"redact"`
+		DateUsed time.Time `db:"date_used"`
+	}{
+		UserID:   userID,
+		CodeHash: hash(code),
+		DateUsed: now,
+	}
+
+	const q = `
+	UPDATE
+		recovery_codes
+	SET
+		"date_used" = :date_used
+	WHERE
+		user_id = :user_id AND
+		code_hash = :code_hash AND
+		date_used IS NULL
+	RETURNING
+		*`
+
+	var rc RecoveryCode
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &rc); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return database.ErrNotFound
+		}
+		return fmt.Errorf("using recovery code userID[%s]: %w", userID, err)
+	}
+
+	return nil
+}
+
+// generateCode returns a new recovery code carrying 80 bits of randomness,
+// grouped to be easy to copy by hand.
+func generateCode() (string, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	s := base32.StdEncoding.EncodeToString(b)
+	return s[0:4] + "-" + s[4:8] + "-" + s[8:12] + "-" + s[12:16], nil
+}
+
+// hash returns the hex encoded SHA-256 of the recovery code, ignoring case
+// and the separators between groups. The codes carry enough randomness for a
+// fast hash to make a leaked table useless.
+func hash(code string) string {
+	code = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,26 @@
+package mfa
+
+import (
+	"time"
+)
+
+// TOTP represents the time-based one-time password secret of a user. The
+// secret is pending until the user confirms it with a code, and the last
+// step a code was accepted for is kept so a code can't be used twice.
+type TOTP struct {
+	UserID      string     `db:"user_id"`
+	Secret      string     `db:"secret" log:"redact"`
+	LastStep    int64      `db:"last_step"`
+	DateCreated time.Time  `db:"date_created"`
+	DateEnabled *time.Time `db:"date_enabled"`
+}
+
+// RecoveryCode represents a single-use code a user can log in with when
+// they lost their authenticator. Only a hash of the code is stored.
+type RecoveryCode struct {
+	ID          string     `db:"code_id"`
+	UserID      string     `db:"user_id"`
+	CodeHash    string     `db:"code_hash" log:"redact"`
+	DateCreated time.Time  `db:"date_created"`
+	DateUsed    *time.Time `db:"date_used"`
+}
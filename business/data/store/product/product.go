@@ -0,0 +1,304 @@
+// Package product contains product related CRUD functionality.
+package product
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mihailtudos/service3/business/sys/auth"
+	"github.com/mihailtudos/service3/business/sys/database"
+	"github.com/mihailtudos/service3/business/sys/validate"
+	"go.uber.org/zap"
+)
+
+// ErrInsufficientStock occurs when more of a product is requested than is
+// in stock.
+var ErrInsufficientStock = validate.NewError(validate.KindConflict, validate.CodeInsufficientStock, "not enough product in stock")
+
+// Store manages the set of APIs for product access.
+type Store struct {
+	db  sqlx.ExtContext
+	log *zap.SugaredLogger
+}
+
+// NewStore constructs a product store for api access.
+func NewStore(db *sqlx.DB, log *zap.SugaredLogger) Store {
+	return Store{
+		db:  db,
+		log: log,
+	}
+}
+
+// Tran returns a new Store bound to the specified transaction.
+func (s Store) Tran(tx sqlx.ExtContext) Store {
+	return Store{
+		db:  tx,
+		log: s.log,
+	}
+}
+
+// Create adds a Product to the database. It returns the created Product with
+// fields like ID and DateCreated populated. The product is owned by the
+// subject of the provided claims.
+func (s Store) Create(ctx context.Context, claims auth.Claims, np NewProduct, now time.Time) (Product, error) {
+	if err := validate.Check(np); err != nil {
+		return Product{}, fmt.Errorf("validating data: %w", err)
+	}
+
+	prd := Product{
+		ID:          validate.GenerateID(),
+		Name:        np.Name,
+		Cost:        np.Cost,
+		Quantity:    np.Quantity,
+		UserID:      claims.Subject,
+		DateCreated: now,
+		DateUpdated: now,
+	}
+
+	const q = `
+	INSERT INTO products
+		(product_id, user_id, name, cost, quantity, date_created, date_updated)
+	VALUES
+		(:product_id, :user_id, :name, :cost, :quantity, :date_created, :date_updated)`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, prd); err != nil {
+		return Product{}, fmt.Errorf("inserting product: %w", err)
+	}
+
+	return prd, nil
+}
+
+// Update modifies data about a Product. It will error if the specified ID is
+// invalid or does not reference an existing Product. Only the fields provided
+// are written, in a single statement, so a concurrent Reserve taking stock
+// isn't overwritten.
+func (s Store) Update(ctx context.Context, productID string, up UpdateProduct, now time.Time) error {
+	if err := validate.CheckID(productID); err != nil {
+		return database.ErrInvalidID
+	}
+
+	if err := validate.Check(up); err != nil {
+		return fmt.Errorf("validating data: %w", err)
+	}
+
+	data := struct {
+		ProductID   string    `db:"product_id"`
+		Name        *string   `db:"name"`
+		Cost        *int      `db:"cost"`
+		Quantity    *int      `db:"quantity"`
+		DateUpdated time.Time `db:"date_updated"`
+	}{
+		ProductID:   productID,
+		Name:        up.Name,
+		Cost:        up.Cost,
+		Quantity:    up.Quantity,
+		DateUpdated: now,
+	}
+
+	const q = `
+	UPDATE
+		products
+	SET
+		"name" = COALESCE(:name, "name"),
+		"cost" = COALESCE(:cost, "cost"),
+		"quantity" = COALESCE(:quantity, "quantity"),
+		"date_updated" = :date_updated
+	WHERE
+		product_id = :product_id
+	RETURNING
+		*`
+
+	var prd Product
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &prd); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return database.ErrNotFound
+		}
+		return fmt.Errorf("updating product productID[%s]: %w", productID, err)
+	}
+
+	return nil
+}
+
+// Delete removes the product identified by a given ID.
+func (s Store) Delete(ctx context.Context, productID string) error {
+	if err := validate.CheckID(productID); err != nil {
+		return database.ErrInvalidID
+	}
+
+	data := struct {
+		ProductID string `db:"product_id"`
+	}{
+		ProductID: productID,
+	}
+
+	const q = `
+	DELETE FROM
+		products
+	WHERE
+		product_id = :product_id`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, data); err != nil {
+		return fmt.Errorf("deleting product productID[%s]: %w", productID, err)
+	}
+
+	return nil
+}
+
+// Reserve takes the specified quantity of a product out of stock and returns
+// the product as it was before the reservation. The product row is locked so
+// the store must be bound to a transaction for the lock to be held until the
+// caller has finished with the product.
+func (s Store) Reserve(ctx context.Context, productID string, quantity int, now time.Time) (Product, error) {
+	if err := validate.CheckID(productID); err != nil {
+		return Product{}, database.ErrInvalidID
+	}
+
+	data := struct {
+		ProductID string `db:"product_id"`
+	}{
+		ProductID: productID,
+	}
+
+	const qSel = `
+	SELECT
+		*
+	FROM
+		products
+	WHERE
+		product_id = :product_id
+	FOR UPDATE`
+
+	var prd Product
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, qSel, data, &prd); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return Product{}, database.ErrNotFound
+		}
+		return Product{}, fmt.Errorf("selecting product productID[%s]: %w", productID, err)
+	}
+
+	if prd.Quantity < quantity {
+		return Product{}, ErrInsufficientStock
+	}
+
+	upd := struct {
+		ProductID   string    `db:"product_id"`
+		Quantity    int       `db:"quantity"`
+		DateUpdated time.Time `db:"date_updated"`
+	}{
+		ProductID:   productID,
+		Quantity:    quantity,
+		DateUpdated: now,
+	}
+
+	const qUpd = `
+	UPDATE
+		products
+	SET
+		"quantity" = "quantity" - :quantity,
+		"date_updated" = :date_updated
+	WHERE
+		product_id = :product_id`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, qUpd, upd); err != nil {
+		return Product{}, fmt.Errorf("reserving product productID[%s]: %w", productID, err)
+	}
+
+	return prd, nil
+}
+
+// Query gets all Products from the database.
+func (s Store) Query(ctx context.Context, pageNumber int, rowsPerPage int) ([]Product, error) {
+	data := struct {
+		Offset      int `db:"offset"`
+		RowsPerPage int `db:"rows_per_page"`
+	}{
+		Offset:      (pageNumber - 1) * rowsPerPage,
+		RowsPerPage: rowsPerPage,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		products
+	ORDER BY
+		product_id
+	OFFSET :offset ROWS FETCH NEXT :rows_per_page ROWS ONLY`
+
+	var prds []Product
+	if err := database.NamedQuerySlice(ctx, s.log, s.db, q, data, &prds); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return nil, database.ErrNotFound
+		}
+		return nil, fmt.Errorf("selecting products: %w", err)
+	}
+
+	return prds, nil
+}
+
+// QueryByID finds the product identified by a given ID.
+func (s Store) QueryByID(ctx context.Context, productID string) (Product, error) {
+	if err := validate.CheckID(productID); err != nil {
+		return Product{}, database.ErrInvalidID
+	}
+
+	data := struct {
+		ProductID string `db:"product_id"`
+	}{
+		ProductID: productID,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		products
+	WHERE
+		product_id = :product_id`
+
+	var prd Product
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &prd); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return Product{}, database.ErrNotFound
+		}
+		return Product{}, fmt.Errorf("selecting product productID[%s]: %w", productID, err)
+	}
+
+	return prd, nil
+}
+
+// QueryByUserID finds the products owned by the specified user.
+func (s Store) QueryByUserID(ctx context.Context, userID string) ([]Product, error) {
+	if err := validate.CheckID(userID); err != nil {
+		return nil, database.ErrInvalidID
+	}
+
+	data := struct {
+		UserID string `db:"user_id"`
+	}{
+		UserID: userID,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		products
+	WHERE
+		user_id = :user_id
+	ORDER BY
+		product_id`
+
+	var prds []Product
+	if err := database.NamedQuerySlice(ctx, s.log, s.db, q, data, &prds); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return nil, database.ErrNotFound
+		}
+		return nil, fmt.Errorf("selecting products userID[%s]: %w", userID, err)
+	}
+
+	return prds, nil
+}
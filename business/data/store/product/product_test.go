@@ -0,0 +1,123 @@
+package product_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/go-cmp/cmp"
+	"github.com/mihailtudos/service3/business/data/store/product"
+	"github.com/mihailtudos/service3/business/data/tests"
+	"github.com/mihailtudos/service3/business/sys/auth"
+	"github.com/mihailtudos/service3/business/sys/database"
+)
+
+var dbc = tests.DBContainer{
+	Image: "postgres:17-alpine",
+	Port:  "5432",
+	Args:  []string{"-e", "POSTGRES_PASSWORD=postgres"},
+}
+
+func TestProduct(t *testing.T) {
+	log, db, teardown := tests.NewUnit(t, dbc)
+	t.Cleanup(teardown)
+
+	store := product.NewStore(db, log)
+
+	t.Log("Given the need to work with Product records.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen handling a single Product.", testID)
+		{
+			ctx := context.Background()
+			now := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+			// The seeded "User Gopher" account owns the product.
+			claims := auth.Claims{
+				RegisteredClaims: jwt.RegisteredClaims{
+					Subject:   "45b5fbd3-755f-4379-8f07-a58d4a30fa2f",
+					Issuer:    "service project",
+					ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+					IssuedAt:  jwt.NewNumericDate(time.Now()),
+				},
+				Roles: []string{auth.RoleUser},
+			}
+
+			np := product.NewProduct{
+				Name:     "Comic Books",
+				Cost:     10,
+				Quantity: 55,
+			}
+
+			prd, err := store.Create(ctx, claims, np, now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create a product : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to create a product.", tests.Success, testID)
+
+			saved, err := store.QueryByID(ctx, prd.ID)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to retrieve product by ID: %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to retrieve product by ID.", tests.Success, testID)
+
+			if diff := cmp.Diff(prd, saved); diff != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould get back the same product. Diff:\n%s", tests.Failed, testID, diff)
+			}
+			t.Logf("\t%s\tTest %d:\tShould get back the same product.", tests.Success, testID)
+
+			upd := product.UpdateProduct{
+				Name:     tests.StringPointer("Comics"),
+				Cost:     tests.IntPointer(50),
+				Quantity: tests.IntPointer(40),
+			}
+			updatedTime := time.Date(2019, time.January, 1, 1, 1, 1, 0, time.UTC)
+
+			if err := store.Update(ctx, prd.ID, upd, updatedTime); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to update product : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to update product.", tests.Success, testID)
+
+			prds, err := store.QueryByUserID(ctx, claims.Subject)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to retrieve products by user : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to retrieve products by user.", tests.Success, testID)
+
+			var found bool
+			for _, p := range prds {
+				if p.ID != prd.ID {
+					continue
+				}
+				found = true
+
+				want := prd
+				want.Name = *upd.Name
+				want.Cost = *upd.Cost
+				want.Quantity = *upd.Quantity
+				want.DateUpdated = updatedTime
+
+				if diff := cmp.Diff(want, p); diff != "" {
+					t.Fatalf("\t%s\tTest %d:\tShould get back the updated product. Diff:\n%s", tests.Failed, testID, diff)
+				}
+				t.Logf("\t%s\tTest %d:\tShould get back the updated product.", tests.Success, testID)
+			}
+			if !found {
+				t.Fatalf("\t%s\tTest %d:\tShould find the product in the user's products.", tests.Failed, testID)
+			}
+
+			if err := store.Delete(ctx, prd.ID); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to delete product : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to delete product.", tests.Success, testID)
+
+			_, err = store.QueryByID(ctx, prd.ID)
+			if !errors.Is(err, database.ErrNotFound) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to retrieve deleted product : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to retrieve deleted product.", tests.Success, testID)
+		}
+	}
+}
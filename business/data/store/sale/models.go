@@ -0,0 +1,23 @@
+package sale
+
+import (
+	"time"
+)
+
+// Sale represents a transaction where a user bought a quantity of a product.
+type Sale struct {
+	ID          string    `db:"sale_id" json:"id"`
+	UserID      string    `db:"user_id" json:"user_id"`
+	ProductID   string    `db:"product_id" json:"product_id"`
+	Quantity    int       `db:"quantity" json:"quantity"`
+	Paid        int       `db:"paid" json:"paid"`
+	DateCreated time.Time `db:"date_created" json:"date_created"`
+}
+
+// NewSale is what we require from clients for recording a Sale. The buyer is
+// taken from the claims of the authenticated user and the amount paid is
+// calculated from the current cost of the product.
+type NewSale struct {
+	ProductID string `json:"product_id" validate:"required"`
+	Quantity  int    `json:"quantity" validate:"gte=1"`
+}
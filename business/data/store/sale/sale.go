@@ -0,0 +1,137 @@
+// Package sale contains sale related CRUD functionality.
+package sale
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mihailtudos/service3/business/sys/auth"
+	"github.com/mihailtudos/service3/business/sys/database"
+	"github.com/mihailtudos/service3/business/sys/validate"
+	"go.uber.org/zap"
+)
+
+// Store manages the set of APIs for sale access.
+type Store struct {
+	db  sqlx.ExtContext
+	log *zap.SugaredLogger
+}
+
+// NewStore constructs a sale store for api access.
+func NewStore(db *sqlx.DB, log *zap.SugaredLogger) Store {
+	return Store{
+		db:  db,
+		log: log,
+	}
+}
+
+// Tran returns a new Store bound to the specified transaction.
+func (s Store) Tran(tx sqlx.ExtContext) Store {
+	return Store{
+		db:  tx,
+		log: s.log,
+	}
+}
+
+// Create records a Sale for the subject of the provided claims. The caller is
+// responsible for taking the quantity out of stock and for working out what
+// was paid, ideally within the same transaction.
+func (s Store) Create(ctx context.Context, claims auth.Claims, ns NewSale, paid int, now time.Time) (Sale, error) {
+	if err := validate.Check(ns); err != nil {
+		return Sale{}, fmt.Errorf("validating data: %w", err)
+	}
+
+	if err := validate.CheckID(ns.ProductID); err != nil {
+		return Sale{}, database.ErrInvalidID
+	}
+
+	sl := Sale{
+		ID:          validate.GenerateID(),
+		UserID:      claims.Subject,
+		ProductID:   ns.ProductID,
+		Quantity:    ns.Quantity,
+		Paid:        paid,
+		DateCreated: now,
+	}
+
+	const q = `
+	INSERT INTO sales
+		(sale_id, user_id, product_id, quantity, paid, date_created)
+	VALUES
+		(:sale_id, :user_id, :product_id, :quantity, :paid, :date_created)`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, sl); err != nil {
+		return Sale{}, fmt.Errorf("inserting sale: %w", err)
+	}
+
+	return sl, nil
+}
+
+// QueryByUserID gets the sales made by the specified user.
+func (s Store) QueryByUserID(ctx context.Context, userID string) ([]Sale, error) {
+	if err := validate.CheckID(userID); err != nil {
+		return nil, database.ErrInvalidID
+	}
+
+	data := struct {
+		UserID string `db:"user_id"`
+	}{
+		UserID: userID,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		sales
+	WHERE
+		user_id = :user_id
+	ORDER BY
+		date_created DESC`
+
+	var sales []Sale
+	if err := database.NamedQuerySlice(ctx, s.log, s.db, q, data, &sales); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return nil, database.ErrNotFound
+		}
+		return nil, fmt.Errorf("selecting sales userID[%s]: %w", userID, err)
+	}
+
+	return sales, nil
+}
+
+// QueryByProductID gets the sales recorded against the specified product.
+func (s Store) QueryByProductID(ctx context.Context, productID string) ([]Sale, error) {
+	if err := validate.CheckID(productID); err != nil {
+		return nil, database.ErrInvalidID
+	}
+
+	data := struct {
+		ProductID string `db:"product_id"`
+	}{
+		ProductID: productID,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		sales
+	WHERE
+		product_id = :product_id
+	ORDER BY
+		date_created DESC`
+
+	var sales []Sale
+	if err := database.NamedQuerySlice(ctx, s.log, s.db, q, data, &sales); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return nil, database.ErrNotFound
+		}
+		return nil, fmt.Errorf("selecting sales productID[%s]: %w", productID, err)
+	}
+
+	return sales, nil
+}
@@ -0,0 +1,40 @@
+package token
+
+import (
+	"time"
+)
+
+// RefreshToken represents a refresh token issued to a user. Only a hash of
+// the token is stored. Tokens created by rotating another token share its
+// family so the whole chain can be revoked when reuse is detected.
+type RefreshToken struct {
+	ID          string     `db:"token_id"`
+	FamilyID    string     `db:"family_id"`
+	UserID      string     `db:"user_id"`
+	TokenHash   string     `db:"token_hash" log:"redact"`
+	ReplacedBy  *string    `db:"replaced_by"`
+	DateCreated time.Time  `db:"date_created"`
+	DateExpires time.Time  `db:"date_expires"`
+	DateRevoked *time.Time `db:"date_revoked"`
+}
+
+// Set of purposes an action token can be issued for.
+const (
+	PurposeResetPassword = "reset_password"
+	PurposeVerifyEmail   = "verify_email"
+	PurposeMFAChallenge  = "mfa_challenge"
+)
+
+// ActionToken represents a single-use token handed to a user to reset their
+// password, verify their email or complete a login with a second factor.
+// Only a hash of the token is stored, along with the email it was sent to.
+type ActionToken struct {
+	ID          string     `db:"token_id"`
+	UserID      string     `db:"user_id"`
+	Purpose     string     `db:"purpose"`
+	Email       string     `db:"email" log:"redact"`
+	TokenHash   string     `db:"token_hash" log:"redact"`
+	DateCreated time.Time  `db:"date_created"`
+	DateExpires time.Time  `db:"date_expires"`
+	DateUsed    *time.Time `db:"date_used"`
+}
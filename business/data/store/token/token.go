@@ -0,0 +1,401 @@
+// Package token contains the storage for refresh tokens, the list of revoked
+// access tokens and the single-use action tokens mailed to users.
+package token
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mihailtudos/service3/business/sys/database"
+	"github.com/mihailtudos/service3/business/sys/validate"
+	"go.uber.org/zap"
+)
+
+// Store manages the set of APIs for token access.
+type Store struct {
+	db  sqlx.ExtContext
+	log *zap.SugaredLogger
+}
+
+// NewStore constructs a token store for api access.
+func NewStore(db *sqlx.DB, log *zap.SugaredLogger) Store {
+	return Store{
+		db:  db,
+		log: log,
+	}
+}
+
+// Tran returns a new Store bound to the specified transaction.
+func (s Store) Tran(tx sqlx.ExtContext) Store {
+	return Store{
+		db:  tx,
+		log: s.log,
+	}
+}
+
+// Create generates a new opaque refresh token for the user and stores its
+// hash. The token itself is only returned here and can't be recovered later.
+// An empty familyID starts a new family.
+func (s Store) Create(ctx context.Context, userID string, familyID string, now time.Time, ttl time.Duration) (string, RefreshToken, error) {
+	if err := validate.CheckID(userID); err != nil {
+		return "", RefreshToken{}, database.ErrInvalidID
+	}
+
+	tkn, err := generate()
+	if err != nil {
+		return "", RefreshToken{}, fmt.Errorf("generating refresh token: %w", err)
+	}
+
+	rt := RefreshToken{
+		ID:          validate.GenerateID(),
+		FamilyID:    familyID,
+		UserID:      userID,
+		TokenHash:   hash(tkn),
+		DateCreated: now,
+		DateExpires: now.Add(ttl),
+	}
+
+	if rt.FamilyID == "" {
+		rt.FamilyID = rt.ID
+	}
+
+	const q = `
+	INSERT INTO refresh_tokens
+		(token_id, family_id, user_id, token_hash, date_created, date_expires)
+	VALUES
+		(:token_id, :family_id, :user_id, :token_hash, :date_created, :date_expires)`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, rt); err != nil {
+		return "", RefreshToken{}, fmt.Errorf("inserting refresh token: %w", err)
+	}
+
+	return tkn, rt, nil
+}
+
+// QueryByToken finds the refresh token matching the opaque token string. The
+// row is locked so a token can only be rotated once when the store is bound
+// to a transaction.
+func (s Store) QueryByToken(ctx context.Context, tkn string) (RefreshToken, error) {
+	data := struct {
+		TokenHash string `db:"token_hash" log:"redact"`
+	}{
+		TokenHash: hash(tkn),
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		refresh_tokens
+	WHERE
+		token_hash = :token_hash
+	FOR UPDATE`
+
+	var rt RefreshToken
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &rt); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return RefreshToken{}, database.ErrNotFound
+		}
+		return RefreshToken{}, fmt.Errorf("selecting refresh token: %w", err)
+	}
+
+	return rt, nil
+}
+
+// Revoke marks the refresh token as used, recording the token that replaced
+// it. An empty replacedBy revokes the token without a replacement.
+func (s Store) Revoke(ctx context.Context, tokenID string, replacedBy string, now time.Time) error {
+	data := struct {
+		TokenID     string    `db:"token_id"`
+		ReplacedBy  *string   `db:"replaced_by"`
+		DateRevoked time.Time `db:"date_revoked"`
+	}{
+		TokenID:     tokenID,
+		DateRevoked: now,
+	}
+
+	if replacedBy != "" {
+		data.ReplacedBy = &replacedBy
+	}
+
+	const q = `
+	UPDATE
+		refresh_tokens
+	SET
+		"replaced_by" = :replaced_by,
+		"date_revoked" = :date_revoked
+	WHERE
+		token_id = :token_id`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, data); err != nil {
+		return fmt.Errorf("revoking refresh token tokenID[%s]: %w", tokenID, err)
+	}
+
+	return nil
+}
+
+// RevokeFamily revokes every outstanding refresh token in the family.
+func (s Store) RevokeFamily(ctx context.Context, familyID string, now time.Time) error {
+	data := struct {
+		FamilyID    string    `db:"family_id"`
+		DateRevoked time.Time `db:"date_revoked"`
+	}{
+		FamilyID:    familyID,
+		DateRevoked: now,
+	}
+
+	const q = `
+	UPDATE
+		refresh_tokens
+	SET
+		"date_revoked" = :date_revoked
+	WHERE
+		family_id = :family_id AND
+		date_revoked IS NULL`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, data); err != nil {
+		return fmt.Errorf("revoking refresh token family familyID[%s]: %w", familyID, err)
+	}
+
+	return nil
+}
+
+// RevokeUser revokes every outstanding refresh token of the user.
+func (s Store) RevokeUser(ctx context.Context, userID string, now time.Time) error {
+	data := struct {
+		UserID      string    `db:"user_id"`
+		DateRevoked time.Time `db:"date_revoked"`
+	}{
+		UserID:      userID,
+		DateRevoked: now,
+	}
+
+	const q = `
+	UPDATE
+		refresh_tokens
+	SET
+		"date_revoked" = :date_revoked
+	WHERE
+		user_id = :user_id AND
+		date_revoked IS NULL`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, data); err != nil {
+		return fmt.Errorf("revoking refresh tokens userID[%s]: %w", userID, err)
+	}
+
+	return nil
+}
+
+// RevokeAccess adds the access token identified by its jti to the revocation
+// list. The entry is kept until the token would have expired anyway.
+func (s Store) RevokeAccess(ctx context.Context, jti string, expires time.Time, now time.Time) error {
+	data := struct {
+		JTI         string    `db:"jti"`
+		DateExpires time.Time `db:"date_expires"`
+		DateCreated time.Time `db:"date_created"`
+	}{
+		JTI:         jti,
+		DateExpires: expires,
+		DateCreated: now,
+	}
+
+	const q = `
+	INSERT INTO revoked_tokens
+		(jti, date_expires, date_created)
+	VALUES
+		(:jti, :date_expires, :date_created)
+	ON CONFLICT DO NOTHING`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, data); err != nil {
+		return fmt.Errorf("revoking access token jti[%s]: %w", jti, err)
+	}
+
+	return nil
+}
+
+// IsRevoked reports whether the access token identified by its jti has been
+// revoked, or whether the user it was issued to has been deleted since. It
+// implements the auth.RevocationList interface.
+func (s Store) IsRevoked(ctx context.Context, jti string, userID string) (bool, error) {
+	if jti != "" {
+		data := struct {
+			JTI string `db:"jti"`
+		}{
+			JTI: jti,
+		}
+
+		const q = `
+		SELECT
+			jti
+		FROM
+			revoked_tokens
+		WHERE
+			jti = :jti`
+
+		var dest struct {
+			JTI string `db:"jti"`
+		}
+		err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &dest)
+		switch {
+		case err == nil:
+			return true, nil
+		case !errors.Is(err, database.ErrNotFound):
+			return false, fmt.Errorf("selecting revoked token jti[%s]: %w", jti, err)
+		}
+	}
+
+	// Only users are checked, other subjects have nothing to be deleted.
+	if err := validate.CheckID(userID); err != nil {
+		return false, nil
+	}
+
+	data := struct {
+		UserID string `db:"user_id"`
+	}{
+		UserID: userID,
+	}
+
+	const q = `
+	SELECT
+		user_id
+	FROM
+		users
+	WHERE
+		user_id = :user_id AND
+		date_deleted IS NULL`
+
+	var dest struct {
+		UserID string `db:"user_id"`
+	}
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &dest); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return true, nil
+		}
+		return false, fmt.Errorf("selecting user userID[%s]: %w", userID, err)
+	}
+
+	return false, nil
+}
+
+// CreateAction generates a new single-use token for the user to perform the
+// action described by purpose, and stores its hash. The token itself is
+// only returned here and can't be recovered later.
+func (s Store) CreateAction(ctx context.Context, userID string, purpose string, email string, now time.Time, ttl time.Duration) (string, ActionToken, error) {
+	if err := validate.CheckID(userID); err != nil {
+		return "", ActionToken{}, database.ErrInvalidID
+	}
+
+	tkn, err := generate()
+	if err != nil {
+		return "", ActionToken{}, fmt.Errorf("generating action token: %w", err)
+	}
+
+	at := ActionToken{
+		ID:          validate.GenerateID(),
+		UserID:      userID,
+		Purpose:     purpose,
+		Email:       email,
+		TokenHash:   hash(tkn),
+		DateCreated: now,
+		DateExpires: now.Add(ttl),
+	}
+
+	const q = `
+	INSERT INTO action_tokens
+		(token_id, user_id, purpose, email, token_hash, date_created, date_expires)
+	VALUES
+		(:token_id, :user_id, :purpose, :email, :token_hash, :date_created, :date_expires)`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, at); err != nil {
+		return "", ActionToken{}, fmt.Errorf("inserting action token: %w", err)
+	}
+
+	return tkn, at, nil
+}
+
+// QueryActionByToken finds the action token for the purpose matching the
+// opaque token string. The row is locked so a token can only be used once
+// when the store is bound to a transaction.
+func (s Store) QueryActionByToken(ctx context.Context, purpose string, tkn string) (ActionToken, error) {
+	data := struct {
+		Purpose   string `db:"purpose"`
+		TokenHash string `db:"token_hash" log:"redact"`
+	}{
+		Purpose:   purpose,
+		TokenHash: hash(tkn),
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		action_tokens
+	WHERE
+		token_hash = :token_hash AND
+		purpose = :purpose
+	FOR UPDATE`
+
+	var at ActionToken
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &at); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return ActionToken{}, database.ErrNotFound
+		}
+		return ActionToken{}, fmt.Errorf("selecting action token: %w", err)
+	}
+
+	return at, nil
+}
+
+// UseAction marks every unused action token of the user for the purpose as
+// used, so once one of them is used the others can't be used either.
+func (s Store) UseAction(ctx context.Context, userID string, purpose string, now time.Time) error {
+	data := struct {
+		UserID   string    `db:"user_id"`
+		Purpose  string    `db:"purpose"`
+		DateUsed time.Time `db:"date_used"`
+	}{
+		UserID:   userID,
+		Purpose:  purpose,
+		DateUsed: now,
+	}
+
+	const q = `
+	UPDATE
+		action_tokens
+	SET
+		"date_used" = :date_used
+	WHERE
+		user_id = :user_id AND
+		purpose = :purpose AND
+		date_used IS NULL`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, data); err != nil {
+		return fmt.Errorf("using action tokens userID[%s]: %w", userID, err)
+	}
+
+	return nil
+}
+
+// generate returns a new opaque token carrying 256 bits of randomness.
+func generate() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hash returns the hex encoded SHA-256 of the token. Tokens carry 256 bits of
+// randomness so a fast hash is enough to make a leaked table useless.
+func hash(tkn string) string {
+	sum := sha256.Sum256([]byte(tkn))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,88 @@
+package user
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"time"
+
+	"github.com/mihailtudos/service3/business/data/order"
+	"github.com/mihailtudos/service3/business/sys/validate"
+)
+
+// ErrInvalidCursor occurs when a cursor can't be decoded or was issued for a
+// different ordering than the one requested.
+var ErrInvalidCursor = validate.NewError(validate.KindInvalid, validate.CodeInvalidCursor, "cursor is not in its proper form")
+
+// cursor marks the position of the last user on a page. It carries the value
+// of the ordered field plus the user id as a tie-breaker so the next page can
+// be found with an index seek instead of an offset scan.
+type cursor struct {
+	Field     string `json:"f"`
+	Direction string `json:"d"`
+	Value     string `json:"v"`
+	UserID    string `json:"id"`
+}
+
+// newCursor constructs the cursor that points just past the specified user.
+func newCursor(orderBy order.By, usr User) cursor {
+	c := cursor{
+		Field:     orderBy.Field,
+		Direction: orderBy.Direction,
+		UserID:    usr.ID,
+	}
+
+	switch orderBy.Field {
+	case OrderByName:
+		c.Value = usr.Name
+	case OrderByEmail:
+		c.Value = usr.Email
+	case OrderByDateCreated:
+		c.Value = usr.DateCreated.Format(time.RFC3339Nano)
+	}
+
+	return c
+}
+
+// encode returns the opaque string form of the cursor handed to clients.
+func (c cursor) encode() string {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return ""
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// value returns the cursor value in the type of the ordered column.
+func (c cursor) value() (any, error) {
+	if c.Field != OrderByDateCreated {
+		return c.Value, nil
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, c.Value)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return t, nil
+}
+
+// decodeCursor parses a cursor previously returned to a client and checks it
+// was issued for the same ordering.
+func decodeCursor(s string, orderBy order.By) (cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor{}, ErrInvalidCursor
+	}
+
+	var c cursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return cursor{}, ErrInvalidCursor
+	}
+
+	if c.Field != orderBy.Field || c.Direction != orderBy.Direction || c.UserID == "" {
+		return cursor{}, ErrInvalidCursor
+	}
+
+	return c, nil
+}
@@ -2,26 +2,30 @@ package user
 
 import (
 	"github.com/lib/pq"
+	"github.com/mihailtudos/service3/business/data/order"
 	"time"
 )
 
 // User represents an individual user.
 type User struct {
-	ID           string         `db:"user_id" json:"id"`
-	Name         string         `db:"name" json:"name"`
-	Email        string         `db:"email" json:"email"`
-	Roles        pq.StringArray `db:"roles" json:"roles"`
-	PasswordHash []byte         `db:"password_hash" json:"-"`
-	DateCreated  time.Time      `db:"date_created" json:"date_created"`
-	DateUpdated  time.Time      `db:"date_updated" json:"date_updated"`
+	ID            string         `db:"user_id" json:"id"`
+	Name          string         `db:"name" json:"name"`
+	Email         string         `db:"email" log:"redact" json:"email"`
+	EmailVerified bool           `db:"email_verified" json:"email_verified"`
+	Roles         pq.StringArray `db:"roles" json:"roles"`
+	PasswordHash  []byte         `db:"password_hash" log:"redact" json:"-"`
+	DateCreated   time.Time      `db:"date_created" json:"date_created"`
+	DateUpdated   time.Time      `db:"date_updated" json:"date_updated"`
+	DateDeleted   *time.Time     `db:"date_deleted" json:"date_deleted,omitempty"`
+	Version       int            `db:"version" json:"-"`
 }
 
 // NewUser contains information needed to create a new User.
 type NewUser struct {
 	Name            string   `db:"name" validate:"required"`
-	Email           string   `db:"email" validate:"required,email"`
+	Email           string   `db:"email" log:"redact" validate:"required,email"`
 	Roles           []string `db:"roles" validate:"required"`
-	Password        string   `db:"password" validate:"required"`
+	Password        string   `db:"password" log:"redact" validate:"required"`
 	PasswordConfirm string   `json:"password_confirm" validate:"eqfield=Password"`
 }
 
@@ -38,3 +42,30 @@ type UpdateUser struct {
 	Password        *string  `json:"password"`
 	PasswordConfirm *string  `json:"password_confirm" validate:"omitempty,eqfield=Password"`
 }
+
+// QueryFilter holds the available fields a query can be filtered on. Nil
+// fields are not applied to the query.
+type QueryFilter struct {
+	Name          *string    `validate:"omitempty,min=1"`
+	Email         *string    `validate:"omitempty,email"`
+	Role          *string    `validate:"omitempty,oneof=ADMIN USER"`
+	CreatedAfter  *time.Time `validate:"omitempty"`
+	CreatedBefore *time.Time `validate:"omitempty"`
+}
+
+// Set of fields that the results can be ordered by.
+const (
+	OrderByName        = "name"
+	OrderByEmail       = "email"
+	OrderByDateCreated = "date_created"
+)
+
+// OrderByFields maps the fields clients can order by to the database columns.
+var OrderByFields = map[string]string{
+	OrderByName:        "name",
+	OrderByEmail:       "email",
+	OrderByDateCreated: "date_created",
+}
+
+// DefaultOrderBy is used when the client doesn't ask for an ordering.
+var DefaultOrderBy = order.NewBy(OrderByDateCreated, order.ASC)
@@ -5,27 +5,88 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
+
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/mihailtudos/service3/business/data/order"
 	"github.com/mihailtudos/service3/business/sys/auth"
 	"github.com/mihailtudos/service3/business/sys/database"
+	"github.com/mihailtudos/service3/business/sys/password"
 	"time"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/mihailtudos/service3/business/sys/validate"
 	"go.uber.org/zap"
-	"golang.org/x/crypto/bcrypt"
 )
 
+// AnyVersion can be passed to the calls checking the version of a user to
+// skip the check.
+const AnyVersion = 0
+
+// PasswordHasher hashes passwords and verifies them against stored hashes.
+// NeedsRehash reports whether a stored hash should be replaced because it
+// was made by another algorithm or with outdated parameters.
+type PasswordHasher interface {
+	Hash(password string) ([]byte, error)
+	Compare(hash []byte, password string) error
+	NeedsRehash(hash []byte) bool
+}
+
+// Store manages the set of APIs for user access.
 type Store struct {
-	db  *sqlx.DB
-	log *zap.SugaredLogger
+	log          *zap.SugaredLogger
+	tr           database.Transactor
+	db           sqlx.ExtContext
+	isWithinTran bool
+	hasher       PasswordHasher
+	policy       password.Policy
 }
 
+// NewStore constructs a user store for api access. Passwords are hashed with
+// bcrypt at the default cost and checked against the default policy.
 func NewStore(db *sqlx.DB, log *zap.SugaredLogger) Store {
 	return Store{
-		db:  db,
-		log: log,
+		log:    log,
+		tr:     db,
+		db:     db,
+		hasher: password.NewBcrypt(),
+		policy: password.DefaultPolicy(),
+	}
+}
+
+// Tran returns a new Store bound to the specified transaction. Every call
+// made through the returned Store takes part in that transaction.
+func (s Store) Tran(tx sqlx.ExtContext) Store {
+	return Store{
+		log:          s.log,
+		tr:           s.tr,
+		db:           tx,
+		isWithinTran: true,
+		hasher:       s.hasher,
+		policy:       s.policy,
+	}
+}
+
+// WithPasswords returns a new Store hashing passwords with the hasher and
+// checking new passwords against the policy.
+func (s Store) WithPasswords(hasher PasswordHasher, policy password.Policy) Store {
+	s.hasher = hasher
+	s.policy = policy
+	return s
+}
+
+// WithinTran runs the function against a Store bound to a transaction. When
+// the Store is already bound to a transaction that transaction is reused.
+func (s Store) WithinTran(ctx context.Context, fn func(Store) error) error {
+	if s.isWithinTran {
+		return fn(s)
 	}
+
+	f := func(tx sqlx.ExtContext) error {
+		return fn(s.Tran(tx))
+	}
+
+	return database.WithinTran(ctx, s.log, s.tr, f)
 }
 
 // Create inserts a new user into the database.
@@ -34,7 +95,11 @@ func (s Store) Create(ctx context.Context, nu NewUser, now time.Time) (User, err
 		return User{}, fmt.Errorf("validating data: %w", err)
 	}
 
-	hash, err := bcrypt.GenerateFromPassword([]byte(nu.Password), bcrypt.DefaultCost)
+	if err := s.policy.Check("password", nu.Password); err != nil {
+		return User{}, fmt.Errorf("validating data: %w", err)
+	}
+
+	hash, err := s.hasher.Hash(nu.Password)
 	if err != nil {
 		return User{}, fmt.Errorf("generating password hash: %w", err)
 	}
@@ -47,22 +112,31 @@ func (s Store) Create(ctx context.Context, nu NewUser, now time.Time) (User, err
 		Roles:        nu.Roles,
 		DateCreated:  now,
 		DateUpdated:  now,
+		Version:      1,
 	}
 
 	const q = `
 	INSERT INTO users
-		(user_id, name, email, password_hash, roles, date_created, date_updated)
+		(user_id, name, email, password_hash, roles, date_created, date_updated, version)
 	VALUES
-	    (:user_id, :name, :email, :password_hash, :roles, :date_created, :date_updated)`
+	    (:user_id, :name, :email, :password_hash, :roles, :date_created, :date_updated, :version)`
 
 	if err := database.NamedExecContext(ctx, s.log, s.db, q, usr); err != nil {
+		if errors.Is(err, database.ErrDuplicate) {
+			fields := validate.FieldErrors{{Field: "email", Error: "already in use"}}
+			return User{}, fmt.Errorf("validating data: %w", fields)
+		}
 		return User{}, fmt.Errorf("inserting user: %w", err)
 	}
 
 	return usr, nil
 }
 
-func (s Store) Update(ctx context.Context, claims auth.Claims, userID string, uu UpdateUser, now time.Time) error {
+// Update replaces a user document in the database. The row is locked for the
+// duration of the read-modify-write so concurrent updates can't interleave.
+// Unless version is AnyVersion, ErrConflict is returned when the stored
+// version doesn't match, so a client can't overwrite changes it hasn't seen.
+func (s Store) Update(ctx context.Context, userID string, version int, uu UpdateUser, now time.Time) error {
 	if err := validate.CheckID(userID); err != nil {
 		return database.ErrInvalidID
 	}
@@ -71,62 +145,193 @@ func (s Store) Update(ctx context.Context, claims auth.Claims, userID string, uu
 		return fmt.Errorf("validating data: %w", err)
 	}
 
-	usr, err := s.QueryByID(ctx, claims, userID)
-	if err != nil {
+	if uu.Password != nil {
+		if err := s.policy.Check("password", *uu.Password); err != nil {
+			return fmt.Errorf("validating data: %w", err)
+		}
+	}
+
+	f := func(s Store) error {
+		usr, err := s.queryByIDForUpdate(ctx, userID)
+		if err != nil {
+			return err
+		}
+
+		if version != AnyVersion && version != usr.Version {
+			return database.ErrConflict
+		}
+
+		if uu.Name != nil {
+			usr.Name = *uu.Name
+		}
+
+		// A new email has to be verified again.
+		if uu.Email != nil && *uu.Email != usr.Email {
+			usr.Email = *uu.Email
+			usr.EmailVerified = false
+		}
+
+		if uu.Roles != nil {
+			usr.Roles = uu.Roles
+		}
+
+		if uu.Password != nil {
+			hash, err := s.hasher.Hash(*uu.Password)
+			if err != nil {
+				return fmt.Errorf("generating password hash: %w", err)
+			}
+			usr.PasswordHash = hash
+		}
+
+		usr.DateUpdated = now
+		usr.Version++
+
+		const q = `
+		UPDATE
+			users
+		SET
+			"name" = :name,
+			"email" = :email,
+			"email_verified" = :email_verified,
+			"roles" = :roles,
+			"password_hash" = :password_hash,
+			"date_updated" = :date_updated,
+			"version" = :version
+		WHERE
+			user_id = :user_id`
+
+		return database.NamedExecContext(ctx, s.log, s.db, q, usr)
+	}
+
+	if err := s.WithinTran(ctx, f); err != nil {
 		return fmt.Errorf("updating user userID[%s]: %w", userID, err)
 	}
 
-	if uu.Name != nil {
-		usr.Name = *uu.Name
+	return nil
+}
+
+// VerifyEmail marks the email of the user as verified. Nothing is changed
+// when the user's email is no longer the one that was verified.
+func (s Store) VerifyEmail(ctx context.Context, userID string, email string, now time.Time) error {
+	if err := validate.CheckID(userID); err != nil {
+		return database.ErrInvalidID
 	}
 
-	if uu.Email != nil {
-		usr.Email = *uu.Email
+	data := struct {
+		UserID      string    `db:"user_id"`
+		Email       string    `db:"email" log:"redact"`
+		DateUpdated time.Time `db:"date_updated"`
+	}{
+		UserID:      userID,
+		Email:       email,
+		DateUpdated: now,
 	}
 
-	if uu.Roles != nil {
-		usr.Roles = uu.Roles
+	const q = `
+	UPDATE
+		users
+	SET
+		"email_verified" = TRUE,
+		"date_updated" = :date_updated,
+		"version" = version + 1
+	WHERE
+		user_id = :user_id AND
+		email = :email AND
+		date_deleted IS NULL`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, data); err != nil {
+		return fmt.Errorf("verifying email userID[%s]: %w", userID, err)
 	}
 
-	if uu.Password != nil {
-		hash, err := bcrypt.GenerateFromPassword([]byte(*uu.Password), bcrypt.DefaultCost)
+	return nil
+}
+
+// Delete marks the user as deleted. A deleted user is left out of every
+// query and can't authenticate, but it keeps its products and sales and can
+// be restored. Unless version is AnyVersion, ErrConflict is returned when the
+// stored version doesn't match.
+func (s Store) Delete(ctx context.Context, userID string, version int, now time.Time) error {
+	if err := validate.CheckID(userID); err != nil {
+		return database.ErrInvalidID
+	}
+
+	f := func(s Store) error {
+		usr, err := s.queryByIDForUpdate(ctx, userID)
 		if err != nil {
-			return fmt.Errorf("generating password hash: %w", err)
+			return err
+		}
+
+		if version != AnyVersion && version != usr.Version {
+			return database.ErrConflict
+		}
+
+		data := struct {
+			UserID      string    `db:"user_id"`
+			DateDeleted time.Time `db:"date_deleted"`
+		}{
+			UserID:      userID,
+			DateDeleted: now,
 		}
-		usr.PasswordHash = hash
+
+		const q = `
+		UPDATE
+			users
+		SET
+			"date_deleted" = :date_deleted,
+			"date_updated" = :date_deleted,
+			"version" = version + 1
+		WHERE
+			user_id = :user_id`
+
+		return database.NamedExecContext(ctx, s.log, s.db, q, data)
+	}
+
+	if err := s.WithinTran(ctx, f); err != nil {
+		return fmt.Errorf("deleting user userID[%s]: %w", userID, err)
 	}
 
-	usr.DateUpdated = now
+	return nil
+}
+
+// Restore brings back a deleted user.
+func (s Store) Restore(ctx context.Context, userID string, now time.Time) error {
+	if err := validate.CheckID(userID); err != nil {
+		return database.ErrInvalidID
+	}
+
+	data := struct {
+		UserID      string    `db:"user_id"`
+		DateUpdated time.Time `db:"date_updated"`
+	}{
+		UserID:      userID,
+		DateUpdated: now,
+	}
 
 	const q = `
-	UPDATE 
+	UPDATE
 		users
-	SET 
-	    "name" = :name,
-	    "email" = :email,
-	    "roles" = :roles,
-	    "password_hash" = :password_hash,
-	    "date_updated" = :date_updated
-	WHERE 
-	    user_id = :user_id`
+	SET
+		"date_deleted" = NULL,
+		"date_updated" = :date_updated,
+		"version" = version + 1
+	WHERE
+		user_id = :user_id AND
+		date_deleted IS NOT NULL`
 
-	if err := database.NamedExecContext(ctx, s.log, s.db, q, usr); err != nil {
-		return fmt.Errorf("updating user userID[%s]: %w", userID, err)
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, data); err != nil {
+		return fmt.Errorf("restoring user userID[%s]: %w", userID, err)
 	}
 
 	return nil
 }
 
-func (s Store) Delete(ctx context.Context, claims auth.Claims, userID string) error {
+// Purge removes the user from the database for good, along with everything
+// that references it, products and sales included.
+func (s Store) Purge(ctx context.Context, userID string) error {
 	if err := validate.CheckID(userID); err != nil {
 		return database.ErrInvalidID
 	}
 
-	// If you are not an admin and looking to delete someone else then you.
-	if !claims.Authorize(auth.RoleAdmin) && claims.Subject != userID {
-		return database.ErrForbidden
-	}
-
 	data := struct {
 		UserID string `db:"user_id"`
 	}{
@@ -134,57 +339,196 @@ func (s Store) Delete(ctx context.Context, claims auth.Claims, userID string) er
 	}
 
 	const q = `
-		DELETE FROM
-			users
-		WHERE
-		    user_id = :user_id`
+	DELETE FROM
+		users
+	WHERE
+		user_id = :user_id`
 
 	if err := database.NamedExecContext(ctx, s.log, s.db, q, data); err != nil {
-		return fmt.Errorf("deleting user userID[%s]: %w", userID, err)
+		return fmt.Errorf("purging user userID[%s]: %w", userID, err)
 	}
 
 	return nil
 }
 
-func (s Store) Query(ctx context.Context, pageNumber int, rowsPerPage int) ([]User, error) {
-	data := struct {
-		OffSet      int `db:"offset"`
-		RowsPerPage int `db:"row_per_page"`
-	}{
-		OffSet:      (pageNumber - 1) * rowsPerPage,
-		RowsPerPage: rowsPerPage,
+// Query retrieves a page of users matching the filter in the specified order.
+// Pages are located with a keyset cursor so the cost of a page doesn't grow
+// with its position. The returned cursor is empty when there are no more
+// pages.
+func (s Store) Query(ctx context.Context, filter QueryFilter, orderBy order.By, cursorStr string, rowsPerPage int) ([]User, string, error) {
+	if err := validate.Check(filter); err != nil {
+		return nil, "", fmt.Errorf("validating filter: %w", err)
 	}
 
-	const q = `
+	column, exists := OrderByFields[orderBy.Field]
+	if !exists {
+		return nil, "", order.ErrInvalidOrder
+	}
+
+	data := map[string]any{
+		"rows_per_page": rowsPerPage + 1,
+	}
+	wc := applyFilter(filter, data)
+
+	if cursorStr != "" {
+		cur, err := decodeCursor(cursorStr, orderBy)
+		if err != nil {
+			return nil, "", err
+		}
+
+		value, err := cur.value()
+		if err != nil {
+			return nil, "", err
+		}
+		data["cursor_value"] = value
+		data["cursor_user_id"] = cur.UserID
+
+		op := ">"
+		if orderBy.Direction == order.DESC {
+			op = "<"
+		}
+		wc = append(wc, fmt.Sprintf("(%s, user_id) %s (:cursor_value, :cursor_user_id)", column, op))
+	}
+
+	var buf strings.Builder
+	buf.WriteString(`
 	SELECT
 		*
 	FROM
-		users
+		users`)
+	writeWhere(&buf, wc)
+	fmt.Fprintf(&buf, `
 	ORDER BY
-	    user_id
-	OFFSET :offset ROWS FETCH NEXT :row_per_page ROWS ONLY `
+		%[1]s %[2]s, user_id %[2]s
+	FETCH FIRST :rows_per_page ROWS ONLY`, column, orderBy.Direction)
 
 	var users []User
-	if err := database.NamedQuerySlice(ctx, s.log, s.db, q, data, &users); err != nil {
+	if err := database.NamedQuerySlice(ctx, s.log, s.db, buf.String(), data, &users); err != nil {
 		if errors.Is(err, database.ErrNotFound) {
-			return nil, database.ErrNotFound
+			return nil, "", database.ErrNotFound
 		}
+		return nil, "", fmt.Errorf("selecting users: %w", err)
+	}
+
+	// We asked for one more row than the page size to find out if there is a
+	// next page without another round trip.
+	var next string
+	if len(users) > rowsPerPage {
+		users = users[:rowsPerPage]
+		next = newCursor(orderBy, users[len(users)-1]).encode()
+	}
+
+	return users, next, nil
+}
+
+// Count returns the total number of users matching the filter.
+func (s Store) Count(ctx context.Context, filter QueryFilter) (int, error) {
+	if err := validate.Check(filter); err != nil {
+		return 0, fmt.Errorf("validating filter: %w", err)
+	}
+
+	data := map[string]any{}
+	wc := applyFilter(filter, data)
+
+	var buf strings.Builder
+	buf.WriteString(`
+	SELECT
+		count(1)
+	FROM
+		users`)
+	writeWhere(&buf, wc)
 
-		return nil, fmt.Errorf("selecting users: %w", err)
+	var count struct {
+		Count int `db:"count"`
+	}
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, buf.String(), data, &count); err != nil {
+		return 0, fmt.Errorf("counting users: %w", err)
+	}
+
+	return count.Count, nil
+}
+
+// applyFilter adds the named parameters for the filter into data and returns
+// the matching where clauses.
+func applyFilter(filter QueryFilter, data map[string]any) []string {
+	wc := []string{"date_deleted IS NULL"}
+
+	if filter.Name != nil {
+		data["name"] = "%" + *filter.Name + "%"
+		wc = append(wc, "name ILIKE :name")
+	}
+
+	if filter.Email != nil {
+		data["email"] = *filter.Email
+		wc = append(wc, "email = :email")
 	}
 
-	return users, nil
+	if filter.Role != nil {
+		data["role"] = *filter.Role
+		wc = append(wc, ":role = ANY(roles)")
+	}
+
+	if filter.CreatedAfter != nil {
+		data["created_after"] = *filter.CreatedAfter
+		wc = append(wc, "date_created >= :created_after")
+	}
+
+	if filter.CreatedBefore != nil {
+		data["created_before"] = *filter.CreatedBefore
+		wc = append(wc, "date_created < :created_before")
+	}
+
+	return wc
+}
+
+// writeWhere writes the where clauses joined with AND into the query.
+func writeWhere(buf *strings.Builder, wc []string) {
+	if len(wc) == 0 {
+		return
+	}
+
+	buf.WriteString(`
+	WHERE
+		`)
+	buf.WriteString(strings.Join(wc, " AND\n\t\t"))
 }
 
 // QueryByID gets the specified user from the database.
-func (s Store) QueryByID(ctx context.Context, claims auth.Claims, userID string) (User, error) {
+func (s Store) QueryByID(ctx context.Context, userID string) (User, error) {
 	if err := validate.CheckID(userID); err != nil {
 		return User{}, database.ErrInvalidID
 	}
 
-	// If you are not an admin and looking to retrieve someone other than yourself.
-	if !claims.Authorize(auth.RoleAdmin) && claims.Subject != userID {
-		return User{}, database.ErrForbidden
+	data := struct {
+		UserID string `db:"user_id"`
+	}{
+		UserID: userID,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+	    users
+	WHERE
+	    user_id = :user_id AND
+	    date_deleted IS NULL`
+
+	var usr User
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &usr); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return User{}, database.ErrNotFound
+		}
+		return User{}, fmt.Errorf("selecting user userID[%s]: %w", userID, err)
+	}
+
+	return usr, nil
+}
+
+// QueryDeletedByID gets the specified user only when it has been deleted.
+func (s Store) QueryDeletedByID(ctx context.Context, userID string) (User, error) {
+	if err := validate.CheckID(userID); err != nil {
+		return User{}, database.ErrInvalidID
 	}
 
 	data := struct {
@@ -197,9 +541,40 @@ func (s Store) QueryByID(ctx context.Context, claims auth.Claims, userID string)
 	SELECT
 		*
 	FROM
-	    users
+		users
 	WHERE
-	    user_id = :user_id`
+		user_id = :user_id AND
+		date_deleted IS NOT NULL`
+
+	var usr User
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &usr); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return User{}, database.ErrNotFound
+		}
+		return User{}, fmt.Errorf("selecting deleted user userID[%s]: %w", userID, err)
+	}
+
+	return usr, nil
+}
+
+// queryByIDForUpdate gets the specified user and locks the row until the
+// surrounding transaction completes.
+func (s Store) queryByIDForUpdate(ctx context.Context, userID string) (User, error) {
+	data := struct {
+		UserID string `db:"user_id"`
+	}{
+		UserID: userID,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		users
+	WHERE
+		user_id = :user_id AND
+		date_deleted IS NULL
+	FOR UPDATE`
 
 	var usr User
 	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &usr); err != nil {
@@ -213,13 +588,13 @@ func (s Store) QueryByID(ctx context.Context, claims auth.Claims, userID string)
 }
 
 // QueryByEmail gets the specified user from the database by email address.
-func (s Store) QueryByEmail(ctx context.Context, claims auth.Claims, email string) (User, error) {
+func (s Store) QueryByEmail(ctx context.Context, email string) (User, error) {
 	if err := validate.Email(email); err != nil {
 		return User{}, database.ErrInvalidID
 	}
 
 	data := struct {
-		Email string `db:"email"`
+		Email string `db:"email" log:"redact"`
 	}{
 		Email: email,
 	}
@@ -230,18 +605,15 @@ func (s Store) QueryByEmail(ctx context.Context, claims auth.Claims, email strin
 	FROM
 		users
 	WHERE
-		email = :email`
+		email = :email AND
+		date_deleted IS NULL`
 
 	var usr User
 	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &usr); err != nil {
 		if errors.Is(err, database.ErrNotFound) {
 			return User{}, database.ErrNotFound
 		}
-		return User{}, fmt.Errorf("selecting user email[%s]: %w", email, err)
-	}
-
-	if !claims.Authorize(auth.RoleAdmin) && claims.Subject != usr.ID {
-		return User{}, database.ErrForbidden
+		return User{}, fmt.Errorf("selecting user by email: %w", err)
 	}
 
 	return usr, nil
@@ -256,7 +628,7 @@ func (s Store) Authenticate(ctx context.Context, now time.Time, email, password
 	}
 
 	data := struct {
-		Email string `db:"email"`
+		Email string `db:"email" log:"redact"`
 	}{
 		Email: email,
 	}
@@ -267,33 +639,124 @@ func (s Store) Authenticate(ctx context.Context, now time.Time, email, password
 		FROM
 		    users
 		WHERE
-		    email = :email`
+		    email = :email AND
+		    date_deleted IS NULL`
 
 	var usr User
 	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &usr); err != nil {
 		if errors.Is(err, database.ErrNotFound) {
-			return auth.Claims{}, database.ErrNotFound
+
+			// Spend the same time on an unknown email as on a wrong password
+			// so the response doesn't tell which emails have an account.
+			// Hashing costs as much as comparing against a hash.
+			s.hasher.Hash(password)
+			return auth.Claims{}, database.ErrAuthenticationFailed
 		}
-		return auth.Claims{}, fmt.Errorf("selecting user email[%s]: %w", email, err)
+		return auth.Claims{}, fmt.Errorf("selecting user by email: %w", err)
 	}
 
-	// Compare the provided password with the saved hash. Use the subtle.ConstantTimeCompare() function
-	// to avoid a timing attack.
-	if err := bcrypt.CompareHashAndPassword(usr.PasswordHash, []byte(password)); err != nil {
+	// Compare the provided password with the saved hash. The hashers compare
+	// in constant time to avoid a timing attack.
+	if err := s.hasher.Compare(usr.PasswordHash, password); err != nil {
 		return auth.Claims{}, database.ErrAuthenticationFailed
 	}
 
-	// If we are this far the request is valid. Create some claims for the user
-	// and generate their token.
-	claims := auth.Claims{
+	// The password is only known now, so this is the chance to move the
+	// hash to the current algorithm and parameters. Failing to do so
+	// doesn't fail the login, it is tried again next time.
+	if s.hasher.NeedsRehash(usr.PasswordHash) {
+		if err := s.rehash(ctx, usr.ID, usr.PasswordHash, password, now); err != nil {
+			s.log.Warnw("rehash password", "userID", usr.ID, "ERROR", err)
+		}
+	}
+
+	return newClaims(usr, now), nil
+}
+
+// Claims builds a fresh set of claims for the specified user from what is
+// currently stored, so changes to roles are picked up. It is used when a
+// user re-authenticates with something other than a password.
+func (s Store) Claims(ctx context.Context, userID string, now time.Time) (auth.Claims, error) {
+	if err := validate.CheckID(userID); err != nil {
+		return auth.Claims{}, database.ErrInvalidID
+	}
+
+	data := struct {
+		UserID string `db:"user_id"`
+	}{
+		UserID: userID,
+	}
+
+	const q = `
+	SELECT
+		*
+	FROM
+		users
+	WHERE
+		user_id = :user_id AND
+		date_deleted IS NULL`
+
+	var usr User
+	if err := database.NamedQueryStruct(ctx, s.log, s.db, q, data, &usr); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			return auth.Claims{}, database.ErrNotFound
+		}
+		return auth.Claims{}, fmt.Errorf("selecting user userID[%s]: %w", userID, err)
+	}
+
+	return newClaims(usr, now), nil
+}
+
+// newClaims constructs the claims used to generate a token for the user.
+func newClaims(usr User, now time.Time) auth.Claims {
+	return auth.Claims{
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        validate.GenerateID(),
 			Issuer:    "service project",
 			Subject:   usr.ID,
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
 			IssuedAt:  jwt.NewNumericDate(now),
 		},
 		Roles: usr.Roles,
 	}
+}
+
+// rehash replaces the stored hash of the user's password with one made by the
+// current hasher. The hash is only replaced while it is still the one the
+// password was checked against, so a password changed in the meantime isn't
+// put back. The version is left alone since the client didn't change the
+// user, so the entity tags clients hold stay valid.
+func (s Store) rehash(ctx context.Context, userID string, oldHash []byte, pw string, now time.Time) error {
+	hash, err := s.hasher.Hash(pw)
+	if err != nil {
+		return fmt.Errorf("generating password hash: %w", err)
+	}
+
+	data := struct {
+		UserID       string    `db:"user_id"`
+		OldHash      []byte    `db:"old_hash" log:"redact"`
+		PasswordHash []byte    `db:"password_hash" log:"redact"`
+		DateUpdated  time.Time `db:"date_updated"`
+	}{
+		UserID:       userID,
+		OldHash:      oldHash,
+		PasswordHash: hash,
+		DateUpdated:  now,
+	}
 
-	return claims, nil
+	const q = `
+	UPDATE
+		users
+	SET
+		"password_hash" = :password_hash,
+		"date_updated" = :date_updated
+	WHERE
+		user_id = :user_id AND
+		password_hash = :old_hash`
+
+	if err := database.NamedExecContext(ctx, s.log, s.db, q, data); err != nil {
+		return fmt.Errorf("updating password hash userID[%s]: %w", userID, err)
+	}
+
+	return nil
 }
@@ -5,11 +5,13 @@ import (
 	"errors"
 	"github.com/mihailtudos/service3/business/sys/database"
 
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/go-cmp/cmp"
+	"github.com/mihailtudos/service3/business/data/order"
 	"github.com/mihailtudos/service3/business/data/store/user"
 	"github.com/mihailtudos/service3/business/data/tests"
 	"github.com/mihailtudos/service3/business/sys/auth"
+	"github.com/mihailtudos/service3/business/sys/password"
+	"github.com/mihailtudos/service3/business/sys/validate"
 
 	"testing"
 	"time"
@@ -38,8 +40,8 @@ func TestUser(t *testing.T) {
 				Name:            "John Doe",
 				Email:           "johndoe@example.com",
 				Roles:           []string{auth.RoleUser},
-				Password:        "gophers",
-				PasswordConfirm: "gophers",
+				Password:        "gophers1",
+				PasswordConfirm: "gophers1",
 			}
 
 			usr, err := store.Create(ctx, nu, now)
@@ -48,17 +50,7 @@ func TestUser(t *testing.T) {
 			}
 			t.Logf("\t%s\tTest %d:\tShould be able to create a user.", tests.Success, testID)
 
-			claims := auth.Claims{
-				RegisteredClaims: jwt.RegisteredClaims{
-					Subject:   usr.ID,
-					Issuer:    "service project",
-					ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
-					IssuedAt:  jwt.NewNumericDate(time.Now()),
-				},
-				Roles: []string{auth.RoleAdmin},
-			}
-
-			saved, err := store.QueryByID(ctx, claims, usr.ID)
+			saved, err := store.QueryByID(ctx, usr.ID)
 			if err != nil {
 				t.Fatalf("\t%s\tTest %d:\tShould be able to retrieve a user by id : %v", tests.Failed, testID, err)
 			}
@@ -74,22 +66,17 @@ func TestUser(t *testing.T) {
 				Email: tests.StringPointer("johnnydoe@example.com"),
 			}
 
-			claims = auth.Claims{
-				RegisteredClaims: jwt.RegisteredClaims{
-					Subject:   usr.ID,
-					Issuer:    "service project",
-					ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
-					IssuedAt:  jwt.NewNumericDate(time.Now()),
-				},
-				Roles: []string{auth.RoleAdmin},
-			}
-
-			if err := store.Update(ctx, claims, usr.ID, upd, now); err != nil {
+			if err := store.Update(ctx, usr.ID, usr.Version, upd, now); err != nil {
 				t.Fatalf("\t%s\tTest %d:\tShould be able to update a user : %v", tests.Failed, testID, err)
 			}
 			t.Logf("\t%s\tTest %d:\tShould be able to update a user.", tests.Success, testID)
 
-			saved, err = store.QueryByEmail(ctx, claims, *upd.Email)
+			if err := store.Update(ctx, usr.ID, usr.Version, upd, now); !errors.Is(err, database.ErrConflict) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to update a stale version : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to update a stale version.", tests.Success, testID)
+
+			saved, err = store.QueryByEmail(ctx, *upd.Email)
 			if err != nil {
 				t.Fatalf("\t%s\tTest %d:\tShould be able to retrieve a user by id : %v", tests.Failed, testID, err)
 			}
@@ -112,17 +99,194 @@ func TestUser(t *testing.T) {
 			}
 
 			// ========================== DELETE USER ==========================
-			if err := store.Delete(ctx, claims, usr.ID); err != nil {
+			if err := store.Delete(ctx, usr.ID, user.AnyVersion, now); err != nil {
 				t.Fatalf("\t%s\tTest %d:\tShould be able to delete a user : %v", tests.Failed, testID, err)
 			}
 			t.Logf("\t%s\tTest %d:\tShould be able to delete a user.", tests.Success, testID)
 
-			_, err = store.QueryByID(ctx, claims, usr.ID)
+			_, err = store.QueryByID(ctx, usr.ID)
 			if !errors.Is(err, database.ErrNotFound) {
 				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to retrieve a user by id : %v", tests.Failed, testID, err)
 			}
 			t.Logf("\t%s\tTest %d:\tShould NOT be able to retrieve deleted user id.", tests.Success, testID)
+
+			if _, err := store.Authenticate(ctx, now, *upd.Email, "gophers1"); !errors.Is(err, database.ErrAuthenticationFailed) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to authenticate a deleted user : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to authenticate a deleted user.", tests.Success, testID)
+
+			// The email of a deleted user can be used again.
+			nu.Email = *upd.Email
+			other, err := store.Create(ctx, nu, now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to reuse the email of a deleted user : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to reuse the email of a deleted user.", tests.Success, testID)
+
+			// ========================== RESTORE USER =========================
+			if err := store.Restore(ctx, usr.ID, now); !errors.Is(err, database.ErrDuplicate) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to restore a user whose email is taken : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to restore a user whose email is taken.", tests.Success, testID)
+
+			if err := store.Delete(ctx, other.ID, user.AnyVersion, now); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to delete a user : %v", tests.Failed, testID, err)
+			}
+
+			if err := store.Restore(ctx, usr.ID, now); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to restore a user : %v", tests.Failed, testID, err)
+			}
+
+			if _, err := store.QueryByID(ctx, usr.ID); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to retrieve a restored user : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to restore a user.", tests.Success, testID)
+
+			// =========================== PURGE USER ==========================
+			if err := store.Purge(ctx, usr.ID); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to purge a user : %v", tests.Failed, testID, err)
+			}
+
+			if _, err := store.QueryDeletedByID(ctx, usr.ID); !errors.Is(err, database.ErrNotFound) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to retrieve a purged user : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to purge a user.", tests.Success, testID)
+		}
+	}
+
+}
+
+func TestPaging(t *testing.T) {
+	log, db, teardown := tests.NewUnit(t, dbc)
+	t.Cleanup(teardown)
+
+	store := user.NewStore(db, log)
+
+	t.Log("Given the need to page through User records.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen paging through the seeded users by name.", testID)
+		{
+			ctx := context.Background()
+			orderBy := order.NewBy(user.OrderByName, order.DESC)
+
+			total, err := store.Count(ctx, user.QueryFilter{})
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to count users : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to count users.", tests.Success, testID)
+
+			var (
+				names  []string
+				cursor string
+				pages  int
+			)
+			for {
+				users, next, err := store.Query(ctx, user.QueryFilter{}, orderBy, cursor, 3)
+				if err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to retrieve a page of users : %v", tests.Failed, testID, err)
+				}
+				pages++
+
+				for _, usr := range users {
+					names = append(names, usr.Name)
+				}
+
+				if next == "" {
+					break
+				}
+				cursor = next
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to retrieve every page of users.", tests.Success, testID)
+
+			if len(names) != total {
+				t.Logf("\t\tTest %d:\texp: %v", testID, total)
+				t.Logf("\t\tTest %d:\tgot: %v", testID, len(names))
+				t.Fatalf("\t%s\tTest %d:\tShould see every user exactly once.", tests.Failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould see every user exactly once.", tests.Success, testID)
+
+			if exp := (total + 2) / 3; pages != exp {
+				t.Logf("\t\tTest %d:\texp: %v", testID, exp)
+				t.Logf("\t\tTest %d:\tgot: %v", testID, pages)
+				t.Fatalf("\t%s\tTest %d:\tShould get the expected number of pages.", tests.Failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould get the expected number of pages.", tests.Success, testID)
+
+			for i := 1; i < len(names); i++ {
+				if names[i-1] < names[i] {
+					t.Fatalf("\t%s\tTest %d:\tShould get users in descending name order : %v", tests.Failed, testID, names)
+				}
+			}
+			t.Logf("\t%s\tTest %d:\tShould get users in descending name order.", tests.Success, testID)
+
+			other := order.NewBy(user.OrderByEmail, order.ASC)
+			if _, _, err := store.Query(ctx, user.QueryFilter{}, other, cursor, 3); !errors.Is(err, user.ErrInvalidCursor) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT accept a cursor issued for another ordering : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT accept a cursor issued for another ordering.", tests.Success, testID)
 		}
 	}
+}
 
+func TestPasswords(t *testing.T) {
+	log, db, teardown := tests.NewUnit(t, dbc)
+	t.Cleanup(teardown)
+
+	store := user.NewStore(db, log)
+	t.Log("Given the need to enforce a password policy and upgrade password hashes.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen handling the password of a User.", testID)
+		{
+			ctx := context.Background()
+			now := time.Date(2018, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+			nu := user.NewUser{
+				Name:            "Jane Doe",
+				Email:           "janedoe@example.com",
+				Roles:           []string{auth.RoleUser},
+				Password:        "short",
+				PasswordConfirm: "short",
+			}
+
+			var fields validate.FieldErrors
+			if _, err := store.Create(ctx, nu, now); !errors.As(err, &fields) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to create a user with a weak password : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to create a user with a weak password.", tests.Success, testID)
+
+			nu.Password = "gophers1"
+			nu.PasswordConfirm = "gophers1"
+			usr, err := store.Create(ctx, nu, now)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create a user : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to create a user.", tests.Success, testID)
+
+			argon := password.Argon2id{Time: 1, Memory: 1024, Threads: 1, KeyLen: 32, SaltLen: 16}
+			upgraded := store.WithPasswords(argon, password.DefaultPolicy())
+
+			if _, err := upgraded.Authenticate(ctx, now, nu.Email, nu.Password); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould authenticate against the old hash : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould authenticate against the old hash.", tests.Success, testID)
+
+			saved, err := store.QueryByID(ctx, usr.ID)
+			if err != nil || argon.NeedsRehash(saved.PasswordHash) {
+				t.Fatalf("\t%s\tTest %d:\tShould rehash the password with the new hasher : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould rehash the password with the new hasher.", tests.Success, testID)
+
+			if saved.Version != usr.Version {
+				t.Fatalf("\t%s\tTest %d:\tShould keep the version of the user : %d != %d", tests.Failed, testID, saved.Version, usr.Version)
+			}
+			t.Logf("\t%s\tTest %d:\tShould keep the version of the user.", tests.Success, testID)
+
+			if _, err := store.Authenticate(ctx, now, nu.Email, nu.Password); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould still authenticate with the new hash : %v", tests.Failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould still authenticate with the new hash.", tests.Success, testID)
+		}
+	}
 }
@@ -4,11 +4,13 @@ package tests
 import (
 	"bytes"
 	"context"
+	"crypto"
 	"crypto/rand"
 	"crypto/rsa"
 	"fmt"
 	"github.com/jmoiron/sqlx"
 	"github.com/mihailtudos/service3/business/data/schema"
+	"github.com/mihailtudos/service3/business/data/store/token"
 	"github.com/mihailtudos/service3/business/data/store/user"
 	"github.com/mihailtudos/service3/business/sys/auth"
 	"github.com/mihailtudos/service3/business/sys/database"
@@ -117,7 +119,7 @@ func NewIntegration(t *testing.T, dbc DBContainer) *Test {
 		t.Fatalf("generating private key: %v", err)
 	}
 
-	auth, err := auth.New(keyID, keystore.NewMap(map[string]*rsa.PrivateKey{keyID: privateKey}))
+	auth, err := auth.New(keyID, keystore.NewMap(map[string]crypto.Signer{keyID: privateKey}), token.NewStore(db, log))
 	if err != nil {
 		t.Fatalf("constructing auth: %v", err)
 	}
@@ -2,17 +2,59 @@
 package auth
 
 import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rsa"
 	"errors"
 	"fmt"
+	"sort"
+	"time"
+
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
+// ErrRevoked is returned when a token was revoked before it expired.
+var ErrRevoked = errors.New("token has been revoked")
+
+// ErrInvalidToken is returned when a token can't be parsed, isn't signed by
+// one of our keys or has expired.
+var ErrInvalidToken = errors.New("invalid token")
+
 // KeyLookup declares a method set of behaviour for looking up
-// public and private keys for JWT authentication.
+// public and private keys for JWT authentication. RSA, ECDSA and Ed25519
+// keys are supported, the signing algorithm is picked from the key type.
 type KeyLookup interface {
-	PublicKey(kid string) (*rsa.PublicKey, error)
-	PrivateKey(kid string) (*rsa.PrivateKey, error)
+	PublicKey(kid string) (crypto.PublicKey, error)
+	PrivateKey(kid string) (crypto.Signer, error)
+}
+
+// PublicKeyLister declares the behaviour of a KeyLookup that can enumerate
+// its public keys so they can be published to other services.
+type PublicKeyLister interface {
+	PublicKeys() map[string]crypto.PublicKey
+}
+
+// ActiveKeyLookup declares the behaviour of a KeyLookup that decides which
+// key signs tokens, allowing the signing key to be rotated while running.
+type ActiveKeyLookup interface {
+	ActiveKID() string
+}
+
+// RevocationList declares a method set of behaviour for checking whether a
+// token, identified by its jti claim, was revoked before it expired. Tokens
+// of a user that was deleted since they were issued count as revoked too.
+type RevocationList interface {
+	IsRevoked(ctx context.Context, jti string, userID string) (bool, error)
+}
+
+// APIKeyValidator declares a method set of behaviour for authenticating a
+// machine client by its API key and building the claims it acts with.
+type APIKeyValidator interface {
+	ValidateAPIKey(ctx context.Context, key string, now time.Time) (Claims, error)
 }
 
 // Auth is used to authenticate clients. It can generate a token for a
@@ -20,74 +62,186 @@ type KeyLookup interface {
 type Auth struct {
 	activeKID string
 	keyLookup KeyLookup
-	method    jwt.SigningMethod
 	keyFunc   jwt.Keyfunc
-	parser    *jwt.Parser
+	revoked   RevocationList
 }
 
-// New creates an Auth to support authentication/authorization.
-func New(activeKID string, keyLookup KeyLookup) (*Auth, error) {
+// New creates an Auth to support authentication/authorization. The revocation
+// list is optional, when nil tokens are valid until they expire. An empty
+// activeKID constructs an Auth that can only validate tokens, which is what
+// services verifying tokens against a remote key set need.
+func New(activeKID string, keyLookup KeyLookup, revoked RevocationList) (*Auth, error) {
 
 	// The activeKID is the key identifier that is used to sign the tokens.
-	_, err := keyLookup.PrivateKey(activeKID)
-	if err != nil {
-		return nil, errors.New("active KID does not exist in store")
-	}
-
-	method := jwt.GetSigningMethod("RS256")
-	if method == nil {
-		return nil, errors.New("parsing signing method: %w")
+	if activeKID != "" {
+		if _, err := keyLookup.PrivateKey(activeKID); err != nil {
+			return nil, errors.New("active KID does not exist in store")
+		}
 	}
 
+	// The algorithm used to sign the JWT must be validated to avoid a
+	// critical vulnerability, so a token is only accepted when its algorithm
+	// is the one the key it names is used with:
+	// https://auth0.com/blog/critical-vulnerabilities-in-json-web-token-libraries/
 	keyFunc := func(t *jwt.Token) (interface{}, error) {
 		kidID, ok := t.Header["kid"].(string)
 		if !ok {
 			return nil, errors.New("missing key id (kid) in token header")
 		}
-		return keyLookup.PublicKey(kidID)
+
+		publicKey, err := keyLookup.PublicKey(kidID)
+		if err != nil {
+			return nil, err
+		}
+
+		alg, err := Algorithm(publicKey)
+		if err != nil {
+			return nil, err
+		}
+
+		if t.Method.Alg() != alg {
+			return nil, fmt.Errorf("signing method %s does not match the key", t.Method.Alg())
+		}
+
+		return publicKey, nil
 	}
 
-	// Create the token parser to use. The algorithm used to sign the JWT must be
-	// validated to avoid a critical vulnerability:
-	// https://auth0.com/blog/critical-vulnerabilities-in-json-web-token-libraries/
-	parser := jwt.NewParser(jwt.WithValidMethods([]string{"RS256"}))
 	return &Auth{
 		activeKID: activeKID,
 		keyLookup: keyLookup,
-		method:    method,
 		keyFunc:   keyFunc,
-		parser:    parser,
+		revoked:   revoked,
 	}, nil
 }
 
 // GenerateToken generates a signed JWT token string representing the user Claims.
+// Every token is given a unique jti so it can be revoked individually.
+// When the KeyLookup picks the active key, that key is used instead of the
+// one Auth was constructed with.
 func (a *Auth) GenerateToken(claims Claims) (string, error) {
-	token := jwt.NewWithClaims(a.method, claims)
-	token.Header["kid"] = a.activeKID
+	activeKID := a.activeKID
+	if l, ok := a.keyLookup.(ActiveKeyLookup); ok {
+		if kid := l.ActiveKID(); kid != "" {
+			activeKID = kid
+		}
+	}
+
+	if activeKID == "" {
+		return "", errors.New("auth is not configured to sign tokens")
+	}
+
+	if claims.ID == "" {
+		claims.ID = uuid.NewString()
+	}
 
-	privateKey, err := a.keyLookup.PrivateKey(a.activeKID)
+	privateKey, err := a.keyLookup.PrivateKey(activeKID)
 	if err != nil {
-		return "", errors.New("private key lookup failed")
+		return "", fmt.Errorf("private key lookup: %w", err)
 	}
 
+	alg, err := Algorithm(privateKey.Public())
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(alg), claims)
+	token.Header["kid"] = activeKID
+
 	str, err := token.SignedString(privateKey)
 	if err != nil {
-		return "", errors.New("signing token: %w")
+		return "", fmt.Errorf("signing token: %w", err)
 	}
 
 	return str, nil
 }
 
-func (a *Auth) ValidateToken(tokenStr string) (Claims, error) {
+// ValidateToken recreates the Claims that were used to generate a token. It
+// verifies that the token was signed using our key and that it hasn't been
+// revoked.
+func (a *Auth) ValidateToken(ctx context.Context, tokenStr string) (Claims, error) {
+	parser := jwt.NewParser(jwt.WithValidMethods(a.validMethods()))
+
 	var claims Claims
-	token, err := a.parser.ParseWithClaims(tokenStr, &claims, a.keyFunc)
+	token, err := parser.ParseWithClaims(tokenStr, &claims, a.keyFunc)
 	if err != nil {
-		return Claims{}, fmt.Errorf("parsing token: %w", err)
+		return Claims{}, fmt.Errorf("%w: parsing token: %w", ErrInvalidToken, err)
 	}
 
 	if !token.Valid {
-		return Claims{}, errors.New("invalid token")
+		return Claims{}, ErrInvalidToken
+	}
+
+	if a.revoked != nil {
+		revoked, err := a.revoked.IsRevoked(ctx, claims.ID, claims.Subject)
+		if err != nil {
+			return Claims{}, fmt.Errorf("checking revocation: %w", err)
+		}
+		if revoked {
+			return Claims{}, ErrRevoked
+		}
 	}
 
 	return claims, nil
 }
+
+// PublicKeys returns the public keys that tokens can be validated with so
+// they can be published as a key set. It fails when the KeyLookup can't
+// enumerate its keys.
+func (a *Auth) PublicKeys() (map[string]crypto.PublicKey, error) {
+	lister, ok := a.keyLookup.(PublicKeyLister)
+	if !ok {
+		return nil, errors.New("key lookup can't list public keys")
+	}
+
+	return lister.PublicKeys(), nil
+}
+
+// validMethods returns the signing algorithms of the keys tokens can be
+// validated with. The keys can change while running so the list is derived
+// on every call. When the KeyLookup can't enumerate its keys every supported
+// algorithm is allowed, the key function still checks it against the key.
+func (a *Auth) validMethods() []string {
+	lister, ok := a.keyLookup.(PublicKeyLister)
+	if !ok {
+		return []string{"RS256", "ES256", "ES384", "ES512", "EdDSA"}
+	}
+
+	set := make(map[string]struct{})
+	for _, key := range lister.PublicKeys() {
+		if alg, err := Algorithm(key); err == nil {
+			set[alg] = struct{}{}
+		}
+	}
+
+	methods := make([]string, 0, len(set))
+	for alg := range set {
+		methods = append(methods, alg)
+	}
+	sort.Strings(methods)
+
+	return methods
+}
+
+// Algorithm returns the JWT signing algorithm used with the public key.
+func Algorithm(key crypto.PublicKey) (string, error) {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return "RS256", nil
+
+	case *ecdsa.PublicKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return "ES256", nil
+		case elliptic.P384():
+			return "ES384", nil
+		case elliptic.P521():
+			return "ES512", nil
+		}
+		return "", fmt.Errorf("unsupported curve %s", k.Curve.Params().Name)
+
+	case ed25519.PublicKey:
+		return "EdDSA", nil
+	}
+
+	return "", fmt.Errorf("unsupported key type %T", key)
+}
@@ -1,8 +1,14 @@
 package auth_test
 
 import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"errors"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"github.com/mihailtudos/service3/business/sys/auth"
@@ -28,7 +34,7 @@ func TestAuth(t *testing.T) {
 			}
 			t.Logf("\t%s\tTest %d:\tShould be able to create a private key.", success, testID)
 
-			a, err := auth.New(keyID, &keyStore{pk: privateKey})
+			a, err := auth.New(keyID, &keyStore{pk: privateKey}, nil)
 			if err != nil {
 				t.Fatalf("\t%s\tTest %d:\tShould be able to create an auth. %v", failed, testID, err)
 			}
@@ -50,7 +56,7 @@ func TestAuth(t *testing.T) {
 			}
 			t.Logf("\t%s\tTest %d:\tShould be able to generate a JWT.", success, testID)
 
-			parsedClaims, err := a.ValidateToken(token)
+			parsedClaims, err := a.ValidateToken(context.Background(), token)
 			if err != nil {
 				t.Fatalf("\t%s\tTest %d:\tShould be able to validate a token: %v", failed, testID, err)
 			}
@@ -73,16 +79,165 @@ func TestAuth(t *testing.T) {
 	}
 }
 
+func TestRevocation(t *testing.T) {
+	t.Log("Given the need to revoke tokens before they expire.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen validating a revoked token.", testID)
+		{
+			const keyID = "456F21BD-1296-449A-9C2E-85A92092E966"
+			privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create a private key: %v", failed, testID, err)
+			}
+
+			rl := revocationList{}
+			a, err := auth.New(keyID, &keyStore{pk: privateKey}, rl)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create an auth. %v", failed, testID, err)
+			}
+
+			claims := auth.Claims{
+				RegisteredClaims: jwt.RegisteredClaims{
+					Subject:   uuid.NewString(),
+					Issuer:    "service project",
+					ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+					IssuedAt:  jwt.NewNumericDate(time.Now()),
+				},
+				Roles: []string{auth.RoleUser},
+			}
+
+			token, err := a.GenerateToken(claims)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to generate a JWT: %v", failed, testID, err)
+			}
+
+			parsedClaims, err := a.ValidateToken(context.Background(), token)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to validate a token: %v", failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to validate a token.", success, testID)
+
+			if parsedClaims.ID == "" {
+				t.Fatalf("\t%s\tTest %d:\tShould have a jti assigned to the token.", failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould have a jti assigned to the token.", success, testID)
+
+			rl[parsedClaims.ID] = true
+
+			if _, err := a.ValidateToken(context.Background(), token); !errors.Is(err, auth.ErrRevoked) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to validate a revoked token: %v", failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to validate a revoked token.", success, testID)
+		}
+	}
+}
+
+func TestAlgorithms(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating rsa key: %v", err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating ecdsa key: %v", err)
+	}
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generating ed25519 key: %v", err)
+	}
+
+	tt := []struct {
+		name string
+		key  crypto.Signer
+		alg  string
+	}{
+		{name: "RSA", key: rsaKey, alg: "RS256"},
+		{name: "ECDSA", key: ecKey, alg: "ES256"},
+		{name: "Ed25519", key: edKey, alg: "EdDSA"},
+	}
+
+	t.Log("Given the need to sign tokens with different types of keys.")
+	{
+		for testID, tst := range tt {
+			t.Logf("\tTest %d:\tWhen using a %s key.", testID, tst.name)
+			{
+				a, err := auth.New("kid", &keyStore{pk: tst.key}, nil)
+				if err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to create an auth. %v", failed, testID, err)
+				}
+
+				claims := auth.Claims{
+					RegisteredClaims: jwt.RegisteredClaims{
+						Subject:   uuid.NewString(),
+						ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+						IssuedAt:  jwt.NewNumericDate(time.Now()),
+					},
+					Roles: []string{auth.RoleUser},
+				}
+
+				token, err := a.GenerateToken(claims)
+				if err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to generate a JWT: %v", failed, testID, err)
+				}
+
+				parsed, _, err := jwt.NewParser().ParseUnverified(token, &auth.Claims{})
+				if err != nil || parsed.Method.Alg() != tst.alg {
+					t.Fatalf("\t%s\tTest %d:\tShould sign the token with %s : %v", failed, testID, tst.alg, err)
+				}
+				t.Logf("\t%s\tTest %d:\tShould sign the token with %s.", success, testID, tst.alg)
+
+				if _, err := a.ValidateToken(context.Background(), token); err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to validate a token: %v", failed, testID, err)
+				}
+				t.Logf("\t%s\tTest %d:\tShould be able to validate a token.", success, testID)
+			}
+		}
+
+		testID := len(tt)
+		t.Logf("\tTest %d:\tWhen the token names a key of another type.", testID)
+		{
+			signer, err := auth.New("kid", &keyStore{pk: ecKey}, nil)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create an auth. %v", failed, testID, err)
+			}
+
+			token, err := signer.GenerateToken(auth.Claims{Roles: []string{auth.RoleUser}})
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to generate a JWT: %v", failed, testID, err)
+			}
+
+			verifier, err := auth.New("", &keyStore{pk: rsaKey}, nil)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to create an auth. %v", failed, testID, err)
+			}
+
+			if _, err := verifier.ValidateToken(context.Background(), token); !errors.Is(err, auth.ErrInvalidToken) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT be able to validate the token.", failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT be able to validate the token.", success, testID)
+		}
+	}
+}
+
 // =============================================================================
 
+type revocationList map[string]bool
+
+func (rl revocationList) IsRevoked(ctx context.Context, jti string, userID string) (bool, error) {
+	return rl[jti], nil
+}
+
 type keyStore struct {
-	pk *rsa.PrivateKey
+	pk crypto.Signer
 }
 
-func (ks *keyStore) PrivateKey(kid string) (*rsa.PrivateKey, error) {
+func (ks *keyStore) PrivateKey(kid string) (crypto.Signer, error) {
 	return ks.pk, nil
 }
 
-func (ks *keyStore) PublicKey(kid string) (*rsa.PublicKey, error) {
-	return &ks.pk.PublicKey, nil
+func (ks *keyStore) PublicKey(kid string) (crypto.PublicKey, error) {
+	return ks.pk.Public(), nil
 }
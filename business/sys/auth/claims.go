@@ -11,10 +11,12 @@ const (
 	RoleUser  = "USER"
 )
 
-// Claims represents the authorization claims transmitted via a JWT.
+// Claims represents the authorization claims transmitted via a JWT. Scopes
+// are only set for API keys and limit the permissions the roles grant.
 type Claims struct {
 	jwt.RegisteredClaims
-	Roles []string `json:"roles"`
+	Roles  []string `json:"roles"`
+	Scopes []string `json:"scopes,omitempty"`
 }
 
 // Authorize returns true if the claims has at least one of the provided roles.
@@ -0,0 +1,174 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+)
+
+// Permission represents an action that can be performed on a kind of
+// resource, named as "resource:action".
+type Permission string
+
+// Set of permissions known by the application.
+const (
+	PermUsersRead      Permission = "users:read"
+	PermUsersWrite     Permission = "users:write"
+	PermUsersPurge     Permission = "users:purge"
+	PermProductsRead   Permission = "products:read"
+	PermProductsCreate Permission = "products:create"
+	PermProductsWrite  Permission = "products:write"
+	PermSalesRead      Permission = "sales:read"
+	PermSalesCreate    Permission = "sales:create"
+	PermAPIKeysRead    Permission = "apikeys:read"
+	PermAPIKeysWrite   Permission = "apikeys:write"
+	PermAuditRead      Permission = "audit:read"
+)
+
+// permissions is the list of permissions known by the application.
+var permissions = []Permission{
+	PermUsersRead, PermUsersWrite, PermUsersPurge,
+	PermProductsRead, PermProductsCreate, PermProductsWrite,
+	PermSalesRead, PermSalesCreate,
+	PermAPIKeysRead, PermAPIKeysWrite,
+	PermAuditRead,
+}
+
+// ParsePermission returns the permission named by the string.
+func ParsePermission(s string) (Permission, error) {
+	perm := Permission(s)
+	if !slices.Contains(permissions, perm) {
+		return "", fmt.Errorf("unknown permission %q", s)
+	}
+
+	return perm, nil
+}
+
+// PolicyConfig represents the mapping of roles to permissions. Roles grant a
+// permission on every resource. Owner grants a permission only on the
+// resources that belong to the authenticated user.
+type PolicyConfig struct {
+	Roles map[string][]Permission `json:"roles"`
+	Owner []Permission            `json:"owner"`
+}
+
+// DefaultPolicyConfig returns the mapping used when none is configured.
+// Admins can do everything, users can browse, create and buy products and
+// manage what they own.
+func DefaultPolicyConfig() PolicyConfig {
+	return PolicyConfig{
+		Roles: map[string][]Permission{
+			RoleAdmin: {
+				PermUsersRead, PermUsersWrite, PermUsersPurge,
+				PermProductsRead, PermProductsCreate, PermProductsWrite,
+				PermSalesRead, PermSalesCreate,
+				PermAPIKeysRead, PermAPIKeysWrite,
+				PermAuditRead,
+			},
+			RoleUser: {
+				PermProductsRead, PermProductsCreate,
+				PermSalesCreate,
+			},
+		},
+		Owner: []Permission{
+			PermUsersRead, PermUsersWrite,
+			PermProductsWrite,
+			PermSalesRead,
+		},
+	}
+}
+
+// Policy decides which permissions a set of claims holds.
+type Policy struct {
+	roles map[string]map[Permission]bool
+	owner map[Permission]bool
+}
+
+// NewPolicy constructs a Policy from the mapping of roles to permissions.
+func NewPolicy(cfg PolicyConfig) *Policy {
+	p := Policy{
+		roles: make(map[string]map[Permission]bool, len(cfg.Roles)),
+		owner: make(map[Permission]bool, len(cfg.Owner)),
+	}
+
+	for role, perms := range cfg.Roles {
+		p.roles[role] = make(map[Permission]bool, len(perms))
+		for _, perm := range perms {
+			p.roles[role][perm] = true
+		}
+	}
+
+	for _, perm := range cfg.Owner {
+		p.owner[perm] = true
+	}
+
+	return &p
+}
+
+// DefaultPolicy constructs a Policy using the default mapping.
+func DefaultPolicy() *Policy {
+	return NewPolicy(DefaultPolicyConfig())
+}
+
+// ParsePolicy constructs a Policy from a JSON document in the form of a
+// PolicyConfig. Unknown permissions are rejected so a typo fails at startup
+// rather than silently changing what is allowed.
+// Example: {"roles": {"ADMIN": ["users:read"]}, "owner": ["users:read"]}
+func ParsePolicy(r io.Reader) (*Policy, error) {
+	var cfg PolicyConfig
+
+	d := json.NewDecoder(r)
+	d.DisallowUnknownFields()
+	if err := d.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("decoding policy: %w", err)
+	}
+
+	for role, perms := range cfg.Roles {
+		for _, perm := range perms {
+			if _, err := ParsePermission(string(perm)); err != nil {
+				return nil, fmt.Errorf("role %s: %w", role, err)
+			}
+		}
+	}
+
+	for _, perm := range cfg.Owner {
+		if _, err := ParsePermission(string(perm)); err != nil {
+			return nil, fmt.Errorf("owner: %w", err)
+		}
+	}
+
+	return NewPolicy(cfg), nil
+}
+
+// Allowed reports whether one of the roles in the claims grants the
+// permission on every resource. Claims carrying scopes are limited to them.
+func (p *Policy) Allowed(claims Claims, perm Permission) bool {
+	if !inScope(claims, perm) {
+		return false
+	}
+
+	for _, role := range claims.Roles {
+		if p.roles[role][perm] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AllowedOwner reports whether the claims hold the permission on a resource
+// owned by the specified user, either through a role or by owning it.
+func (p *Policy) AllowedOwner(claims Claims, perm Permission, ownerID string) bool {
+	if p.Allowed(claims, perm) {
+		return true
+	}
+
+	return inScope(claims, perm) && claims.Subject != "" && claims.Subject == ownerID && p.owner[perm]
+}
+
+// inScope reports whether the scopes of the claims, if any, include the
+// permission.
+func inScope(claims Claims, perm Permission) bool {
+	return len(claims.Scopes) == 0 || slices.Contains(claims.Scopes, string(perm))
+}
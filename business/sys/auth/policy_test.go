@@ -0,0 +1,96 @@
+package auth_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/mihailtudos/service3/business/sys/auth"
+)
+
+func TestPolicy(t *testing.T) {
+	const doc = `{
+		"roles": {"SUPPORT": ["users:read"]},
+		"owner": ["products:write"]
+	}`
+
+	p, err := auth.ParsePolicy(strings.NewReader(doc))
+	if err != nil {
+		t.Fatalf("parsing policy: %v", err)
+	}
+
+	support := auth.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{Subject: "user1"},
+		Roles:            []string{"SUPPORT"},
+	}
+
+	t.Log("Given the need to authorize actions through a policy.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen a role grants a permission.", testID)
+		{
+			if !p.Allowed(support, auth.PermUsersRead) {
+				t.Fatalf("\t%s\tTest %d:\tShould allow the permission granted by the role.", failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould allow the permission granted by the role.", success, testID)
+
+			if p.Allowed(support, auth.PermUsersWrite) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT allow a permission the role doesn't grant.", failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT allow a permission the role doesn't grant.", success, testID)
+		}
+
+		testID = 1
+		t.Logf("\tTest %d:\tWhen a permission is granted to owners.", testID)
+		{
+			if !p.AllowedOwner(support, auth.PermProductsWrite, "user1") {
+				t.Fatalf("\t%s\tTest %d:\tShould allow the owner of the resource.", failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould allow the owner of the resource.", success, testID)
+
+			if p.AllowedOwner(support, auth.PermProductsWrite, "user2") {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT allow someone else's resource.", failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT allow someone else's resource.", success, testID)
+
+			if p.AllowedOwner(support, auth.PermSalesRead, "user1") {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT allow a permission owners don't get.", failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT allow a permission owners don't get.", success, testID)
+		}
+
+		testID = 2
+		t.Logf("\tTest %d:\tWhen the claims carry scopes.", testID)
+		{
+			scoped := support
+			scoped.Scopes = []string{string(auth.PermProductsWrite)}
+
+			if p.Allowed(scoped, auth.PermUsersRead) {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT allow a permission outside the scopes.", failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT allow a permission outside the scopes.", success, testID)
+
+			if !p.AllowedOwner(scoped, auth.PermProductsWrite, "user1") {
+				t.Fatalf("\t%s\tTest %d:\tShould allow a permission inside the scopes.", failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould allow a permission inside the scopes.", success, testID)
+		}
+
+		testID = 3
+		t.Logf("\tTest %d:\tWhen the policy document is invalid.", testID)
+		{
+			if _, err := auth.ParsePolicy(strings.NewReader(`{"groups": {}}`)); err == nil {
+				t.Fatalf("\t%s\tTest %d:\tShould reject unknown fields.", failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould reject unknown fields.", success, testID)
+
+			if _, err := auth.ParsePolicy(strings.NewReader(`{"roles": {"SUPPORT": ["user:read"]}}`)); err == nil {
+				t.Fatalf("\t%s\tTest %d:\tShould reject unknown permissions.", failed, testID)
+			}
+			if _, err := auth.ParsePolicy(strings.NewReader(`{"owner": ["products:wirte"]}`)); err == nil {
+				t.Fatalf("\t%s\tTest %d:\tShould reject unknown owner permissions.", failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould reject unknown permissions.", success, testID)
+		}
+	}
+}
@@ -3,10 +3,12 @@ package database
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"fmt"
 	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
+	"github.com/mihailtudos/service3/business/sys/validate"
 	"github.com/mihailtudos/service3/foundation/web"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
@@ -14,30 +16,56 @@ import (
 	"net/url"
 	"reflect"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
 // Set of errors for CRUD operations.
 var (
-	ErrNotFound             = errors.New("not found")
-	ErrInvalidID            = errors.New("ID is not in its proper form")
-	ErrForbidden            = errors.New("attempted action is not allowed")
-	ErrAuthenticationFailed = errors.New("authentication failed")
+	ErrNotFound             = validate.NewError(validate.KindNotFound, validate.CodeNotFound, "not found")
+	ErrInvalidID            = validate.NewError(validate.KindInvalid, validate.CodeInvalidID, "ID is not in its proper form")
+	ErrForbidden            = validate.NewError(validate.KindForbidden, validate.CodeForbidden, "attempted action is not allowed")
+	ErrAuthenticationFailed = validate.NewError(validate.KindUnauthenticated, validate.CodeAuthentication, "authentication failed")
+	ErrConflict             = validate.NewError(validate.KindPrecondition, validate.CodeConflict, "record was changed by someone else")
+	ErrDuplicate            = validate.NewError(validate.KindConflict, validate.CodeDuplicate, "duplicate entry")
 )
 
-// Config is the required properties to use the database.
+// uniqueViolation is the postgres error code for a unique constraint
+// violation.
+const uniqueViolation = "23505"
+
+// Transactor interface needed to begin transaction.
+type Transactor interface {
+	BeginTxx(ctx context.Context, opts *sql.TxOptions) (*sqlx.Tx, error)
+}
+
+// Config is the required properties to use the database. Queries taking
+// SlowQueryThreshold or longer are logged at warn level, a zero threshold
+// turns that off. ExplainSlowQueries adds the plan of the query to that log,
+// except in production builds. LogQueries picks how much of the SQL is
+// written to the logs and traces, one of off, statement or full. In full the
+// values of the parameters named in RedactColumns, or bound from fields tagged
+// log:"redact", are replaced.
 type Config struct {
-	User         string
-	Password     string
-	Host         string
-	Name         string
-	MaxIdleConns int
-	MaxOpenConns int
-	DisableTLS   bool
+	User               string
+	Password           string
+	Host               string
+	Name               string
+	MaxIdleConns       int
+	MaxOpenConns       int
+	DisableTLS         bool
+	SlowQueryThreshold time.Duration
+	ExplainSlowQueries bool
+	LogQueries         string
+	RedactColumns      []string
 }
 
 // Open knows how to open a database connection based on the configuration.
 func Open(cfg Config) (*sqlx.DB, error) {
+	if err := logQueries(cfg); err != nil {
+		return nil, err
+	}
+
 	sslmode := "require"
 	if cfg.DisableTLS {
 		sslmode = "disable"
@@ -63,6 +91,8 @@ func Open(cfg Config) (*sqlx.DB, error) {
 	db.SetMaxIdleConns(cfg.MaxIdleConns)
 	db.SetMaxOpenConns(cfg.MaxOpenConns)
 
+	observeQueries(cfg)
+
 	return db, nil
 }
 
@@ -100,17 +130,102 @@ func StatusCheck(ctx context.Context, db *sqlx.DB) error {
 	return db.QueryRowContext(ctx, q).Scan(&tmp)
 }
 
+// WithinTran runs passed function and do commit/rollback at the end. The
+// function receives the transaction as an sqlx.ExtContext so stores can be
+// bound to it and share a single unit of work.
+func WithinTran(ctx context.Context, log *zap.SugaredLogger, db Transactor, fn func(sqlx.ExtContext) error) error {
+	traceID := web.GetTraceID(ctx)
+
+	log.Infow("begin tran", "traceID", traceID)
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tran: %w", err)
+	}
+
+	// We can defer the rollback since the code checks if the transaction
+	// has already been committed.
+	defer func() {
+		if err := tx.Rollback(); err != nil {
+			if errors.Is(err, sql.ErrTxDone) {
+				return
+			}
+			log.Errorw("unable to rollback tran", "traceID", traceID, "ERROR", err)
+			return
+		}
+		log.Infow("rollback tran", "traceID", traceID)
+	}()
+
+	if err := fn(tx); err != nil {
+		return fmt.Errorf("exec tran: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tran: %w", err)
+	}
+	log.Infow("commit tran", "traceID", traceID)
+
+	return nil
+}
+
+// savepoints numbers the savepoints so each one has its own name.
+var savepoints atomic.Uint64
+
+// WithinSavepoint runs the function inside a savepoint of the transaction.
+// When the function fails the transaction is rolled back to the savepoint, so
+// it can go on and still be committed. Every savepoint gets its own name, so
+// nested calls release and roll back to the savepoint they made.
+func WithinSavepoint(ctx context.Context, log *zap.SugaredLogger, tx sqlx.ExtContext, fn func(sqlx.ExtContext) error) error {
+	traceID := web.GetTraceID(ctx)
+	name := fmt.Sprintf("sp_%d", savepoints.Add(1))
+
+	if _, err := tx.ExecContext(ctx, "SAVEPOINT "+name); err != nil {
+		return fmt.Errorf("savepoint: %w", err)
+	}
+
+	if err := fn(tx); err != nil {
+		if _, rerr := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name); rerr != nil {
+			log.Errorw("unable to rollback to savepoint", "traceID", traceID, "ERROR", rerr)
+			return fmt.Errorf("rollback to savepoint: %w", rerr)
+		}
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT "+name); err != nil {
+		return fmt.Errorf("release savepoint: %w", err)
+	}
+
+	return nil
+}
+
 // NamedExecContext is a helper function to execute a CUD operation with
 // logging and tracing where field replacement is necessary.
-func NamedExecContext(ctx context.Context, log *zap.SugaredLogger, db *sqlx.DB, query string, data any) (err error) {
-	q := queryString(query, data)
-	log.Infow("database.NamedExecContext", "traceID", web.GetTraceID(ctx), "query", q)
+func NamedExecContext(ctx context.Context, log *zap.SugaredLogger, db sqlx.ExtContext, query string, data any) (err error) {
+	name := queryName()
+	q := queryText(query, data)
+	if q != "" {
+		log.Infow("database.NamedExecContext", "traceID", web.GetTraceID(ctx), "name", name, "query", q)
+	}
 
 	ctx, span := otel.GetTracerProvider().Tracer("").Start(ctx, "database.NamedExecContext")
-	span.SetAttributes(attribute.String("query", q))
+	if q != "" {
+		span.SetAttributes(attribute.String("query", q))
+	}
 	defer span.End()
 
-	if _, err := db.NamedExecContext(ctx, query, data); err != nil {
+	var rows int64
+	defer func(start time.Time) {
+		observe(ctx, log, db, name, query, data, start, rows, err)
+	}(time.Now())
+
+	result, err := sqlx.NamedExecContext(ctx, db, query, data)
+	if err == nil {
+		rows, _ = result.RowsAffected()
+	}
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+			return fmt.Errorf("%w: %s", ErrDuplicate, pqErr.Constraint)
+		}
 		return err
 	}
 
@@ -119,12 +234,17 @@ func NamedExecContext(ctx context.Context, log *zap.SugaredLogger, db *sqlx.DB,
 
 // NamedQuerySlice is a helper function to execute a query that returns a
 // collection of data to be unmarshalled into a slice.
-func NamedQuerySlice(ctx context.Context, log *zap.SugaredLogger, db *sqlx.DB, query string, data any, dest any) (err error) {
-	q := queryString(query, data)
-	log.Infow("database.NamedQuerySlice", "traceID", web.GetTraceID(ctx), "query", q)
+func NamedQuerySlice(ctx context.Context, log *zap.SugaredLogger, db sqlx.ExtContext, query string, data any, dest any) (err error) {
+	name := queryName()
+	q := queryText(query, data)
+	if q != "" {
+		log.Infow("database.NamedQuerySlice", "traceID", web.GetTraceID(ctx), "name", name, "query", q)
+	}
 
 	ctx, span := otel.GetTracerProvider().Tracer("").Start(ctx, "database.NamedExecContext")
-	span.SetAttributes(attribute.String("query", q))
+	if q != "" {
+		span.SetAttributes(attribute.String("query", q))
+	}
 	defer span.End()
 
 	val := reflect.ValueOf(dest)
@@ -132,7 +252,12 @@ func NamedQuerySlice(ctx context.Context, log *zap.SugaredLogger, db *sqlx.DB, q
 		return errors.New("must provide a pointer to a slice")
 	}
 
-	rows, err := db.NamedQueryContext(ctx, query, data)
+	slice := val.Elem()
+	defer func(start time.Time) {
+		observe(ctx, log, db, name, query, data, start, int64(slice.Len()), err)
+	}(time.Now())
+
+	rows, err := sqlx.NamedQueryContext(ctx, db, query, data)
 	if err != nil {
 		return err
 	}
@@ -143,7 +268,6 @@ func NamedQuerySlice(ctx context.Context, log *zap.SugaredLogger, db *sqlx.DB, q
 		}
 	}()
 
-	slice := val.Elem()
 	for rows.Next() {
 		v := reflect.New(slice.Type().Elem())
 		if err := rows.StructScan(v.Interface()); err != nil {
@@ -156,22 +280,39 @@ func NamedQuerySlice(ctx context.Context, log *zap.SugaredLogger, db *sqlx.DB, q
 
 // NamedQueryStruct is a helper function to execute a query that returns a
 // single data to be unmarshalled into a struct.
-func NamedQueryStruct(ctx context.Context, log *zap.SugaredLogger, db *sqlx.DB, query string, data any, dest any) (err error) {
-	q := queryString(query, data)
-	log.Infow("database.NamedQueryStruct", "traceID", web.GetTraceID(ctx), "query", q)
+func NamedQueryStruct(ctx context.Context, log *zap.SugaredLogger, db sqlx.ExtContext, query string, data any, dest any) (err error) {
+	name := queryName()
+	q := queryText(query, data)
+	if q != "" {
+		log.Infow("database.NamedQueryStruct", "traceID", web.GetTraceID(ctx), "name", name, "query", q)
+	}
 
 	ctx, span := otel.GetTracerProvider().Tracer("").Start(ctx, "database.NamedExecContext")
-	span.SetAttributes(attribute.String("query", q))
+	if q != "" {
+		span.SetAttributes(attribute.String("query", q))
+	}
 	defer span.End()
 
-	rows, err := db.NamedQueryContext(ctx, query, data)
+	var found int64
+	defer func(start time.Time) {
+		observe(ctx, log, db, name, query, data, start, found, err)
+	}(time.Now())
+
+	rows, err := sqlx.NamedQueryContext(ctx, db, query, data)
 	if err != nil {
 		return err
 	}
 
+	defer func() {
+		if err := rows.Close(); err != nil {
+			log.Errorw("database.NamedQueryStruct.rows.Close", "error", err)
+		}
+	}()
+
 	if !rows.Next() {
 		return ErrNotFound
 	}
+	found = 1
 
 	if err := rows.StructScan(dest); err != nil {
 		return err
@@ -181,13 +322,23 @@ func NamedQueryStruct(ctx context.Context, log *zap.SugaredLogger, db *sqlx.DB,
 }
 
 // queryString provides a pretty print version of the query and parameters.
+// Values that must not be logged are redacted, all of them when the
+// parameters can't be matched up with their names.
 func queryString(query string, args any) string {
+	names := paramNames(query)
+	redact := redactedNames(args)
+
 	query, params, err := sqlx.Named(query, args)
 	if err != nil {
 		return err.Error()
 	}
 
-	for _, param := range params {
+	for i, param := range params {
+		if len(names) != len(params) || redact[names[i]] {
+			query = strings.Replace(query, "?", redacted, 1)
+			continue
+		}
+
 		var value string
 		switch v := param.(type) {
 		case string:
@@ -200,8 +351,5 @@ func queryString(query string, args any) string {
 		query = strings.Replace(query, "?", value, 1)
 	}
 
-	query = strings.ReplaceAll(query, "\t", "")
-	query = strings.ReplaceAll(query, "\n", " ")
-
-	return strings.Trim(query, " ")
+	return compact(query)
 }
@@ -0,0 +1,8 @@
+//go:build !production
+
+package database
+
+// explainAvailable allows capturing the plan of slow queries. Production
+// builds leave it out so a configuration mistake can't add the cost of an
+// extra statement to every slow query.
+const explainAvailable = true
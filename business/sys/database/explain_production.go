@@ -0,0 +1,7 @@
+//go:build production
+
+package database
+
+// explainAvailable allows capturing the plan of slow queries, which is left
+// out of production builds.
+const explainAvailable = false
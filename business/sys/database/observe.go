@@ -0,0 +1,123 @@
+package database
+
+import (
+	"context"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mihailtudos/service3/business/sys/metrics"
+	"github.com/mihailtudos/service3/foundation/web"
+	"go.uber.org/zap"
+)
+
+// Set of settings for observing queries, taken from the Config given to
+// Open. They are package level since the query helpers are given the
+// connection or transaction rather than the Config.
+var (
+	slowQuery    atomic.Int64
+	explainSlow  atomic.Bool
+	explainLimit = 5 * time.Second
+)
+
+// observeQueries applies the query settings of the configuration.
+func observeQueries(cfg Config) {
+	slowQuery.Store(int64(cfg.SlowQueryThreshold))
+	explainSlow.Store(cfg.ExplainSlowQueries && explainAvailable)
+}
+
+// queryName names the query after the function that ran it, like
+// user.Store.QueryByID, so queries can be told apart without naming each one.
+func queryName() string {
+	pcs := make([]uintptr, 8)
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	for {
+		frame, more := frames.Next()
+		name := frame.Function
+		if !strings.Contains(name, "/business/sys/database.") {
+			if i := strings.LastIndex(name, "/"); i >= 0 {
+				name = name[i+1:]
+			}
+
+			// Drop the suffixes of closures, like those run in a transaction.
+			for {
+				i := strings.LastIndex(name, ".func")
+				if i < 0 {
+					break
+				}
+				name = name[:i]
+			}
+
+			return name
+		}
+
+		if !more {
+			return "unknown"
+		}
+	}
+}
+
+// observe records how long the query took and how many rows it returned or
+// changed. Slow queries are logged at warn level, along with their plan when
+// explaining slow queries is enabled and the query wasn't part of a
+// transaction.
+func observe(ctx context.Context, log *zap.SugaredLogger, db sqlx.ExtContext, name string, query string, data any, start time.Time, rows int64, err error) {
+	d := time.Since(start)
+	metrics.ObserveQuery(name, d, rows)
+
+	slow := time.Duration(slowQuery.Load())
+	if slow <= 0 || d < slow {
+		return
+	}
+
+	args := []any{
+		"traceID", web.GetTraceID(ctx),
+		"name", name,
+		"duration", d.String(),
+		"rows", rows,
+	}
+	if q := queryText(query, data); q != "" {
+		args = append(args, "query", q)
+	}
+
+	// A failed or cancelled EXPLAIN would abort the caller's transaction,
+	// so queries run in one aren't explained.
+	if _, inTran := db.(*sqlx.Tx); err == nil && !inTran && explainSlow.Load() {
+		plan, err := explain(ctx, db, query, data)
+		if err != nil {
+			args = append(args, "explainError", err)
+		} else {
+			args = append(args, "plan", plan)
+		}
+	}
+
+	log.Warnw("database slow query", args...)
+}
+
+// explain returns the plan of the query. The query isn't run again, plain
+// EXPLAIN only plans it, so this is safe for statements that write.
+func explain(ctx context.Context, db sqlx.ExtContext, query string, data any) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, explainLimit)
+	defer cancel()
+
+	rows, err := sqlx.NamedQueryContext(ctx, db, "EXPLAIN "+query, data)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var lines []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return "", err
+		}
+		lines = append(lines, line)
+	}
+
+	return strings.Join(lines, "\n"), rows.Err()
+}
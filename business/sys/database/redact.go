@@ -0,0 +1,160 @@
+package database
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Set of ways the SQL of a query is written to the logs and traces.
+const (
+	LogQueriesOff       = "off"
+	LogQueriesStatement = "statement"
+	LogQueriesFull      = "full"
+)
+
+// redacted replaces the values that must not be written to logs and traces.
+const redacted = "[REDACTED]"
+
+// queryLogging holds how queries are logged, taken from the Config given to
+// Open.
+type queryLogging struct {
+	mode    string
+	columns map[string]bool
+}
+
+var logging atomic.Pointer[queryLogging]
+
+func init() {
+	logging.Store(&queryLogging{mode: LogQueriesFull})
+}
+
+// logQueries applies the query logging settings of the configuration. An
+// empty mode logs the full query.
+func logQueries(cfg Config) error {
+	mode := cfg.LogQueries
+	switch mode {
+	case "":
+		mode = LogQueriesFull
+	case LogQueriesOff, LogQueriesStatement, LogQueriesFull:
+	default:
+		return fmt.Errorf("invalid log queries value: [%s]", mode)
+	}
+
+	columns := make(map[string]bool)
+	for _, column := range cfg.RedactColumns {
+		columns[strings.ToLower(strings.TrimSpace(column))] = true
+	}
+
+	logging.Store(&queryLogging{mode: mode, columns: columns})
+
+	return nil
+}
+
+// queryText returns the query the way it's written to the logs and traces.
+// It's empty when queries aren't logged.
+func queryText(query string, data any) string {
+	switch logging.Load().mode {
+	case LogQueriesOff:
+		return ""
+	case LogQueriesStatement:
+		return compact(query)
+	default:
+		return queryString(query, data)
+	}
+}
+
+// namedParam matches the named parameters of a query the way sqlx does, "::"
+// being an escaped colon.
+var namedParam = regexp.MustCompile(`::|:[\w.]+`)
+
+// paramNames returns the names of the parameters in the order sqlx binds them.
+func paramNames(query string) []string {
+	var names []string
+	for _, match := range namedParam.FindAllString(query, -1) {
+		if match == "::" {
+			continue
+		}
+		names = append(names, match[1:])
+	}
+
+	return names
+}
+
+// redactedFields caches the names of the fields tagged log:"redact" per type.
+var redactedFields sync.Map
+
+// redactedNames returns the names of the parameters whose values must not be
+// logged: the fields of the data tagged log:"redact" and the configured
+// columns.
+func redactedNames(data any) map[string]bool {
+	names := make(map[string]bool)
+	for column := range logging.Load().columns {
+		names[column] = true
+	}
+
+	t := reflect.TypeOf(data)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return names
+	}
+
+	fields, ok := redactedFields.Load(t)
+	if !ok {
+		fields, _ = redactedFields.LoadOrStore(t, taggedFields(t))
+	}
+	for _, name := range fields.([]string) {
+		names[name] = true
+	}
+
+	return names
+}
+
+// taggedFields returns the names of the fields of the struct type, and of
+// its embedded structs, tagged log:"redact". Names follow sqlx, the db tag
+// or else the lowercased field name.
+func taggedFields(t reflect.Type) []string {
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		name, _, _ := strings.Cut(f.Tag.Get("db"), ",")
+		if name == "-" {
+			continue
+		}
+
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				names = append(names, taggedFields(ft)...)
+				continue
+			}
+		}
+
+		if f.Tag.Get("log") != "redact" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		names = append(names, name)
+	}
+
+	return names
+}
+
+// compact puts the query on a single line.
+func compact(query string) string {
+	query = strings.ReplaceAll(query, "\t", "")
+	query = strings.ReplaceAll(query, "\n", " ")
+
+	return strings.Trim(query, " ")
+}
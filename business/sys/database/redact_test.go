@@ -0,0 +1,97 @@
+package database
+
+import (
+	"testing"
+)
+
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+func TestRedact(t *testing.T) {
+	defer logQueries(Config{})
+
+	type base struct {
+		ID    string `db:"user_id"`
+		Email string `db:"email" log:"redact"`
+	}
+	data := struct {
+		base
+		Hash []byte `db:"password_hash" log:"redact"`
+		Name string `db:"name"`
+	}{
+		base: base{ID: "45b5fbd3", Email: "user@example.com"},
+		Hash: []byte("secret"),
+		Name: "Bill",
+	}
+	const q = `UPDATE users SET email = :email, password_hash = :password_hash, name = :name WHERE user_id = :user_id`
+
+	t.Log("Given the need to keep sensitive values out of query logs and traces.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen logging the full query.", testID)
+		{
+			if err := logQueries(Config{LogQueries: LogQueriesFull}); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to apply the settings : %s.", failed, testID, err)
+			}
+
+			exp := `UPDATE users SET email = [REDACTED], password_hash = [REDACTED], name = 'Bill' WHERE user_id = '45b5fbd3'`
+			if got := queryText(q, data); got != exp {
+				t.Logf("\t\tTest %d:\tGot: %s", testID, got)
+				t.Logf("\t\tTest %d:\tExp: %s", testID, exp)
+				t.Fatalf("\t%s\tTest %d:\tShould redact the tagged fields.", failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould redact the tagged fields.", success, testID)
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tWhen redacting configured columns.", testID)
+		{
+			if err := logQueries(Config{RedactColumns: []string{"Email"}}); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to apply the settings : %s.", failed, testID, err)
+			}
+
+			data := map[string]any{"email": "user@example.com", "role": "ADMIN"}
+			exp := `SELECT * FROM users WHERE email = [REDACTED] AND 'ADMIN' = ANY(roles)`
+			if got := queryText(`SELECT * FROM users WHERE email = :email AND :role = ANY(roles)`, data); got != exp {
+				t.Logf("\t\tTest %d:\tGot: %s", testID, got)
+				t.Logf("\t\tTest %d:\tExp: %s", testID, exp)
+				t.Fatalf("\t%s\tTest %d:\tShould redact the configured columns.", failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould redact the configured columns.", success, testID)
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tWhen logging the statement only.", testID)
+		{
+			if err := logQueries(Config{LogQueries: LogQueriesStatement}); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to apply the settings : %s.", failed, testID, err)
+			}
+
+			if got := queryText(q, data); got != q {
+				t.Logf("\t\tTest %d:\tGot: %s", testID, got)
+				t.Fatalf("\t%s\tTest %d:\tShould log the query without values.", failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould log the query without values.", success, testID)
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tWhen query logging is off.", testID)
+		{
+			if err := logQueries(Config{LogQueries: LogQueriesOff}); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to apply the settings : %s.", failed, testID, err)
+			}
+
+			if got := queryText(q, data); got != "" {
+				t.Fatalf("\t%s\tTest %d:\tShould not log the query : %s.", failed, testID, got)
+			}
+			t.Logf("\t%s\tTest %d:\tShould not log the query.", success, testID)
+
+			if err := logQueries(Config{LogQueries: "verbose"}); err == nil {
+				t.Fatalf("\t%s\tTest %d:\tShould reject an unknown setting.", failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould reject an unknown setting.", success, testID)
+		}
+	}
+}
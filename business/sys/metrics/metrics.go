@@ -4,7 +4,6 @@ package metrics
 import (
 	"context"
 	"expvar"
-	"fmt"
 	"runtime"
 )
 
@@ -21,6 +20,8 @@ type metrics struct {
 	requests   *expvar.Int
 	errors     *expvar.Int
 	panics     *expvar.Int
+	keyEvents  *expvar.Map
+	activeKID  *expvar.String
 }
 
 // init constructs the metrics value that will be used to capture metrics.
@@ -28,12 +29,13 @@ type metrics struct {
 // inside of expvar is registered as a singleton. The use of once will make
 // sure this initialization only happens once.
 func init() {
-	fmt.Println("RUNNN*********************")
 	m = metrics{
 		goroutines: expvar.NewInt("goroutines"),
 		requests:   expvar.NewInt("requests"),
 		errors:     expvar.NewInt("errors"),
 		panics:     expvar.NewInt("panics"),
+		keyEvents:  expvar.NewMap("key_events"),
+		activeKID:  expvar.NewString("active_kid"),
 	}
 }
 
@@ -87,3 +89,14 @@ func AddPanics(ctx context.Context) int64 {
 
 	return 0
 }
+
+// AddKeyEvent increments the count of keystore events of the specified kind.
+// Keys are rotated outside of any request so there is no context to use.
+func AddKeyEvent(kind string) {
+	m.keyEvents.Add(kind, 1)
+}
+
+// SetActiveKID records the key identifier currently used to sign tokens.
+func SetActiveKID(kid string) {
+	m.activeKID.Set(kid)
+}
@@ -0,0 +1,66 @@
+package metrics_test
+
+import (
+	"bytes"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mihailtudos/service3/business/sys/metrics"
+)
+
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+func TestPrometheus(t *testing.T) {
+	metrics.ObserveRequest("GET", "/v1/users/:id", 200, 20*time.Millisecond)
+	metrics.ObserveRequest("GET", "/v1/users/:id", 200, 3*time.Second)
+	metrics.ObserveRequest("GET", "/v1/users/:id", 404, time.Millisecond)
+	metrics.AddInFlight("POST", "/v1/users", 1)
+	metrics.ObserveQuery("user.Store.Query", 2*time.Millisecond, 20)
+	metrics.ObserveQuery("user.Store.Query", 300*time.Millisecond, 5)
+
+	stats := func() sql.DBStats {
+		return sql.DBStats{OpenConnections: 3, InUse: 1, Idle: 2}
+	}
+
+	t.Log("Given the need to expose metrics to Prometheus.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen writing the metrics.", testID)
+		{
+			var buf bytes.Buffer
+			if err := metrics.WritePrometheus(&buf, stats); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to write the metrics : %v", failed, testID, err)
+			}
+			out := buf.String()
+
+			lines := []string{
+				`# TYPE http_requests_total counter`,
+				`http_requests_total{method="GET",route="/v1/users/:id",status="200"} 2`,
+				`http_requests_total{method="GET",route="/v1/users/:id",status="404"} 1`,
+				`http_request_duration_seconds_bucket{method="GET",route="/v1/users/:id",le="0.005"} 1`,
+				`http_request_duration_seconds_bucket{method="GET",route="/v1/users/:id",le="0.025"} 2`,
+				`http_request_duration_seconds_bucket{method="GET",route="/v1/users/:id",le="2.5"} 2`,
+				`http_request_duration_seconds_bucket{method="GET",route="/v1/users/:id",le="+Inf"} 3`,
+				`http_request_duration_seconds_count{method="GET",route="/v1/users/:id"} 3`,
+				`http_requests_in_flight{method="POST",route="/v1/users"} 1`,
+				`db_query_duration_seconds_bucket{query="user.Store.Query",le="0.005"} 1`,
+				`db_query_duration_seconds_bucket{query="user.Store.Query",le="0.5"} 2`,
+				`db_query_duration_seconds_count{query="user.Store.Query"} 2`,
+				`db_query_rows_total{query="user.Store.Query"} 25`,
+				`db_open_connections 3`,
+				`db_in_use_connections 1`,
+			}
+			for _, line := range lines {
+				if !strings.Contains(out, line+"\n") {
+					t.Fatalf("\t%s\tTest %d:\tShould contain %q :\n%s", failed, testID, line, out)
+				}
+			}
+			t.Logf("\t%s\tTest %d:\tShould contain the requests, latencies, in flight requests, queries and pool stats.", success, testID)
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package metrics
+
+import (
+	"bufio"
+	"database/sql"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the buckets of the
+// request latency histogram.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// routeKey identifies the requests made to a route.
+type routeKey struct {
+	method string
+	route  string
+}
+
+// requestKey identifies the requests made to a route that got a status.
+type requestKey struct {
+	routeKey
+	status int
+}
+
+// histogram counts observations per bucket. The last count is for the
+// observations above the largest bucket.
+type histogram struct {
+	counts []uint64
+	sum    float64
+}
+
+// routeMetrics holds the per route metrics. Routes are the patterns requests
+// are matched against, so the number of series stays bounded.
+type routeMetrics struct {
+	mu       sync.Mutex
+	requests map[requestKey]uint64
+	latency  map[routeKey]*histogram
+	inFlight map[routeKey]int64
+}
+
+var rm = routeMetrics{
+	requests: make(map[requestKey]uint64),
+	latency:  make(map[routeKey]*histogram),
+	inFlight: make(map[routeKey]int64),
+}
+
+// AddInFlight adds delta to the number of requests being served for the
+// route.
+func AddInFlight(method string, route string, delta int64) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	rm.inFlight[routeKey{method, route}] += delta
+}
+
+// ObserveRequest records a completed request to the route, the status it got
+// and how long it took.
+func ObserveRequest(method string, route string, status int, d time.Duration) {
+	rk := routeKey{method, route}
+
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	rm.requests[requestKey{rk, status}]++
+
+	h, exists := rm.latency[rk]
+	if !exists {
+		h = &histogram{counts: make([]uint64, len(latencyBuckets)+1)}
+		rm.latency[rk] = h
+	}
+
+	secs := d.Seconds()
+	h.counts[sort.SearchFloat64s(latencyBuckets, secs)]++
+	h.sum += secs
+}
+
+// queryMetrics holds the per query metrics. Queries are named after the
+// function that ran them, so the number of series stays bounded.
+type queryMetrics struct {
+	mu      sync.Mutex
+	latency map[string]*histogram
+	rows    map[string]uint64
+}
+
+var qm = queryMetrics{
+	latency: make(map[string]*histogram),
+	rows:    make(map[string]uint64),
+}
+
+// ObserveQuery records a completed database query, how long it took and how
+// many rows it returned or changed.
+func ObserveQuery(name string, d time.Duration, rows int64) {
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+
+	h, exists := qm.latency[name]
+	if !exists {
+		h = &histogram{counts: make([]uint64, len(latencyBuckets)+1)}
+		qm.latency[name] = h
+	}
+
+	secs := d.Seconds()
+	h.counts[sort.SearchFloat64s(latencyBuckets, secs)]++
+	h.sum += secs
+
+	if rows > 0 {
+		qm.rows[name] += uint64(rows)
+	}
+}
+
+// Handler serves the metrics in the Prometheus text exposition format. The
+// stats of the database pool are read from stats on every scrape.
+func Handler(stats func() sql.DBStats) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WritePrometheus(w, stats)
+	})
+}
+
+// WritePrometheus writes the metrics in the Prometheus text exposition
+// format. stats can be nil when there is no database.
+func WritePrometheus(out io.Writer, stats func() sql.DBStats) error {
+	w := bufio.NewWriter(out)
+
+	writeRoutes(w)
+	writeQueries(w)
+
+	family(w, "go_goroutines", "gauge", "Number of goroutines that currently exist.")
+	sample(w, "go_goroutines", nil, float64(runtime.NumGoroutine()))
+
+	family(w, "panics_total", "counter", "Number of panics recovered while handling requests.")
+	sample(w, "panics_total", nil, float64(m.panics.Value()))
+
+	family(w, "key_events_total", "counter", "Number of keystore events by kind.")
+	m.keyEvents.Do(func(kv expvar.KeyValue) {
+		if v, ok := kv.Value.(*expvar.Int); ok {
+			sample(w, "key_events_total", []string{"kind", kv.Key}, float64(v.Value()))
+		}
+	})
+
+	if stats != nil {
+		writeDBStats(w, stats())
+	}
+
+	return w.Flush()
+}
+
+// writeRoutes writes the per route metrics in a stable order.
+func writeRoutes(w *bufio.Writer) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	family(w, "http_requests_total", "counter", "Number of requests by method, route and status.")
+	reqs := make([]requestKey, 0, len(rm.requests))
+	for k := range rm.requests {
+		reqs = append(reqs, k)
+	}
+	sort.Slice(reqs, func(i, j int) bool {
+		if reqs[i].routeKey != reqs[j].routeKey {
+			return less(reqs[i].routeKey, reqs[j].routeKey)
+		}
+		return reqs[i].status < reqs[j].status
+	})
+	for _, k := range reqs {
+		sample(w, "http_requests_total", []string{"method", k.method, "route", k.route, "status", strconv.Itoa(k.status)}, float64(rm.requests[k]))
+	}
+
+	family(w, "http_request_duration_seconds", "histogram", "Latency of requests by method and route.")
+	for _, k := range sortedRoutes(rm.latency) {
+		h := rm.latency[k]
+
+		var count uint64
+		for i, le := range latencyBuckets {
+			count += h.counts[i]
+			sample(w, "http_request_duration_seconds_bucket", []string{"method", k.method, "route", k.route, "le", formatFloat(le)}, float64(count))
+		}
+		count += h.counts[len(latencyBuckets)]
+		sample(w, "http_request_duration_seconds_bucket", []string{"method", k.method, "route", k.route, "le", "+Inf"}, float64(count))
+		sample(w, "http_request_duration_seconds_sum", []string{"method", k.method, "route", k.route}, h.sum)
+		sample(w, "http_request_duration_seconds_count", []string{"method", k.method, "route", k.route}, float64(count))
+	}
+
+	family(w, "http_requests_in_flight", "gauge", "Number of requests being served by method and route.")
+	for _, k := range sortedRoutes(rm.inFlight) {
+		sample(w, "http_requests_in_flight", []string{"method", k.method, "route", k.route}, float64(rm.inFlight[k]))
+	}
+}
+
+// writeQueries writes the per query metrics in a stable order.
+func writeQueries(w *bufio.Writer) {
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+
+	family(w, "db_query_duration_seconds", "histogram", "Latency of database queries by query name.")
+	for _, name := range sortedNames(qm.latency) {
+		h := qm.latency[name]
+
+		var count uint64
+		for i, le := range latencyBuckets {
+			count += h.counts[i]
+			sample(w, "db_query_duration_seconds_bucket", []string{"query", name, "le", formatFloat(le)}, float64(count))
+		}
+		count += h.counts[len(latencyBuckets)]
+		sample(w, "db_query_duration_seconds_bucket", []string{"query", name, "le", "+Inf"}, float64(count))
+		sample(w, "db_query_duration_seconds_sum", []string{"query", name}, h.sum)
+		sample(w, "db_query_duration_seconds_count", []string{"query", name}, float64(count))
+	}
+
+	family(w, "db_query_rows_total", "counter", "Number of rows returned or changed by query name.")
+	for _, name := range sortedNames(qm.latency) {
+		sample(w, "db_query_rows_total", []string{"query", name}, float64(qm.rows[name]))
+	}
+}
+
+// writeDBStats writes the stats of the database connection pool.
+func writeDBStats(w *bufio.Writer, s sql.DBStats) {
+	gauges := []struct {
+		name  string
+		help  string
+		value float64
+	}{
+		{"db_max_open_connections", "Maximum number of open connections to the database.", float64(s.MaxOpenConnections)},
+		{"db_open_connections", "Number of established connections to the database.", float64(s.OpenConnections)},
+		{"db_in_use_connections", "Number of connections currently in use.", float64(s.InUse)},
+		{"db_idle_connections", "Number of idle connections.", float64(s.Idle)},
+	}
+	for _, g := range gauges {
+		family(w, g.name, "gauge", g.help)
+		sample(w, g.name, nil, g.value)
+	}
+
+	counters := []struct {
+		name  string
+		help  string
+		value float64
+	}{
+		{"db_wait_count_total", "Number of connections waited for.", float64(s.WaitCount)},
+		{"db_wait_duration_seconds_total", "Time blocked waiting for a new connection.", s.WaitDuration.Seconds()},
+		{"db_max_idle_closed_total", "Number of connections closed due to the idle limit.", float64(s.MaxIdleClosed)},
+		{"db_max_idle_time_closed_total", "Number of connections closed due to the idle time limit.", float64(s.MaxIdleTimeClosed)},
+		{"db_max_lifetime_closed_total", "Number of connections closed due to the lifetime limit.", float64(s.MaxLifetimeClosed)},
+	}
+	for _, c := range counters {
+		family(w, c.name, "counter", c.help)
+		sample(w, c.name, nil, c.value)
+	}
+}
+
+// family writes the help and type lines of a metric.
+func family(w *bufio.Writer, name string, kind string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// sample writes a single sample. Labels are given as name, value pairs.
+func sample(w *bufio.Writer, name string, labels []string, value float64) {
+	w.WriteString(name)
+	if len(labels) > 0 {
+		w.WriteByte('{')
+		for i := 0; i < len(labels); i += 2 {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", labels[i], escapeLabel(labels[i+1]))
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+// labelEscaper escapes the characters not allowed as is in label values.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// sortedRoutes returns the keys of the map ordered by route then method.
+func sortedRoutes[V any](m map[routeKey]V) []routeKey {
+	keys := make([]routeKey, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return less(keys[i], keys[j]) })
+
+	return keys
+}
+
+// sortedNames returns the keys of the map in order.
+func sortedNames[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func less(a routeKey, b routeKey) bool {
+	if a.route != b.route {
+		return a.route < b.route
+	}
+	return a.method < b.method
+}
@@ -0,0 +1,173 @@
+// Package password provides support for hashing passwords and checking them
+// against a password policy.
+package password
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrMismatch is returned when a password doesn't match the hash.
+var ErrMismatch = errors.New("password doesn't match")
+
+// Bcrypt hashes passwords with bcrypt at the configured cost.
+type Bcrypt struct {
+	Cost int
+}
+
+// NewBcrypt constructs a Bcrypt hasher at the default cost.
+func NewBcrypt() Bcrypt {
+	return Bcrypt{Cost: bcrypt.DefaultCost}
+}
+
+// Hash returns the bcrypt hash of the password.
+func (b Bcrypt) Hash(password string) ([]byte, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), b.Cost)
+	if err != nil {
+		return nil, fmt.Errorf("generating bcrypt hash: %w", err)
+	}
+
+	return hash, nil
+}
+
+// Compare checks the password against a hash made by any supported
+// algorithm.
+func (b Bcrypt) Compare(hash []byte, password string) error {
+	return Compare(hash, password)
+}
+
+// NeedsRehash reports whether the hash was made by another algorithm or at
+// another cost.
+func (b Bcrypt) NeedsRehash(hash []byte) bool {
+	cost, err := bcrypt.Cost(hash)
+	return err != nil || cost != b.Cost
+}
+
+// =============================================================================
+
+const argon2Prefix = "$argon2id$"
+
+// Argon2id hashes passwords with argon2id using the configured parameters.
+// Memory is in KiB.
+type Argon2id struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+	KeyLen  uint32
+	SaltLen uint32
+}
+
+// NewArgon2id constructs an Argon2id hasher with the second recommended
+// option of RFC 9106: three passes over 64 MiB.
+func NewArgon2id() Argon2id {
+	return Argon2id{
+		Time:    3,
+		Memory:  64 * 1024,
+		Threads: 4,
+		KeyLen:  32,
+		SaltLen: 16,
+	}
+}
+
+// Hash returns the argon2id hash of the password in the PHC string format.
+func (a Argon2id) Hash(password string) ([]byte, error) {
+	salt := make([]byte, a.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("generating salt: %w", err)
+	}
+
+	key := argon2.IDKey([]byte(password), salt, a.Time, a.Memory, a.Threads, a.KeyLen)
+
+	enc := base64.RawStdEncoding
+	hash := fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2Prefix, argon2.Version, a.Memory, a.Time, a.Threads, enc.EncodeToString(salt), enc.EncodeToString(key))
+
+	return []byte(hash), nil
+}
+
+// Compare checks the password against a hash made by any supported
+// algorithm.
+func (a Argon2id) Compare(hash []byte, password string) error {
+	return Compare(hash, password)
+}
+
+// NeedsRehash reports whether the hash was made by another algorithm or with
+// other parameters.
+func (a Argon2id) NeedsRehash(hash []byte) bool {
+	p, _, key, err := parseArgon2id(hash)
+	if err != nil {
+		return true
+	}
+
+	return p.Time != a.Time || p.Memory != a.Memory || p.Threads != a.Threads || uint32(len(key)) != a.KeyLen
+}
+
+// =============================================================================
+
+// Compare checks the password against a bcrypt or argon2id hash. It returns
+// ErrMismatch when the password doesn't match.
+func Compare(hash []byte, password string) error {
+	if !bytes.HasPrefix(hash, []byte(argon2Prefix)) {
+		if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil {
+			if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+				return ErrMismatch
+			}
+			return fmt.Errorf("comparing bcrypt hash: %w", err)
+		}
+		return nil
+	}
+
+	p, salt, key, err := parseArgon2id(hash)
+	if err != nil {
+		return err
+	}
+
+	got := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, uint32(len(key)))
+	if subtle.ConstantTimeCompare(got, key) != 1 {
+		return ErrMismatch
+	}
+
+	return nil
+}
+
+// parseArgon2id reads the parameters, salt and key from an argon2id hash in
+// the PHC string format.
+func parseArgon2id(hash []byte) (Argon2id, []byte, []byte, error) {
+	parts := strings.Split(string(hash), "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return Argon2id{}, nil, nil, errors.New("not an argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return Argon2id{}, nil, nil, fmt.Errorf("unsupported argon2 version %q", parts[2])
+	}
+
+	var p Argon2id
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads); err != nil {
+		return Argon2id{}, nil, nil, fmt.Errorf("parsing argon2 parameters: %w", err)
+	}
+
+	enc := base64.RawStdEncoding
+	salt, err := enc.DecodeString(parts[4])
+	if err != nil {
+		return Argon2id{}, nil, nil, fmt.Errorf("decoding argon2 salt: %w", err)
+	}
+
+	key, err := enc.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return Argon2id{}, nil, nil, errors.New("decoding argon2 key")
+	}
+
+	p.SaltLen = uint32(len(salt))
+	p.KeyLen = uint32(len(key))
+
+	return p, salt, key, nil
+}
@@ -0,0 +1,106 @@
+package password_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/mihailtudos/service3/business/sys/password"
+	"github.com/mihailtudos/service3/business/sys/validate"
+)
+
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+func TestHashers(t *testing.T) {
+	fast := password.Argon2id{Time: 1, Memory: 1024, Threads: 1, KeyLen: 32, SaltLen: 16}
+	hashers := map[string]interface {
+		Hash(string) ([]byte, error)
+		NeedsRehash([]byte) bool
+	}{
+		"bcrypt":   password.Bcrypt{Cost: 4},
+		"argon2id": fast,
+	}
+
+	t.Log("Given the need to hash passwords with different algorithms.")
+	{
+		testID := 0
+		for name, h := range hashers {
+			t.Logf("\tTest %d:\tWhen hashing with %s.", testID, name)
+			{
+				hash, err := h.Hash("gophers1")
+				if err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to hash : %v", failed, testID, err)
+				}
+				t.Logf("\t%s\tTest %d:\tShould be able to hash.", success, testID)
+
+				if err := password.Compare(hash, "gophers1"); err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould match the password : %v", failed, testID, err)
+				}
+				t.Logf("\t%s\tTest %d:\tShould match the password.", success, testID)
+
+				if err := password.Compare(hash, "gophers2"); !errors.Is(err, password.ErrMismatch) {
+					t.Fatalf("\t%s\tTest %d:\tShould NOT match another password : %v", failed, testID, err)
+				}
+				t.Logf("\t%s\tTest %d:\tShould NOT match another password.", success, testID)
+
+				if h.NeedsRehash(hash) {
+					t.Fatalf("\t%s\tTest %d:\tShould NOT need a rehash with the same parameters.", failed, testID)
+				}
+				t.Logf("\t%s\tTest %d:\tShould NOT need a rehash with the same parameters.", success, testID)
+			}
+			testID++
+		}
+
+		t.Logf("\tTest %d:\tWhen the parameters change.", testID)
+		{
+			old, err := password.Bcrypt{Cost: 4}.Hash("gophers1")
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to hash : %v", failed, testID, err)
+			}
+
+			if !(password.Bcrypt{Cost: 5}).NeedsRehash(old) || !fast.NeedsRehash(old) {
+				t.Fatalf("\t%s\tTest %d:\tShould need a rehash.", failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould need a rehash.", success, testID)
+		}
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	p := password.DefaultPolicy()
+	p.RequireDigit = true
+	if err := p.LoadBreached(strings.NewReader("password1\nletmein1\n")); err != nil {
+		t.Fatalf("loading breached passwords: %v", err)
+	}
+
+	tt := []struct {
+		password string
+		errors   int
+	}{
+		{"gophers1", 0},
+		{"gophers", 2},
+		{"gopher1", 1},
+		{"Password1", 1},
+		{strings.Repeat("a1", 40), 1},
+	}
+
+	t.Log("Given the need to enforce a password policy.")
+	{
+		for testID, tc := range tt {
+			t.Logf("\tTest %d:\tWhen checking %q.", testID, tc.password)
+			{
+				err := p.Check("password", tc.password)
+
+				var fields validate.FieldErrors
+				errors.As(err, &fields)
+				if len(fields) != tc.errors {
+					t.Fatalf("\t%s\tTest %d:\tShould break %d rules : %v", failed, testID, tc.errors, err)
+				}
+				t.Logf("\t%s\tTest %d:\tShould break %d rules.", success, testID, tc.errors)
+			}
+		}
+	}
+}
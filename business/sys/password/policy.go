@@ -0,0 +1,111 @@
+package password
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/mihailtudos/service3/business/sys/validate"
+)
+
+// Policy describes the passwords users are allowed to choose.
+type Policy struct {
+	// MinLength is counted in characters.
+	MinLength int
+
+	// MaxLength is counted in bytes, bcrypt ignores anything past 72 bytes.
+	MaxLength int
+
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+
+	breached map[string]struct{}
+}
+
+// DefaultPolicy returns a policy that only limits the length of passwords.
+func DefaultPolicy() Policy {
+	return Policy{
+		MinLength: 8,
+		MaxLength: 72,
+	}
+}
+
+// LoadBreached reads a list of breached passwords, one per line, that can't
+// be chosen. Passwords are matched ignoring case.
+func (p *Policy) LoadBreached(r io.Reader) error {
+	if p.breached == nil {
+		p.breached = make(map[string]struct{})
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if pw := strings.TrimSpace(scanner.Text()); pw != "" {
+			p.breached[strings.ToLower(pw)] = struct{}{}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading breached passwords: %w", err)
+	}
+
+	return nil
+}
+
+// Check validates the password against the policy. Every rule the password
+// breaks is reported as a validate.FieldErrors for the field.
+func (p Policy) Check(field string, password string) error {
+	var fields validate.FieldErrors
+	add := func(msg string) {
+		fields = append(fields, validate.FieldError{Field: field, Error: msg})
+	}
+
+	if p.MinLength > 0 && utf8.RuneCountInString(password) < p.MinLength {
+		add(fmt.Sprintf("must be at least %d characters long", p.MinLength))
+	}
+
+	if p.MaxLength > 0 && len(password) > p.MaxLength {
+		add(fmt.Sprintf("must be at most %d bytes long", p.MaxLength))
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+
+	if p.RequireUpper && !upper {
+		add("must contain an upper case letter")
+	}
+	if p.RequireLower && !lower {
+		add("must contain a lower case letter")
+	}
+	if p.RequireDigit && !digit {
+		add("must contain a digit")
+	}
+	if p.RequireSymbol && !symbol {
+		add("must contain a symbol")
+	}
+
+	if _, ok := p.breached[strings.ToLower(password)]; ok {
+		add("appears in a list of breached passwords")
+	}
+
+	if len(fields) > 0 {
+		return fields
+	}
+
+	return nil
+}
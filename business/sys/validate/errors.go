@@ -3,20 +3,105 @@ package validate
 import (
 	"encoding/json"
 	"errors"
+	"net/http"
+	"strings"
+)
+
+// Set of machine readable codes clients can rely on to tell errors apart.
+// Codes are never changed once published.
+const (
+	CodeValidation           = "validation_failed"
+	CodeInvalidID            = "invalid_id"
+	CodeInvalidEmail         = "invalid_email"
+	CodeNotFound             = "not_found"
+	CodeForbidden            = "forbidden"
+	CodeAuthentication       = "authentication_failed"
+	CodeConflict             = "conflict"
+	CodeDuplicate            = "duplicate"
+	CodeBadRequest           = "bad_request"
+	CodeUnauthorized         = "unauthorized"
+	CodePreconditionFailed   = "precondition_failed"
+	CodePreconditionRequired = "precondition_required"
+	CodeTooManyRequests      = "too_many_requests"
+	CodeInvalidCursor        = "invalid_cursor"
+	CodeInvalidOrder         = "invalid_order"
+	CodeInsufficientStock    = "insufficient_stock"
+	CodeNotDeleted           = "not_deleted"
+	CodeTOTPEnabled          = "totp_enabled"
+	CodeEmailInUse           = "email_in_use"
+	CodeInternal             = "internal"
 )
 
 // ErrInvalidID occures when an ID is not in a valid form.
-var ErrInvalidID = errors.New("ID is not in its proper form")
+var ErrInvalidID = NewError(KindInvalid, CodeInvalidID, "ID is not in its proper form")
 
 // ErrInvalidEmail occures when an email is not in a valid form.
-var ErrInvalidEmail = errors.New("email address is not valid")
+var ErrInvalidEmail = NewError(KindInvalid, CodeInvalidEmail, "email address is not valid")
 
-// ErrorResponse is used when there is a validation error.
+// ErrorResponse is the response for an error in the legacy format, where the
+// field errors are a JSON document inside a string.
 type ErrorResponse = struct {
 	Error  string `json:"error"`
 	Fields string `json:"fields,omitempty"`
 }
 
+// Problem is the response for an error following RFC 7807 problem details.
+// Code is one of the machine readable codes and the type is derived from it.
+type Problem struct {
+	Type    string      `json:"type"`
+	Title   string      `json:"title"`
+	Status  int         `json:"status"`
+	Detail  string      `json:"detail,omitempty"`
+	Code    string      `json:"code"`
+	TraceID string      `json:"trace_id,omitempty"`
+	Fields  FieldErrors `json:"fields,omitempty"`
+}
+
+// NewProblem constructs the problem details for the code and status.
+func NewProblem(code string, status int, detail string, traceID string) Problem {
+	return Problem{
+		Type:    "urn:problem-type:" + code,
+		Title:   http.StatusText(status),
+		Status:  status,
+		Detail:  detail,
+		Code:    code,
+		TraceID: traceID,
+	}
+}
+
+// Code returns the code of the first error in the chain that has one, or
+// else a code matching the status.
+func Code(err error, status int) string {
+	var ce interface{ Code() string }
+	if errors.As(err, &ce) {
+		return ce.Code()
+	}
+
+	switch status {
+	case http.StatusBadRequest:
+		return CodeBadRequest
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusPreconditionFailed:
+		return CodePreconditionFailed
+	case http.StatusPreconditionRequired:
+		return CodePreconditionRequired
+	case http.StatusTooManyRequests:
+		return CodeTooManyRequests
+	}
+
+	if status >= 500 {
+		return CodeInternal
+	}
+	return strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+}
+
 // RequestError is used to pass an error during the request through the
 // application with web specific context.
 type RequestError struct {
@@ -54,17 +139,3 @@ func (fe FieldErrors) Error() string {
 	}
 	return string(d)
 }
-
-// Cause iterates through all the wrapped errors until the root
-// error is reached.
-func Cause(err error) error {
-	root := err
-	for {
-		unwrapped := errors.Unwrap(root)
-		if unwrapped == nil {
-			return root
-		}
-
-		root = unwrapped
-	}
-}
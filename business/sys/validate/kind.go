@@ -0,0 +1,176 @@
+package validate
+
+import (
+	"errors"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// Kind classifies an error by what went wrong, so business packages can say
+// how an error should be treated without knowing about HTTP.
+type Kind int
+
+// Set of kinds of errors.
+const (
+	KindInternal Kind = iota
+	KindInvalid
+	KindUnauthenticated
+	KindForbidden
+	KindNotFound
+	KindConflict
+	KindPrecondition
+)
+
+// Class is how the errors of a kind are reported, the status of the response
+// and the level they are logged at.
+type Class struct {
+	Status int
+	Level  zapcore.Level
+}
+
+// registry maps every kind to its class.
+var registry = struct {
+	mu      sync.RWMutex
+	classes map[Kind]Class
+}{
+	classes: map[Kind]Class{
+		KindInternal:        {http.StatusInternalServerError, zapcore.ErrorLevel},
+		KindInvalid:         {http.StatusBadRequest, zapcore.InfoLevel},
+		KindUnauthenticated: {http.StatusUnauthorized, zapcore.WarnLevel},
+		KindForbidden:       {http.StatusForbidden, zapcore.WarnLevel},
+		KindNotFound:        {http.StatusNotFound, zapcore.InfoLevel},
+		KindConflict:        {http.StatusConflict, zapcore.InfoLevel},
+		KindPrecondition:    {http.StatusPreconditionFailed, zapcore.InfoLevel},
+	},
+}
+
+// Register sets how the errors of the kind are reported.
+func Register(kind Kind, class Class) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	registry.classes[kind] = class
+}
+
+// classOf returns the class of the kind, unknown kinds being internal.
+func classOf(kind Kind) Class {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	if class, exists := registry.classes[kind]; exists {
+		return class
+	}
+	return registry.classes[KindInternal]
+}
+
+// Error is an error of a kind with a machine readable code. Its message is
+// meant to be shown to clients, so it shouldn't carry internal details.
+type Error struct {
+	kind Kind
+	code string
+	msg  string
+}
+
+// NewError constructs an error of the kind with the code and message. Like
+// the errors from errors.New, each call returns a distinct error.
+func NewError(kind Kind, code string, msg string) error {
+	return &Error{kind: kind, code: code, msg: msg}
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	return e.msg
+}
+
+// Kind returns the kind of the error.
+func (e *Error) Kind() Kind {
+	return e.kind
+}
+
+// Code returns the machine readable code of the error.
+func (e *Error) Code() string {
+	return e.code
+}
+
+// Resolution is how an error is reported to the client and logged.
+type Resolution struct {
+	Status  int
+	Code    string
+	Message string
+	Fields  FieldErrors
+	Level   zapcore.Level
+}
+
+// Resolve works out how to report the error, looking through the errors it
+// wraps. A request error gives the status explicitly, field errors are a bad
+// request and kinded errors are reported the way their kind is registered.
+// Anything else is an internal error and its message is kept from the client.
+func Resolve(err error) Resolution {
+	var re *RequestError
+	if errors.As(err, &re) {
+		res := Resolution{
+			Status:  re.Status,
+			Code:    Code(re.Err, re.Status),
+			Message: re.Error(),
+			Level:   levelOf(re.Status),
+		}
+		if fields, ok := re.Fields.(FieldErrors); ok {
+			res.Fields = fields
+		}
+		return res
+	}
+
+	var fields FieldErrors
+	if errors.As(err, &fields) {
+		class := classOf(KindInvalid)
+		return Resolution{
+			Status:  class.Status,
+			Code:    CodeValidation,
+			Message: "data validation error",
+			Fields:  fields,
+			Level:   class.Level,
+		}
+	}
+
+	var ke *Error
+	if errors.As(err, &ke) {
+		class := classOf(ke.kind)
+		return Resolution{
+			Status:  class.Status,
+			Code:    ke.code,
+			Message: ke.msg,
+			Level:   class.Level,
+		}
+	}
+
+	class := classOf(KindInternal)
+	return Resolution{
+		Status: class.Status,
+		Code:   CodeInternal,
+		Level:  class.Level,
+	}
+}
+
+// levelOf returns the level of the registered class with the status, or
+// else error for server errors and info for the rest. When several kinds
+// share the status, the lowest kind decides so the level doesn't change from
+// run to run.
+func levelOf(status int) zapcore.Level {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	for _, kind := range slices.Sorted(maps.Keys(registry.classes)) {
+		if class := registry.classes[kind]; class.Status == status {
+			return class.Level
+		}
+	}
+
+	if status >= http.StatusInternalServerError {
+		return zapcore.ErrorLevel
+	}
+	return zapcore.InfoLevel
+}
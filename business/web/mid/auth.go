@@ -5,28 +5,60 @@ import (
 	"errors"
 	"fmt"
 	"github.com/mihailtudos/service3/business/sys/auth"
+	"github.com/mihailtudos/service3/business/sys/database"
 	"github.com/mihailtudos/service3/business/sys/validate"
 	"github.com/mihailtudos/service3/foundation/web"
 	"net/http"
 	"strings"
 )
 
-// Authenticate validates a JWT from the `Authorization` header.
-func Authenticate(a *auth.Auth) web.Middleware {
+// Authenticate validates a JWT from the `Authorization` header. When an API
+// key validator is provided, machine clients can authenticate with the
+// `ApiKey` scheme instead.
+func Authenticate(a *auth.Auth, keys auth.APIKeyValidator) web.Middleware {
 	m := func(next web.Handler) web.Handler {
 		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
-			// Expecting Authorization: Bearer <token>
+			// Expecting Authorization: Bearer <token> or ApiKey <key>
 			authStr := r.Header.Get("Authorization")
 
 			parts := strings.Split(authStr, " ")
-			if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
+			if len(parts) != 2 {
 				err := errors.New("invalid authorization header format: bearer <token>")
 				return validate.NewRequestError(err, http.StatusUnauthorized)
 			}
 
-			// Validate the token is signed by us.
-			claims, err := a.ValidateToken(parts[1])
-			if err != nil {
+			var claims auth.Claims
+			switch {
+			case strings.ToLower(parts[0]) == "bearer":
+
+				// Validate the token is signed by us. Failing to check
+				// whether it was revoked is our fault, not the caller's.
+				c, err := a.ValidateToken(ctx, parts[1])
+				if err != nil {
+					if errors.Is(err, auth.ErrInvalidToken) || errors.Is(err, auth.ErrRevoked) {
+						return validate.NewRequestError(err, http.StatusUnauthorized)
+					}
+					return fmt.Errorf("validating token: %w", err)
+				}
+				claims = c
+
+			case strings.ToLower(parts[0]) == "apikey" && keys != nil:
+				v, err := web.GetValues(ctx)
+				if err != nil {
+					return web.NewShutdownError("web value missing from context")
+				}
+
+				c, err := keys.ValidateAPIKey(ctx, parts[1], v.Now)
+				if err != nil {
+					if errors.Is(err, database.ErrAuthenticationFailed) {
+						return validate.NewRequestError(err, http.StatusUnauthorized)
+					}
+					return fmt.Errorf("validating api key: %w", err)
+				}
+				claims = c
+
+			default:
+				err := errors.New("invalid authorization header format: bearer <token>")
 				return validate.NewRequestError(err, http.StatusUnauthorized)
 			}
 
@@ -72,3 +104,34 @@ func Authorize(roles ...string) web.Middleware {
 
 	return h
 }
+
+// RequirePermission validates that an authenticated user holds the permission
+// on every resource according to the policy. Rules based on who owns a
+// resource are checked by the core packages once the resource is loaded.
+func RequirePermission(p *auth.Policy, perm auth.Permission) web.Middleware {
+	h := func(next web.Handler) web.Handler {
+		m := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+
+			// If the context is missing this value return failure.
+			claims, err := auth.GetClaims(ctx)
+			if err != nil {
+				return validate.NewRequestError(
+					fmt.Errorf("you are not authorized for that action, no claims"),
+					http.StatusForbidden)
+			}
+
+			if !p.Allowed(claims, perm) {
+				return validate.NewRequestError(
+					fmt.Errorf("you are not authorized for that action claims[%v] permission[%s]", claims.Roles, perm),
+					http.StatusForbidden,
+				)
+			}
+
+			return next(ctx, w, r)
+		}
+
+		return m
+	}
+
+	return h
+}
@@ -6,9 +6,21 @@ import (
 	"github.com/mihailtudos/service3/foundation/web"
 	"go.uber.org/zap"
 	"net/http"
+	"strings"
 )
 
-func Errors(log *zap.SugaredLogger) web.Middleware {
+// contentTypeProblem is the media type of RFC 7807 problem details.
+const contentTypeProblem = "application/problem+json"
+
+// Errors handles errors coming out of the call chain and responds to the
+// client in a uniform way. The status, public message and log level of an
+// error are resolved in one place by validate.Resolve, so handlers can return
+// the errors of the business packages as they are. Errors are sent as problem
+// details, unless legacy is set, in which case they keep the older format for
+// clients that don't ask for problem details in the Accept header. Errors
+// returned once the response has started, like a stream failing part way,
+// are only logged since the status and part of the body are already sent.
+func Errors(log *zap.SugaredLogger, legacy bool) web.Middleware {
 	m := func(next web.Handler) web.Handler {
 		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 			v, err := web.GetValues(ctx)
@@ -17,35 +29,27 @@ func Errors(log *zap.SugaredLogger) web.Middleware {
 			}
 
 			if err := next(ctx, w, r); err != nil {
-				// Log the error.
-				log.Errorw("ERROR", "traceID", v.TraceID, "ERROR", err)
+				// Writing an error now would add it to the end of the body
+				// already sent, so the body just ends early.
+				if v.Started {
+					log.Errorw("ERROR", "traceID", v.TraceID, "status", v.StatusCode, "message", "response already started", "ERROR", err)
 
-				// Build out the error response.
-				var er validate.ErrorResponse
-				var status int
-				switch act := validate.Cause(err).(type) {
-				case validate.FieldErrors:
-					er = validate.ErrorResponse{
-						Error:  "data validation error",
-						Fields: act.Error(),
+					if ok := web.IsShutdown(err); ok {
+						return err
 					}
-					status = http.StatusBadRequest
+					return nil
+				}
 
-				case *validate.RequestError:
-					er = validate.ErrorResponse{
-						Error: act.Error(),
-					}
-					status = act.Status
+				// Work out how to report the error, and log it at the level
+				// that goes with it.
+				res := validate.Resolve(err)
+				log.Logw(res.Level, "ERROR", "traceID", v.TraceID, "status", res.Status, "ERROR", err)
 
-				default:
-					er = validate.ErrorResponse{
-						Error: http.StatusText(http.StatusInternalServerError),
-					}
-					status = http.StatusInternalServerError
-				}
+				pd := validate.NewProblem(res.Code, res.Status, res.Message, v.TraceID)
+				pd.Fields = res.Fields
 
 				// Respond with the error back to the client.
-				if err := web.Respond(ctx, w, er, status); err != nil {
+				if err := respondError(ctx, w, r, pd, legacy); err != nil {
 					return err
 				}
 
@@ -62,3 +66,23 @@ func Errors(log *zap.SugaredLogger) web.Middleware {
 
 	return m
 }
+
+// respondError sends the problem details, or the legacy error response made
+// from them.
+func respondError(ctx context.Context, w http.ResponseWriter, r *http.Request, pd validate.Problem, legacy bool) error {
+	if !legacy || strings.Contains(r.Header.Get("Accept"), contentTypeProblem) {
+		return web.RespondAs(ctx, w, contentTypeProblem, pd, pd.Status)
+	}
+
+	er := validate.ErrorResponse{
+		Error: pd.Detail,
+	}
+	if pd.Detail == "" {
+		er.Error = pd.Title
+	}
+	if pd.Fields != nil {
+		er.Fields = pd.Fields.Error()
+	}
+
+	return web.Respond(ctx, w, er, pd.Status)
+}
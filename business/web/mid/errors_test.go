@@ -0,0 +1,173 @@
+package mid_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/mihailtudos/service3/business/sys/database"
+	"github.com/mihailtudos/service3/business/sys/validate"
+	"github.com/mihailtudos/service3/business/web/mid"
+	"github.com/mihailtudos/service3/foundation/web"
+	"go.uber.org/zap"
+)
+
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+func TestErrors(t *testing.T) {
+	handlers := map[string]web.Handler{
+		"/fields": func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			return validate.FieldErrors{{Field: "email", Error: "email is a required field"}}
+		},
+		"/missing": func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			err := fmt.Errorf("querying user: %w", database.ErrNotFound)
+			return validate.NewRequestError(err, http.StatusNotFound)
+		},
+		"/kinded": func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			err := fmt.Errorf("updating product: %w", database.ErrForbidden)
+			return fmt.Errorf("ID[%s]: %w", "a2b0639f", err)
+		},
+		"/status": func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			return validate.NewRequestError(fmt.Errorf("missing If-Match header"), http.StatusPreconditionRequired)
+		},
+		"/broken": func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			return fmt.Errorf("connection refused")
+		},
+		"/stream": func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			return web.RespondStream(ctx, w, "text/csv", http.StatusOK, func(out io.Writer) error {
+				if _, err := io.WriteString(out, "id,name\n"); err != nil {
+					return err
+				}
+				return fmt.Errorf("exporting users: connection reset")
+			})
+		},
+	}
+
+	newApp := func(legacy bool) *web.App {
+		app := web.NewApp(make(chan os.Signal, 1), mid.Errors(zap.NewNop().Sugar(), legacy))
+		for path, h := range handlers {
+			app.Handle(http.MethodGet, "", path, h)
+		}
+		return app
+	}
+
+	tt := []struct {
+		path   string
+		status int
+		code   string
+		detail string
+		fields int
+	}{
+		{"/fields", http.StatusBadRequest, validate.CodeValidation, "data validation error", 1},
+		{"/missing", http.StatusNotFound, validate.CodeNotFound, "querying user: not found", 0},
+		{"/kinded", http.StatusForbidden, validate.CodeForbidden, "attempted action is not allowed", 0},
+		{"/status", http.StatusPreconditionRequired, validate.CodePreconditionRequired, "missing If-Match header", 0},
+		{"/broken", http.StatusInternalServerError, validate.CodeInternal, "", 0},
+	}
+
+	t.Log("Given the need to report errors as problem details.")
+	{
+		app := newApp(false)
+
+		for testID, tst := range tt {
+			t.Logf("\tTest %d:\tWhen requesting %s.", testID, tst.path)
+			{
+				r := httptest.NewRequest(http.MethodGet, tst.path, nil)
+				w := httptest.NewRecorder()
+				app.ServeHTTP(w, r)
+
+				if w.Code != tst.status {
+					t.Fatalf("\t%s\tTest %d:\tShould receive a status code of %d : %d", failed, testID, tst.status, w.Code)
+				}
+				if ct := w.Header().Get("Content-Type"); ct != "application/problem+json" {
+					t.Fatalf("\t%s\tTest %d:\tShould receive problem details : %s", failed, testID, ct)
+				}
+
+				var pd validate.Problem
+				if err := json.NewDecoder(w.Body).Decode(&pd); err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to decode the response : %s", failed, testID, err)
+				}
+
+				if pd.Status != tst.status || pd.Code != tst.code || pd.Detail != tst.detail || len(pd.Fields) != tst.fields {
+					t.Logf("\t\tTest %d:\tGot: %+v", testID, pd)
+					t.Fatalf("\t%s\tTest %d:\tShould describe the error.", failed, testID)
+				}
+				if pd.Type != "urn:problem-type:"+tst.code || pd.Title != http.StatusText(tst.status) || pd.TraceID == "" {
+					t.Logf("\t\tTest %d:\tGot: %+v", testID, pd)
+					t.Fatalf("\t%s\tTest %d:\tShould have the type, title and trace ID.", failed, testID)
+				}
+				t.Logf("\t%s\tTest %d:\tShould describe the error.", success, testID)
+			}
+		}
+	}
+
+	t.Log("Given the need to stream a response that fails part way.")
+	{
+		app := newApp(false)
+
+		testID := 0
+		t.Logf("\tTest %d:\tWhen the export fails after the first row.", testID)
+		{
+			r := httptest.NewRequest(http.MethodGet, "/stream", nil)
+			w := httptest.NewRecorder()
+			app.ServeHTTP(w, r)
+
+			if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/csv" {
+				t.Fatalf("\t%s\tTest %d:\tShould keep the status and content type : %d %s", failed, testID, w.Code, w.Header().Get("Content-Type"))
+			}
+			t.Logf("\t%s\tTest %d:\tShould keep the status and content type.", success, testID)
+
+			if body := w.Body.String(); body != "id,name\n" {
+				t.Fatalf("\t%s\tTest %d:\tShould end the body without an error : %q", failed, testID, body)
+			}
+			t.Logf("\t%s\tTest %d:\tShould end the body without an error.", success, testID)
+		}
+	}
+
+	t.Log("Given the need to keep the legacy format for existing clients.")
+	{
+		app := newApp(true)
+
+		testID := 0
+		t.Logf("\tTest %d:\tWhen not asking for problem details.", testID)
+		{
+			r := httptest.NewRequest(http.MethodGet, "/fields", nil)
+			w := httptest.NewRecorder()
+			app.ServeHTTP(w, r)
+
+			var er validate.ErrorResponse
+			if err := json.NewDecoder(w.Body).Decode(&er); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to decode the response : %s", failed, testID, err)
+			}
+
+			exp := `[{"field":"email","error":"email is a required field"}]`
+			if w.Code != http.StatusBadRequest || er.Error != "data validation error" || er.Fields != exp {
+				t.Logf("\t\tTest %d:\tGot: %d %+v", testID, w.Code, er)
+				t.Fatalf("\t%s\tTest %d:\tShould receive the legacy format.", failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould receive the legacy format.", success, testID)
+		}
+
+		testID++
+		t.Logf("\tTest %d:\tWhen asking for problem details.", testID)
+		{
+			r := httptest.NewRequest(http.MethodGet, "/missing", nil)
+			r.Header.Set("Accept", "application/problem+json")
+			w := httptest.NewRecorder()
+			app.ServeHTTP(w, r)
+
+			if ct := w.Header().Get("Content-Type"); ct != "application/problem+json" {
+				t.Fatalf("\t%s\tTest %d:\tShould receive problem details : %s", failed, testID, ct)
+			}
+			t.Logf("\t%s\tTest %d:\tShould receive problem details.", success, testID)
+		}
+	}
+}
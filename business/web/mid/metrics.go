@@ -2,29 +2,51 @@ package mid
 
 import (
 	"context"
+	"net/http"
+	"time"
+
 	"github.com/mihailtudos/service3/business/sys/metrics"
 	"github.com/mihailtudos/service3/foundation/web"
-	"net/http"
 )
 
-// Metrics updates program counters.
+// Metrics updates program counters and records the count, status and latency
+// of requests per route. It has to run outside of the Errors middleware to
+// see the status of failed requests.
 func Metrics() web.Middleware {
 	m := func(next web.Handler) web.Handler {
 		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			start := time.Now()
+			route := web.Route(r)
 
 			// Add the metrics into the context for metric gathering.
 			ctx = metrics.Set(ctx)
 
+			metrics.AddInFlight(r.Method, route, 1)
+			defer metrics.AddInFlight(r.Method, route, -1)
+
 			// Call the next handler.
 			err := next(ctx, w, r)
 
+			// An error making it this far is a shutdown error and has been
+			// responded to as a server error.
+			status := http.StatusInternalServerError
+			if v, verr := web.GetValues(ctx); verr == nil && err == nil {
+				status = v.StatusCode
+				start = v.Now
+			}
+			if status == 0 {
+				status = http.StatusOK
+			}
+
+			metrics.ObserveRequest(r.Method, route, status, time.Since(start))
+
 			// Handle updating the metrics that can be updated.
 			n := metrics.AddRequests(ctx)
 			if n%1000 == 0 {
 				metrics.AddGoroutines(ctx)
 			}
 
-			if err != nil {
+			if err != nil || status >= http.StatusBadRequest {
 				metrics.AddErrors(ctx)
 			}
 
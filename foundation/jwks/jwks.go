@@ -0,0 +1,217 @@
+// Package jwks provides support for publishing public keys as a JSON Web Key
+// Set (RFC 7517) and for looking up keys from a remote set.
+package jwks
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+)
+
+// Key represents a single public key in a JSON Web Key Set.
+type Key struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+// Set represents a JSON Web Key Set.
+type Set struct {
+	Keys []Key `json:"keys"`
+}
+
+// NewSet constructs a Set from a map of public keys keyed by key id. The
+// keys are sorted by key id so the document is stable between calls. Keys
+// of an unsupported type are left out.
+func NewSet(keys map[string]crypto.PublicKey) Set {
+	set := Set{
+		Keys: make([]Key, 0, len(keys)),
+	}
+
+	for kid, key := range keys {
+		k, err := NewKey(kid, key)
+		if err != nil {
+			continue
+		}
+		set.Keys = append(set.Keys, k)
+	}
+
+	sort.Slice(set.Keys, func(i, j int) bool {
+		return set.Keys[i].KeyID < set.Keys[j].KeyID
+	})
+
+	return set
+}
+
+// NewKey constructs the JWK form of a public key used for signing. RSA,
+// ECDSA (P-256, P-384 and P-521) and Ed25519 keys are supported.
+func NewKey(kid string, key crypto.PublicKey) (Key, error) {
+	enc := base64.RawURLEncoding
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return Key{
+			KeyType:   "RSA",
+			KeyID:     kid,
+			Use:       "sig",
+			Algorithm: "RS256",
+			N:         enc.EncodeToString(k.N.Bytes()),
+			E:         enc.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+		}, nil
+
+	case *ecdsa.PublicKey:
+		crv, alg, size, err := curveParams(k.Curve)
+		if err != nil {
+			return Key{}, err
+		}
+
+		return Key{
+			KeyType:   "EC",
+			KeyID:     kid,
+			Use:       "sig",
+			Algorithm: alg,
+			Curve:     crv,
+			X:         enc.EncodeToString(k.X.FillBytes(make([]byte, size))),
+			Y:         enc.EncodeToString(k.Y.FillBytes(make([]byte, size))),
+		}, nil
+
+	case ed25519.PublicKey:
+		return Key{
+			KeyType:   "OKP",
+			KeyID:     kid,
+			Use:       "sig",
+			Algorithm: "EdDSA",
+			Curve:     "Ed25519",
+			X:         enc.EncodeToString(k),
+		}, nil
+	}
+
+	return Key{}, fmt.Errorf("unsupported key type %T", key)
+}
+
+// PublicKey converts the JWK back into a public key.
+func (k Key) PublicKey() (crypto.PublicKey, error) {
+	switch k.KeyType {
+	case "RSA":
+		return k.rsaPublicKey()
+	case "EC":
+		return k.ecdsaPublicKey()
+	case "OKP":
+		return k.ed25519PublicKey()
+	}
+
+	return nil, fmt.Errorf("unsupported key type %q", k.KeyType)
+}
+
+func (k Key) rsaPublicKey() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("decoding modulus: %w", err)
+	}
+
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, fmt.Errorf("decoding exponent: %w", err)
+	}
+
+	exp := new(big.Int).SetBytes(e)
+	if len(n) == 0 || !exp.IsInt64() || exp.Int64() < 3 || exp.Int64() > 1<<31-1 {
+		return nil, errors.New("invalid key parameters")
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(exp.Int64()),
+	}, nil
+}
+
+func (k Key) ecdsaPublicKey() (*ecdsa.PublicKey, error) {
+	var (
+		curve elliptic.Curve
+		ec    ecdh.Curve
+	)
+
+	switch k.Curve {
+	case "P-256":
+		curve, ec = elliptic.P256(), ecdh.P256()
+	case "P-384":
+		curve, ec = elliptic.P384(), ecdh.P384()
+	case "P-521":
+		curve, ec = elliptic.P521(), ecdh.P521()
+	default:
+		return nil, fmt.Errorf("unsupported curve %q", k.Curve)
+	}
+
+	x, err := base64.RawURLEncoding.DecodeString(k.X)
+	if err != nil {
+		return nil, fmt.Errorf("decoding x coordinate: %w", err)
+	}
+
+	y, err := base64.RawURLEncoding.DecodeString(k.Y)
+	if err != nil {
+		return nil, fmt.Errorf("decoding y coordinate: %w", err)
+	}
+
+	size := (curve.Params().BitSize + 7) / 8
+	if len(x) != size || len(y) != size {
+		return nil, errors.New("invalid key parameters")
+	}
+
+	// Let ecdh validate that the point is on the curve.
+	point := append(append([]byte{4}, x...), y...)
+	if _, err := ec.NewPublicKey(point); err != nil {
+		return nil, fmt.Errorf("invalid key parameters: %w", err)
+	}
+
+	return &ecdsa.PublicKey{
+		Curve: curve,
+		X:     new(big.Int).SetBytes(x),
+		Y:     new(big.Int).SetBytes(y),
+	}, nil
+}
+
+func (k Key) ed25519PublicKey() (ed25519.PublicKey, error) {
+	if k.Curve != "Ed25519" {
+		return nil, fmt.Errorf("unsupported curve %q", k.Curve)
+	}
+
+	x, err := base64.RawURLEncoding.DecodeString(k.X)
+	if err != nil {
+		return nil, fmt.Errorf("decoding public key: %w", err)
+	}
+
+	if len(x) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid key parameters")
+	}
+
+	return ed25519.PublicKey(x), nil
+}
+
+// curveParams returns the JWK curve name, the signing algorithm and the size
+// of a coordinate in bytes for the curve.
+func curveParams(curve elliptic.Curve) (string, string, int, error) {
+	switch curve {
+	case elliptic.P256():
+		return "P-256", "ES256", 32, nil
+	case elliptic.P384():
+		return "P-384", "ES384", 48, nil
+	case elliptic.P521():
+		return "P-521", "ES512", 66, nil
+	}
+
+	return "", "", 0, errors.New("unsupported curve")
+}
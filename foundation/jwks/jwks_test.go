@@ -0,0 +1,234 @@
+package jwks_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mihailtudos/service3/foundation/jwks"
+)
+
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+func TestRemote(t *testing.T) {
+	key1 := generateKey(t)
+	key2 := generateKey(t)
+
+	var (
+		mu      sync.Mutex
+		keys    = map[string]crypto.PublicKey{"kid1": &key1.PublicKey}
+		fail    atomic.Bool
+		fetches atomic.Int32
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		if fail.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		json.NewEncoder(w).Encode(jwks.NewSet(keys))
+	}))
+	t.Cleanup(srv.Close)
+
+	remote := jwks.NewRemote(jwks.Config{
+		URL:                srv.URL,
+		TTL:                time.Hour,
+		MinRefreshInterval: 50 * time.Millisecond,
+		MaxBackoff:         time.Hour,
+	})
+
+	t.Log("Given the need to look up keys from a remote key set.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen looking up a known key.", testID)
+		{
+			pk, err := remote.PublicKey("kid1")
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to look up the key : %v", failed, testID, err)
+			}
+			if !key1.PublicKey.Equal(pk) {
+				t.Fatalf("\t%s\tTest %d:\tShould get back the published key.", failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould get back the published key.", success, testID)
+
+			if _, err := remote.PublicKey("kid1"); err != nil || fetches.Load() != 1 {
+				t.Fatalf("\t%s\tTest %d:\tShould serve the key from the cache : %v fetches[%d]", failed, testID, err, fetches.Load())
+			}
+			t.Logf("\t%s\tTest %d:\tShould serve the key from the cache.", success, testID)
+		}
+
+		testID = 1
+		t.Logf("\tTest %d:\tWhen the remote service adds a key.", testID)
+		{
+			mu.Lock()
+			keys["kid2"] = &key2.PublicKey
+			mu.Unlock()
+
+			time.Sleep(60 * time.Millisecond)
+
+			pk, err := remote.PublicKey("kid2")
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould refresh the set on an unknown kid : %v", failed, testID, err)
+			}
+			if !key2.PublicKey.Equal(pk) {
+				t.Fatalf("\t%s\tTest %d:\tShould get back the new key.", failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould refresh the set on an unknown kid.", success, testID)
+		}
+
+		testID = 2
+		t.Logf("\tTest %d:\tWhen the remote service is failing.", testID)
+		{
+			fail.Store(true)
+			time.Sleep(60 * time.Millisecond)
+
+			if _, err := remote.PublicKey("kid3"); err == nil {
+				t.Fatalf("\t%s\tTest %d:\tShould fail to look up an unknown key.", failed, testID)
+			}
+			before := fetches.Load()
+
+			if _, err := remote.PublicKey("kid3"); err == nil {
+				t.Fatalf("\t%s\tTest %d:\tShould fail to look up an unknown key.", failed, testID)
+			}
+			if fetches.Load() != before {
+				t.Fatalf("\t%s\tTest %d:\tShould back off after a failed fetch.", failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould back off after a failed fetch.", success, testID)
+
+			if _, err := remote.PublicKey("kid1"); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould keep serving known keys : %v", failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould keep serving known keys.", success, testID)
+		}
+	}
+}
+
+func TestRemoteSlowFetch(t *testing.T) {
+	key := generateKey(t)
+	release := make(chan struct{})
+
+	var fetches atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fetches.Add(1) > 1 {
+			<-release
+		}
+		json.NewEncoder(w).Encode(jwks.NewSet(map[string]crypto.PublicKey{"kid1": &key.PublicKey}))
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(release) })
+
+	remote := jwks.NewRemote(jwks.Config{
+		URL:                srv.URL,
+		TTL:                20 * time.Millisecond,
+		MinRefreshInterval: 10 * time.Millisecond,
+	})
+
+	t.Log("Given the need to look up keys while the remote set is slow to fetch.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen the cached set has expired.", testID)
+		{
+			if _, err := remote.PublicKey("kid1"); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to look up the key : %v", failed, testID, err)
+			}
+			time.Sleep(30 * time.Millisecond)
+
+			start := time.Now()
+			var wg sync.WaitGroup
+			for range 10 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if _, err := remote.PublicKey("kid1"); err != nil {
+						t.Errorf("\t%s\tTest %d:\tShould be able to look up the key : %v", failed, testID, err)
+					}
+				}()
+			}
+			wg.Wait()
+
+			if d := time.Since(start); d > time.Second {
+				t.Fatalf("\t%s\tTest %d:\tShould serve the cached key while fetching : took %v", failed, testID, d)
+			}
+			t.Logf("\t%s\tTest %d:\tShould serve the cached key while fetching.", success, testID)
+
+			// the fetch runs in the background, so give it time to reach the
+			// remote service.
+			for i := 0; i < 100 && fetches.Load() < 2; i++ {
+				time.Sleep(10 * time.Millisecond)
+			}
+			if n := fetches.Load(); n != 2 {
+				t.Fatalf("\t%s\tTest %d:\tShould fetch the set once at a time : fetches[%d]", failed, testID, n)
+			}
+			t.Logf("\t%s\tTest %d:\tShould fetch the set once at a time.", success, testID)
+		}
+	}
+}
+
+func TestKeyTypes(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating ecdsa key: %v", err)
+	}
+
+	edKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generating ed25519 key: %v", err)
+	}
+
+	keys := map[string]crypto.PublicKey{
+		"rsa": &generateKey(t).PublicKey,
+		"ec":  &ecKey.PublicKey,
+		"ed":  edKey,
+	}
+
+	t.Log("Given the need to publish keys of different types.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen converting keys to and from a key set.", testID)
+		{
+			set := jwks.NewSet(keys)
+			if len(set.Keys) != len(keys) {
+				t.Fatalf("\t%s\tTest %d:\tShould publish every key : got %d", failed, testID, len(set.Keys))
+			}
+			t.Logf("\t%s\tTest %d:\tShould publish every key.", success, testID)
+
+			for _, k := range set.Keys {
+				pk, err := k.PublicKey()
+				if err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould be able to decode the %s key : %v", failed, testID, k.KeyID, err)
+				}
+
+				exp := keys[k.KeyID].(interface{ Equal(crypto.PublicKey) bool })
+				if !exp.Equal(pk) {
+					t.Fatalf("\t%s\tTest %d:\tShould get back the %s key.", failed, testID, k.KeyID)
+				}
+			}
+			t.Logf("\t%s\tTest %d:\tShould get back every key.", success, testID)
+		}
+	}
+}
+
+func generateKey(t *testing.T) *rsa.PrivateKey {
+	pk, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	return pk
+}
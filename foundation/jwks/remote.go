@@ -0,0 +1,221 @@
+package jwks
+
+import (
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrKeyNotFound is returned when the remote set has no key for the key id.
+var ErrKeyNotFound = errors.New("key not found")
+
+// Config represents the settings for looking up keys from a remote set.
+type Config struct {
+	// URL of the JSON Web Key Set document.
+	URL string
+
+	// Client used to fetch the document. Defaults to a client with a 5
+	// second timeout.
+	Client *http.Client
+
+	// TTL is how long a fetched set is trusted before it is fetched again.
+	// Defaults to 5 minutes.
+	TTL time.Duration
+
+	// MinRefreshInterval limits how often an unknown key id can force the
+	// set to be fetched again. Defaults to 10 seconds.
+	MinRefreshInterval time.Duration
+
+	// MaxBackoff caps the delay between attempts after failed fetches.
+	// Defaults to 5 minutes.
+	MaxBackoff time.Duration
+}
+
+// Remote implements the auth.KeyLookup interface using a JSON Web Key Set
+// served by another service. It only knows public keys so it can be used to
+// validate tokens but never to sign them.
+type Remote struct {
+	cfg Config
+	now func() time.Time
+
+	mu          sync.Mutex
+	keys        map[string]crypto.PublicKey
+	fetched     time.Time
+	nextAttempt time.Time
+	failures    int
+	inflight    *flight
+}
+
+// flight is a fetch of the set in progress. Lookups that need its outcome
+// wait for done to be closed, after which err holds the result.
+type flight struct {
+	done chan struct{}
+	err  error
+}
+
+// NewRemote constructs a Remote for the configured set. Keys are fetched
+// lazily on the first lookup.
+func NewRemote(cfg Config) *Remote {
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 5 * time.Second}
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = 5 * time.Minute
+	}
+	if cfg.MinRefreshInterval <= 0 {
+		cfg.MinRefreshInterval = 10 * time.Second
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = 5 * time.Minute
+	}
+
+	return &Remote{
+		cfg: cfg,
+		now: time.Now,
+	}
+}
+
+// PrivateKey always fails since a remote set only carries public keys.
+func (r *Remote) PrivateKey(kid string) (crypto.Signer, error) {
+	return nil, errors.New("private keys are not available from a remote key set")
+}
+
+// PublicKey returns the public key for the key id. The set is fetched again
+// when the cached copy is older than the TTL or when the key id is unknown,
+// which is how keys added by the remote service are picked up. Fetches are
+// rate limited and back off after failures, in which case the last known
+// copy of the set keeps being used.
+//
+// Only one fetch runs at a time and it runs without holding the lock. Keys
+// already known are served from the cache while it runs, and only lookups
+// of unknown keys wait for it.
+func (r *Remote) PublicKey(kid string) (crypto.PublicKey, error) {
+	r.mu.Lock()
+
+	now := r.now()
+	key, exists := r.keys[kid]
+	fresh := !r.fetched.IsZero() && now.Sub(r.fetched) < r.cfg.TTL
+
+	if exists && fresh {
+		r.mu.Unlock()
+		return key, nil
+	}
+
+	f := r.inflight
+	if f == nil {
+		if now.Before(r.nextAttempt) {
+			r.mu.Unlock()
+			if exists {
+				return key, nil
+			}
+			return nil, ErrKeyNotFound
+		}
+
+		f = &flight{done: make(chan struct{})}
+		r.inflight = f
+		go r.refresh(f)
+	}
+
+	r.mu.Unlock()
+
+	if exists {
+		return key, nil
+	}
+
+	<-f.done
+	if f.err != nil {
+		return nil, fmt.Errorf("refreshing key set: %w", f.err)
+	}
+
+	r.mu.Lock()
+	key, exists = r.keys[kid]
+	r.mu.Unlock()
+
+	if !exists {
+		return nil, ErrKeyNotFound
+	}
+
+	return key, nil
+}
+
+// refresh fetches the set and replaces the cached keys, then reports the
+// outcome to the lookups waiting on the flight. The fetch is done without
+// holding the lock.
+func (r *Remote) refresh(f *flight) {
+	keys, err := r.fetch()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	defer close(f.done)
+
+	now := r.now()
+	r.inflight = nil
+	f.err = err
+
+	if err != nil {
+		r.failures++
+		r.nextAttempt = now.Add(r.backoff())
+		return
+	}
+
+	r.keys = keys
+	r.fetched = now
+	r.failures = 0
+	r.nextAttempt = now.Add(r.cfg.MinRefreshInterval)
+}
+
+// backoff returns the delay before the next attempt, doubling with every
+// consecutive failure.
+func (r *Remote) backoff() time.Duration {
+	d := r.cfg.MinRefreshInterval
+	for i := 1; i < r.failures && d < r.cfg.MaxBackoff; i++ {
+		d *= 2
+	}
+
+	if d > r.cfg.MaxBackoff {
+		d = r.cfg.MaxBackoff
+	}
+
+	return d
+}
+
+// fetch retrieves and decodes the set. Keys that can't be decoded are
+// skipped so one bad key doesn't take down the others.
+func (r *Remote) fetch() (map[string]crypto.PublicKey, error) {
+	resp, err := r.cfg.Client.Get(r.cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("fetching key set: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching key set: unexpected status %d", resp.StatusCode)
+	}
+
+	// limit the document to 1 megabyte, which is far more than any
+	// reasonable key set needs.
+	var set Set
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1024*1024)).Decode(&set); err != nil {
+		return nil, fmt.Errorf("decoding key set: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		pk, err := k.PublicKey()
+		if err != nil {
+			continue
+		}
+		keys[k.KeyID] = pk
+	}
+
+	return keys, nil
+}
@@ -3,33 +3,47 @@
 package keystore
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
 	"errors"
 	"fmt"
-	"github.com/golang-jwt/jwt/v5"
 	"io"
 	"io/fs"
 	"path"
 	"strings"
 	"sync"
+	"time"
 )
 
+// KeyStore represents an in memory store implementation of the
+// KeyLookup interface for use with the auth package.
 type KeyStore struct {
-	mu   sync.RWMutex
-	keys map[string]*rsa.PrivateKey
+	mu      sync.RWMutex
+	keys    map[string]crypto.Signer
+	retired map[string]time.Time
+
+	active   string
+	next     string
+	switchAt time.Time
 }
 
 // NewKeyStore creates an empty - zero value - KeyStore.
 func NewKeyStore() *KeyStore {
 	return &KeyStore{
-		keys: make(map[string]*rsa.PrivateKey),
+		keys:    make(map[string]crypto.Signer),
+		retired: make(map[string]time.Time),
 	}
 }
 
 // NewMap creates a new KeyStore from a map.
-func NewMap(store map[string]*rsa.PrivateKey) *KeyStore {
+func NewMap(store map[string]crypto.Signer) *KeyStore {
 	return &KeyStore{
-		keys: store,
+		keys:    store,
+		retired: make(map[string]time.Time),
 	}
 }
 
@@ -38,10 +52,18 @@ func NewMap(store map[string]*rsa.PrivateKey) *KeyStore {
 // Example: keystore.NewFS(os.DirFS("./zarf/keys/"))
 // Example: zarf/keys/95A369C9-068E-4932-90FC-4D46ADFC0FB3.pem
 func NewFS(fsys fs.FS) (*KeyStore, error) {
-	ks := KeyStore{
-		keys: make(map[string]*rsa.PrivateKey),
+	keys, err := readFS(fsys)
+	if err != nil {
+		return nil, err
 	}
 
+	return NewMap(keys), nil
+}
+
+// readFS reads every PEM file rooted inside the directory.
+func readFS(fsys fs.FS) (map[string]crypto.Signer, error) {
+	keys := make(map[string]crypto.Signer)
+
 	fn := func(fileName string, dirEntry fs.DirEntry, err error) error {
 		if err != nil {
 			return fmt.Errorf("walkdir error: %w", err)
@@ -70,7 +92,7 @@ func NewFS(fsys fs.FS) (*KeyStore, error) {
 			return fmt.Errorf("reading auth private key: %w", err)
 		}
 
-		privateKey, err := jwt.ParseRSAPrivateKeyFromPEM(pemFile)
+		privateKey, err := parsePrivateKey(pemFile)
 		if err != nil {
 			return fmt.Errorf("parsing auth private key: %w", err)
 		}
@@ -78,7 +100,7 @@ func NewFS(fsys fs.FS) (*KeyStore, error) {
 		// The key identifier is the file name without the extension.
 		KID := strings.TrimSuffix(dirEntry.Name(), ".pem")
 
-		ks.keys[KID] = privateKey
+		keys[KID] = privateKey
 		return nil
 	}
 
@@ -86,15 +108,16 @@ func NewFS(fsys fs.FS) (*KeyStore, error) {
 		return nil, fmt.Errorf("walking directory: %w", err)
 	}
 
-	return &ks, nil
+	return keys, nil
 }
 
 // Add adds a private key to the keystore.
-func (ks *KeyStore) Add(key *rsa.PrivateKey, kid string) {
+func (ks *KeyStore) Add(key crypto.Signer, kid string) {
 	ks.mu.Lock()
 	defer ks.mu.Unlock()
 
 	ks.keys[kid] = key
+	delete(ks.retired, kid)
 }
 
 // Remove removes a private key from the keystore.
@@ -103,10 +126,12 @@ func (ks *KeyStore) Remove(kid string) {
 	defer ks.mu.Unlock()
 
 	delete(ks.keys, kid)
+	delete(ks.retired, kid)
 }
 
-// PrivateKey returns a private key from the keystore.
-func (ks *KeyStore) PrivateKey(kid string) (*rsa.PrivateKey, error) {
+// PrivateKey returns a private key from the keystore. Retired keys can no
+// longer be used for signing.
+func (ks *KeyStore) PrivateKey(kid string) (crypto.Signer, error) {
 	ks.mu.RLock()
 	defer ks.mu.RUnlock()
 
@@ -115,15 +140,70 @@ func (ks *KeyStore) PrivateKey(kid string) (*rsa.PrivateKey, error) {
 		return nil, errors.New("key not found")
 	}
 
+	if _, retired := ks.retired[kid]; retired {
+		return nil, errors.New("key has been retired")
+	}
+
 	return privateKey, nil
 }
 
-// PublicKey returns a public key from the keystore.
-func (ks *KeyStore) PublicKey(kid string) (*rsa.PublicKey, error) {
-	privateKey, err := ks.PrivateKey(kid)
+// PublicKey returns a public key from the keystore. Retired keys are still
+// returned until they are removed so tokens they signed stay valid.
+func (ks *KeyStore) PublicKey(kid string) (crypto.PublicKey, error) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	privateKey, ok := ks.keys[kid]
+	if !ok {
+		return nil, errors.New("key not found")
+	}
+
+	return privateKey.Public(), nil
+}
+
+// PublicKeys returns the public keys held by the keystore keyed by key id.
+func (ks *KeyStore) PublicKeys() map[string]crypto.PublicKey {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	keys := make(map[string]crypto.PublicKey, len(ks.keys))
+	for kid, privateKey := range ks.keys {
+		keys[kid] = privateKey.Public()
+	}
+
+	return keys
+}
+
+// parsePrivateKey parses a PEM encoded RSA, ECDSA or Ed25519 private key. The
+// key can be in PKCS #1, SEC 1 or PKCS #8 form whatever the PEM block type
+// says, since tools are not consistent about it.
+func parsePrivateKey(pemFile []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(pemFile)
+	if block == nil {
+		return nil, errors.New("key must be PEM encoded")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
 	if err != nil {
-		return nil, err
+		return nil, errors.New("unsupported private key format")
+	}
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return k, nil
+	case *ecdsa.PrivateKey:
+		return k, nil
+	case ed25519.PrivateKey:
+		return k, nil
 	}
 
-	return &privateKey.PublicKey, nil
+	return nil, fmt.Errorf("unsupported private key type %T", key)
 }
@@ -0,0 +1,158 @@
+package keystore_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/mihailtudos/service3/foundation/keystore"
+)
+
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+func TestRotation(t *testing.T) {
+	fsys := fstest.MapFS{
+		"kid1.pem": {Data: generatePEM(t)},
+	}
+
+	ks, err := keystore.NewFS(fsys)
+	if err != nil {
+		t.Fatalf("constructing keystore: %v", err)
+	}
+	if err := ks.SetActive("kid1"); err != nil {
+		t.Fatalf("setting active key: %v", err)
+	}
+
+	now := time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC)
+	const retention = time.Hour
+
+	t.Log("Given the need to rotate keys while running.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen a key is added and scheduled.", testID)
+		{
+			fsys["kid2.pem"] = &fstest.MapFile{Data: generatePEM(t)}
+			fsys[keystore.ScheduleFile] = &fstest.MapFile{
+				Data: []byte(`{"next_kid": "kid2", "switch_at": "2026-01-01T00:10:00Z"}`),
+			}
+
+			events, err := ks.Sync(fsys, now, retention)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to sync the keys : %v", failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to sync the keys.", success, testID)
+
+			if !hasEvent(events, keystore.EventAdded, "kid2") || !hasEvent(events, keystore.EventScheduled, "kid2") {
+				t.Fatalf("\t%s\tTest %d:\tShould report the key as added and scheduled : %v", failed, testID, events)
+			}
+			t.Logf("\t%s\tTest %d:\tShould report the key as added and scheduled.", success, testID)
+
+			if kid := ks.ActiveKID(); kid != "kid1" {
+				t.Fatalf("\t%s\tTest %d:\tShould keep the active key before the switch : got %q", failed, testID, kid)
+			}
+			t.Logf("\t%s\tTest %d:\tShould keep the active key before the switch.", success, testID)
+		}
+
+		testID = 1
+		t.Logf("\tTest %d:\tWhen the switch over time has passed.", testID)
+		{
+			events, err := ks.Sync(fsys, now.Add(10*time.Minute), retention)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to sync the keys : %v", failed, testID, err)
+			}
+
+			if kid := ks.ActiveKID(); kid != "kid2" || !hasEvent(events, keystore.EventActivated, "kid2") {
+				t.Fatalf("\t%s\tTest %d:\tShould activate the next key : got %q", failed, testID, kid)
+			}
+			t.Logf("\t%s\tTest %d:\tShould activate the next key.", success, testID)
+		}
+
+		testID = 2
+		t.Logf("\tTest %d:\tWhen the old key file is removed.", testID)
+		{
+			delete(fsys, "kid1.pem")
+
+			events, err := ks.Sync(fsys, now.Add(20*time.Minute), retention)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to sync the keys : %v", failed, testID, err)
+			}
+
+			if !hasEvent(events, keystore.EventRetired, "kid1") {
+				t.Fatalf("\t%s\tTest %d:\tShould retire the key : %v", failed, testID, events)
+			}
+			t.Logf("\t%s\tTest %d:\tShould retire the key.", success, testID)
+
+			if _, err := ks.PrivateKey("kid1"); err == nil {
+				t.Fatalf("\t%s\tTest %d:\tShould not be able to sign with a retired key.", failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould not be able to sign with a retired key.", success, testID)
+
+			if _, err := ks.PublicKey("kid1"); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould still validate with a retired key : %v", failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould still validate with a retired key.", success, testID)
+		}
+
+		testID = 3
+		t.Logf("\tTest %d:\tWhen the retention has passed.", testID)
+		{
+			events, err := ks.Sync(fsys, now.Add(20*time.Minute+retention), retention)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to sync the keys : %v", failed, testID, err)
+			}
+
+			if !hasEvent(events, keystore.EventRemoved, "kid1") {
+				t.Fatalf("\t%s\tTest %d:\tShould remove the key : %v", failed, testID, events)
+			}
+
+			if _, err := ks.PublicKey("kid1"); err == nil {
+				t.Fatalf("\t%s\tTest %d:\tShould not validate with a removed key.", failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould remove the key.", success, testID)
+		}
+
+		testID = 4
+		t.Logf("\tTest %d:\tWhen the active key file is removed.", testID)
+		{
+			delete(fsys, "kid2.pem")
+			delete(fsys, keystore.ScheduleFile)
+
+			if _, err := ks.Sync(fsys, now.Add(3*time.Hour), retention); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to sync the keys : %v", failed, testID, err)
+			}
+
+			if _, err := ks.PrivateKey("kid2"); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould keep signing with the active key : %v", failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould keep signing with the active key.", success, testID)
+		}
+	}
+}
+
+func hasEvent(events []keystore.Event, kind string, kid string) bool {
+	for _, e := range events {
+		if e.Kind == kind && e.KID == kid {
+			return true
+		}
+	}
+	return false
+}
+
+func generatePEM(t *testing.T) []byte {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	})
+}
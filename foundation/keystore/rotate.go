@@ -0,0 +1,225 @@
+package keystore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"time"
+)
+
+// ScheduleFile is the name of the optional file inside the keys folder that
+// schedules the next active key.
+// Example: {"next_kid": "95A369C9-068E-4932-90FC-4D46ADFC0FB3", "switch_at": "2026-01-02T15:04:05Z"}
+const ScheduleFile = "rotation.json"
+
+// Set of event kinds reported when the keystore changes.
+const (
+	EventAdded     = "added"
+	EventRetired   = "retired"
+	EventRemoved   = "removed"
+	EventScheduled = "scheduled"
+	EventActivated = "activated"
+)
+
+// Event describes a single change to the keys held by the keystore.
+type Event struct {
+	Kind string
+	KID  string
+	Time time.Time
+}
+
+// schedule represents the contents of the schedule file.
+type schedule struct {
+	NextKID  string    `json:"next_kid"`
+	SwitchAt time.Time `json:"switch_at"`
+}
+
+// ActiveKID returns the key identifier that should be used to sign tokens.
+func (ks *KeyStore) ActiveKID() string {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	return ks.active
+}
+
+// SetActive makes the specified key the one used to sign tokens.
+func (ks *KeyStore) SetActive(kid string) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if _, ok := ks.keys[kid]; !ok {
+		return errors.New("key not found")
+	}
+	if _, retired := ks.retired[kid]; retired {
+		return errors.New("key has been retired")
+	}
+
+	ks.active = kid
+	return nil
+}
+
+// ScheduleNext sets the key that becomes active once the switch over time
+// has passed. The switch happens during the next call to Sync.
+func (ks *KeyStore) ScheduleNext(kid string, switchAt time.Time) error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if _, ok := ks.keys[kid]; !ok {
+		return errors.New("key not found")
+	}
+
+	ks.next = kid
+	ks.switchAt = switchAt
+	return nil
+}
+
+// Sync brings the keystore in line with the PEM files rooted inside the
+// directory. New files are added, keys whose file is gone are retired and
+// retired keys are removed once they have been retired for longer than the
+// retention, which should be at least the lifetime of a token. Retired keys
+// can't sign tokens but can still validate the tokens they signed. The active
+// key is never retired so the service can keep signing tokens. When the
+// directory holds a schedule file, the scheduled key becomes active at the
+// switch over time.
+func (ks *KeyStore) Sync(fsys fs.FS, now time.Time, retention time.Duration) ([]Event, error) {
+	keys, err := readFS(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	sched, err := readSchedule(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	var events []Event
+	event := func(kind string, kid string) {
+		events = append(events, Event{Kind: kind, KID: kid, Time: now})
+	}
+
+	for kid, key := range keys {
+		_, exists := ks.keys[kid]
+		_, retired := ks.retired[kid]
+
+		ks.keys[kid] = key
+		delete(ks.retired, kid)
+
+		if !exists || retired {
+			event(EventAdded, kid)
+		}
+	}
+
+	for kid := range ks.keys {
+		if _, ok := keys[kid]; ok || kid == ks.active {
+			continue
+		}
+
+		retiredAt, retired := ks.retired[kid]
+		switch {
+		case !retired:
+			ks.retired[kid] = now
+			event(EventRetired, kid)
+
+		case now.Sub(retiredAt) >= retention:
+			delete(ks.keys, kid)
+			delete(ks.retired, kid)
+			event(EventRemoved, kid)
+		}
+	}
+
+	if sched.NextKID != "" && (sched.NextKID != ks.next || !sched.SwitchAt.Equal(ks.switchAt)) && sched.NextKID != ks.active {
+		if _, ok := keys[sched.NextKID]; !ok {
+			return events, fmt.Errorf("scheduled key %q not found", sched.NextKID)
+		}
+		ks.next = sched.NextKID
+		ks.switchAt = sched.SwitchAt
+		event(EventScheduled, ks.next)
+	}
+
+	if ks.next != "" && !now.Before(ks.switchAt) {
+		if _, retired := ks.retired[ks.next]; !retired {
+			if _, ok := ks.keys[ks.next]; ok {
+				ks.active = ks.next
+				event(EventActivated, ks.active)
+			}
+		}
+		ks.next = ""
+		ks.switchAt = time.Time{}
+	}
+
+	return events, nil
+}
+
+// readSchedule reads the schedule file if the directory holds one.
+func readSchedule(fsys fs.FS) (schedule, error) {
+	file, err := fsys.Open(ScheduleFile)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return schedule{}, nil
+		}
+		return schedule{}, fmt.Errorf("opening schedule file: %w", err)
+	}
+	defer file.Close()
+
+	var sched schedule
+	if err := json.NewDecoder(io.LimitReader(file, 1024*1024)).Decode(&sched); err != nil {
+		return schedule{}, fmt.Errorf("decoding schedule file: %w", err)
+	}
+
+	return sched, nil
+}
+
+// WatchConfig represents the settings for watching a keys folder.
+type WatchConfig struct {
+	// Interval between two reads of the folder. Defaults to 30 seconds.
+	Interval time.Duration
+
+	// Retention is how long a retired key can still validate tokens.
+	// Defaults to 1 hour.
+	Retention time.Duration
+
+	// OnEvent is called for every change to the keystore.
+	OnEvent func(Event)
+
+	// OnError is called when the folder can't be read. The keystore keeps
+	// the keys it already holds.
+	OnError func(error)
+}
+
+// Watch polls the directory and syncs the keystore with it until the context
+// is cancelled.
+func (ks *KeyStore) Watch(ctx context.Context, fsys fs.FS, cfg WatchConfig) {
+	if cfg.Interval <= 0 {
+		cfg.Interval = 30 * time.Second
+	}
+	if cfg.Retention <= 0 {
+		cfg.Retention = time.Hour
+	}
+
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case now := <-ticker.C:
+			events, err := ks.Sync(fsys, now, cfg.Retention)
+			if cfg.OnEvent != nil {
+				for _, e := range events {
+					cfg.OnEvent(e)
+				}
+			}
+			if err != nil && cfg.OnError != nil {
+				cfg.OnError(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,151 @@
+// Package mail provides support for sending email through a pluggable Mailer.
+package mail
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Message is a plain text email sent to a single recipient.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends email messages.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// =============================================================================
+
+// SMTPConfig holds the settings for sending email through an SMTP server.
+// The credentials are optional, when provided PLAIN authentication is used
+// which requires the server to support TLS.
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+// SMTP sends email through an SMTP server.
+type SMTP struct {
+	cfg SMTPConfig
+}
+
+// NewSMTP constructs a Mailer that sends email through an SMTP server.
+func NewSMTP(cfg SMTPConfig) *SMTP {
+	return &SMTP{cfg: cfg}
+}
+
+// Send delivers the message to the SMTP server. The standard library client
+// doesn't take a context, so the call can't be cancelled once started.
+func (s *SMTP) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	data, err := format(s.cfg.From, msg)
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if s.cfg.Username != "" {
+		auth = smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, s.cfg.Host)
+	}
+
+	addr := net.JoinHostPort(s.cfg.Host, strconv.Itoa(s.cfg.Port))
+	if err := smtp.SendMail(addr, auth, s.cfg.From, []string{msg.To}, data); err != nil {
+		return fmt.Errorf("sending mail to %s: %w", msg.To, err)
+	}
+
+	return nil
+}
+
+// =============================================================================
+
+// File writes every message to its own file in a folder, for local
+// development and tests.
+type File struct {
+	dir string
+	seq atomic.Int64
+}
+
+// NewFile constructs a Mailer that writes messages to the folder.
+func NewFile(dir string) *File {
+	return &File{dir: dir}
+}
+
+// Send writes the message to a new file named after the time it was sent.
+func (f *File) Send(ctx context.Context, msg Message) error {
+	data, err := format("", msg)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%d.eml", time.Now().UTC().Format("20060102T150405.000000000"), f.seq.Add(1))
+	if err := os.WriteFile(filepath.Join(f.dir, name), data, 0o600); err != nil {
+		return fmt.Errorf("writing mail to %s: %w", msg.To, err)
+	}
+
+	return nil
+}
+
+// =============================================================================
+
+// Log writes every message to the logger, for local development.
+type Log struct {
+	log *zap.SugaredLogger
+}
+
+// NewLog constructs a Mailer that writes messages to the logger.
+func NewLog(log *zap.SugaredLogger) *Log {
+	return &Log{log: log}
+}
+
+// Send logs the message.
+func (l *Log) Send(ctx context.Context, msg Message) error {
+	l.log.Infow("mail", "to", msg.To, "subject", msg.Subject, "body", msg.Body)
+	return nil
+}
+
+// =============================================================================
+
+// format renders the message with its headers. Addresses and the subject are
+// rejected when they contain line breaks so they can't inject headers.
+func format(from string, msg Message) ([]byte, error) {
+	for _, v := range []string{from, msg.To, msg.Subject} {
+		if strings.ContainsAny(v, "\r\n") {
+			return nil, errors.New("mail headers can't contain line breaks")
+		}
+	}
+
+	var b bytes.Buffer
+	if from != "" {
+		fmt.Fprintf(&b, "From: %s\r\n", from)
+	}
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+
+	return b.Bytes(), nil
+}
@@ -0,0 +1,71 @@
+package mail_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mihailtudos/service3/foundation/mail"
+)
+
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+func TestFile(t *testing.T) {
+	dir := t.TempDir()
+	m := mail.NewFile(dir)
+
+	t.Log("Given the need to write mail to a folder.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen sending a message.", testID)
+		{
+			msg := mail.Message{
+				To:      "user@example.com",
+				Subject: "Hello",
+				Body:    "Hello Gopher\nBye",
+			}
+
+			if err := m.Send(context.Background(), msg); err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to send the message : %v", failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to send the message.", success, testID)
+
+			files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+			if err != nil || len(files) != 1 {
+				t.Fatalf("\t%s\tTest %d:\tShould write a single file : %v", failed, testID, files)
+			}
+			t.Logf("\t%s\tTest %d:\tShould write a single file.", success, testID)
+
+			data, err := os.ReadFile(files[0])
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to read the file : %v", failed, testID, err)
+			}
+
+			for _, want := range []string{"To: user@example.com\r\n", "Subject: Hello\r\n", "Hello Gopher\r\nBye"} {
+				if !strings.Contains(string(data), want) {
+					t.Fatalf("\t%s\tTest %d:\tShould write the message : missing %q in %q", failed, testID, want, data)
+				}
+			}
+			t.Logf("\t%s\tTest %d:\tShould write the message.", success, testID)
+		}
+
+		testID = 1
+		t.Logf("\tTest %d:\tWhen the subject contains a line break.", testID)
+		{
+			msg := mail.Message{
+				To:      "user@example.com",
+				Subject: "Hello\r\nBcc: other@example.com",
+			}
+
+			if err := m.Send(context.Background(), msg); err == nil {
+				t.Fatalf("\t%s\tTest %d:\tShould reject the message.", failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould reject the message.", success, testID)
+		}
+	}
+}
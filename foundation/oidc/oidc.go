@@ -0,0 +1,252 @@
+// Package oidc provides support for logging users in through an OpenID
+// Connect provider with the authorization code flow and PKCE.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/mihailtudos/service3/foundation/jwks"
+)
+
+// Config represents the settings of the client registered with the provider.
+type Config struct {
+	// Issuer is the URL of the provider, used to discover its endpoints.
+	Issuer string
+
+	ClientID     string
+	ClientSecret string
+
+	// RedirectURL is where the provider sends the user back to with the
+	// authorization code.
+	RedirectURL string
+
+	// Scopes asked for. Defaults to openid, email and profile.
+	Scopes []string
+
+	// Client used to talk to the provider. Defaults to a client with a 5
+	// second timeout.
+	Client *http.Client
+}
+
+// Identity represents the user as known by the provider.
+type Identity struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// Provider is an OpenID Connect provider discovered from its issuer URL.
+type Provider struct {
+	cfg      Config
+	authURL  string
+	tokenURL string
+	keys     *jwks.Remote
+	now      func() time.Time
+}
+
+// Discover reads the configuration document of the provider and constructs
+// a Provider for it.
+func Discover(ctx context.Context, cfg Config) (*Provider, error) {
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 5 * time.Second}
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email", "profile"}
+	}
+
+	u := strings.TrimSuffix(cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("building discovery request: %w", err)
+	}
+
+	resp, err := cfg.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching discovery document: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching discovery document: unexpected status %d", resp.StatusCode)
+	}
+
+	var doc struct {
+		Issuer   string `json:"issuer"`
+		AuthURL  string `json:"authorization_endpoint"`
+		TokenURL string `json:"token_endpoint"`
+		JWKSURI  string `json:"jwks_uri"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("decoding discovery document: %w", err)
+	}
+
+	if doc.Issuer != cfg.Issuer {
+		return nil, fmt.Errorf("discovery document issuer %q doesn't match %q", doc.Issuer, cfg.Issuer)
+	}
+
+	if doc.AuthURL == "" || doc.TokenURL == "" || doc.JWKSURI == "" {
+		return nil, errors.New("discovery document is missing endpoints")
+	}
+
+	p := Provider{
+		cfg:      cfg,
+		authURL:  doc.AuthURL,
+		tokenURL: doc.TokenURL,
+		keys:     jwks.NewRemote(jwks.Config{URL: doc.JWKSURI, Client: cfg.Client}),
+		now:      time.Now,
+	}
+
+	return &p, nil
+}
+
+// Issuer returns the issuer URL of the provider.
+func (p *Provider) Issuer() string {
+	return p.cfg.Issuer
+}
+
+// AuthCodeURL returns the URL to send the user to for logging in. The state
+// and nonce tie the response to this login, and the challenge is derived
+// from the verifier that has to be presented with the code.
+func (p *Provider) AuthCodeURL(state string, nonce string, verifier string) string {
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", p.cfg.ClientID)
+	v.Set("redirect_uri", p.cfg.RedirectURL)
+	v.Set("scope", strings.Join(p.cfg.Scopes, " "))
+	v.Set("state", state)
+	v.Set("nonce", nonce)
+	v.Set("code_challenge", Challenge(verifier))
+	v.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(p.authURL, "?") {
+		sep = "&"
+	}
+
+	return p.authURL + sep + v.Encode()
+}
+
+// Exchange trades the authorization code for an ID token and returns the
+// identity it carries once the token is verified.
+func (p *Provider) Exchange(ctx context.Context, code string, verifier string, nonce string) (Identity, error) {
+	v := url.Values{}
+	v.Set("grant_type", "authorization_code")
+	v.Set("code", code)
+	v.Set("redirect_uri", p.cfg.RedirectURL)
+	v.Set("code_verifier", verifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenURL, strings.NewReader(v.Encode()))
+	if err != nil {
+		return Identity{}, fmt.Errorf("building token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+
+	resp, err := p.cfg.Client.Do(req)
+	if err != nil {
+		return Identity{}, fmt.Errorf("exchanging code: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var tkn struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&tkn); err != nil {
+		return Identity{}, fmt.Errorf("decoding token response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return Identity{}, fmt.Errorf("exchanging code: %s: %s", tkn.Error, tkn.ErrorDescription)
+	}
+
+	if tkn.IDToken == "" {
+		return Identity{}, errors.New("token response has no id token")
+	}
+
+	return p.Verify(tkn.IDToken, nonce)
+}
+
+// Verify checks the signature and the claims of the ID token and returns the
+// identity it carries.
+func (p *Provider) Verify(idToken string, nonce string) (Identity, error) {
+	var claims struct {
+		jwt.RegisteredClaims
+		Nonce         string `json:"nonce"`
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+		Name          string `json:"name"`
+	}
+
+	keyFunc := func(t *jwt.Token) (any, error) {
+		kid, ok := t.Header["kid"].(string)
+		if !ok {
+			return nil, errors.New("missing key id (kid) in id token header")
+		}
+		return p.keys.PublicKey(kid)
+	}
+
+	parser := jwt.NewParser(
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "ES256", "ES384", "ES512", "EdDSA"}),
+		jwt.WithIssuer(p.cfg.Issuer),
+		jwt.WithAudience(p.cfg.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithTimeFunc(p.now),
+	)
+
+	if _, err := parser.ParseWithClaims(idToken, &claims, keyFunc); err != nil {
+		return Identity{}, fmt.Errorf("verifying id token: %w", err)
+	}
+
+	if claims.Nonce == "" || claims.Nonce != nonce {
+		return Identity{}, errors.New("id token nonce doesn't match")
+	}
+
+	if claims.Subject == "" {
+		return Identity{}, errors.New("id token has no subject")
+	}
+
+	id := Identity{
+		Issuer:        claims.Issuer,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		Name:          claims.Name,
+	}
+
+	return id, nil
+}
+
+// =============================================================================
+
+// RandomString returns a random URL safe string carrying 256 bits of
+// randomness, suitable for a state, nonce or PKCE verifier.
+func RandomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generating random string: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Challenge returns the S256 PKCE challenge for the verifier.
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,177 @@
+package oidc_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/mihailtudos/service3/foundation/jwks"
+	"github.com/mihailtudos/service3/foundation/oidc"
+)
+
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+// provider is a stand-in OpenID Connect provider. It hands out a single
+// code for the last authorization request it saw.
+type provider struct {
+	srv       *httptest.Server
+	key       *rsa.PrivateKey
+	challenge string
+	nonce     string
+	email     string
+}
+
+func newProvider(t *testing.T) *provider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+
+	p := provider{
+		key:   key,
+		email: "gopher@example.com",
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.srv.URL,
+			"authorization_endpoint": p.srv.URL + "/authorize",
+			"token_endpoint":         p.srv.URL + "/token",
+			"jwks_uri":               p.srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jwks.NewSet(map[string]crypto.PublicKey{"kid1": &key.PublicKey}))
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		if id != "client" || secret != "secret" || r.FormValue("code") != "code" || oidc.Challenge(r.FormValue("code_verifier")) != p.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		claims := struct {
+			jwt.RegisteredClaims
+			Nonce         string `json:"nonce"`
+			Email         string `json:"email"`
+			EmailVerified bool   `json:"email_verified"`
+		}{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    p.srv.URL,
+				Subject:   "subject1",
+				Audience:  jwt.ClaimStrings{"client"},
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+				IssuedAt:  jwt.NewNumericDate(time.Now()),
+			},
+			Nonce:         p.nonce,
+			Email:         p.email,
+			EmailVerified: true,
+		}
+
+		tkn := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		tkn.Header["kid"] = "kid1"
+		signed, err := tkn.SignedString(key)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]string{"id_token": signed, "token_type": "Bearer"})
+	})
+
+	p.srv = httptest.NewServer(mux)
+	t.Cleanup(p.srv.Close)
+
+	return &p
+}
+
+// authorize plays the part of the user logging in at the provider.
+func (p *provider) authorize(t *testing.T, authURL string) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("parsing authorization url: %v", err)
+	}
+
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" {
+		t.Fatalf("unexpected challenge method %q", q.Get("code_challenge_method"))
+	}
+	p.challenge = q.Get("code_challenge")
+	p.nonce = q.Get("nonce")
+}
+
+func TestLogin(t *testing.T) {
+	p := newProvider(t)
+
+	cfg := oidc.Config{
+		Issuer:       p.srv.URL,
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost:3000/v1/oidc/callback",
+	}
+
+	t.Log("Given the need to log users in through an OpenID Connect provider.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen completing a login.", testID)
+		{
+			ctx := context.Background()
+
+			prv, err := oidc.Discover(ctx, cfg)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to discover the provider : %v", failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to discover the provider.", success, testID)
+
+			verifier, _ := oidc.RandomString()
+			nonce, _ := oidc.RandomString()
+			p.authorize(t, prv.AuthCodeURL("state", nonce, verifier))
+
+			if _, err := prv.Exchange(ctx, "code", "wrong verifier", nonce); err == nil {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT exchange the code with the wrong verifier.", failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT exchange the code with the wrong verifier.", success, testID)
+
+			if _, err := prv.Exchange(ctx, "code", verifier, "other nonce"); err == nil {
+				t.Fatalf("\t%s\tTest %d:\tShould NOT accept an id token for another nonce.", failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould NOT accept an id token for another nonce.", success, testID)
+
+			id, err := prv.Exchange(ctx, "code", verifier, nonce)
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to exchange the code : %v", failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to exchange the code.", success, testID)
+
+			if id.Issuer != p.srv.URL || id.Subject != "subject1" || id.Email != p.email || !id.EmailVerified {
+				t.Fatalf("\t%s\tTest %d:\tShould get the identity from the id token : %+v", failed, testID, id)
+			}
+			t.Logf("\t%s\tTest %d:\tShould get the identity from the id token.", success, testID)
+		}
+
+		testID = 1
+		t.Logf("\tTest %d:\tWhen the provider claims another issuer.", testID)
+		{
+			bad := cfg
+			bad.Issuer = p.srv.URL + "/other"
+
+			if _, err := oidc.Discover(context.Background(), bad); err == nil {
+				t.Fatalf("\t%s\tTest %d:\tShould reject the provider.", failed, testID)
+			}
+			t.Logf("\t%s\tTest %d:\tShould reject the provider.", success, testID)
+		}
+	}
+}
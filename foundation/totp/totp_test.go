@@ -0,0 +1,77 @@
+package totp_test
+
+import (
+	"encoding/base32"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/mihailtudos/service3/foundation/totp"
+)
+
+const (
+	success = "\u2713"
+	failed  = "\u2717"
+)
+
+func TestCode(t *testing.T) {
+	// The SHA1 test vectors from RFC 6238 appendix B, truncated to six digits.
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+	vectors := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	t.Log("Given the need to generate and validate one-time passwords.")
+	{
+		for testID, tt := range vectors {
+			t.Logf("\tTest %d:\tWhen handling the code at %d.", testID, tt.unix)
+			{
+				now := time.Unix(tt.unix, 0)
+
+				code, err := totp.Code(secret, totp.Step(now))
+				if err != nil || code != tt.code {
+					t.Fatalf("\t%s\tTest %d:\tShould generate code %s : got %s, %v", failed, testID, tt.code, code, err)
+				}
+				t.Logf("\t%s\tTest %d:\tShould generate code %s.", success, testID, tt.code)
+
+				if _, ok, err := totp.Validate(secret, tt.code, now.Add(totp.Period), 1); !ok || err != nil {
+					t.Fatalf("\t%s\tTest %d:\tShould accept the code a step later : %v", failed, testID, err)
+				}
+				t.Logf("\t%s\tTest %d:\tShould accept the code a step later.", success, testID)
+
+				if _, ok, _ := totp.Validate(secret, tt.code, now.Add(3*totp.Period), 1); ok {
+					t.Fatalf("\t%s\tTest %d:\tShould NOT accept the code three steps later.", failed, testID)
+				}
+				t.Logf("\t%s\tTest %d:\tShould NOT accept the code three steps later.", success, testID)
+			}
+		}
+	}
+}
+
+func TestURI(t *testing.T) {
+	t.Log("Given the need to enrol authenticator apps.")
+	{
+		testID := 0
+		t.Logf("\tTest %d:\tWhen generating a secret and its URI.", testID)
+		{
+			secret, err := totp.GenerateSecret()
+			if err != nil {
+				t.Fatalf("\t%s\tTest %d:\tShould be able to generate a secret : %v", failed, testID, err)
+			}
+			t.Logf("\t%s\tTest %d:\tShould be able to generate a secret.", success, testID)
+
+			u, err := url.Parse(totp.URI("Sales", "user@example.com", secret))
+			if err != nil || u.Scheme != "otpauth" || u.Host != "totp" || u.Query().Get("secret") != secret {
+				t.Fatalf("\t%s\tTest %d:\tShould build an otpauth URI : %v", failed, testID, u)
+			}
+			t.Logf("\t%s\tTest %d:\tShould build an otpauth URI.", success, testID)
+		}
+	}
+}
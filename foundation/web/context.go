@@ -13,11 +13,13 @@ type ctxKey int
 // key is how request values or stored/retrieved.
 const key ctxKey = 1
 
-// Values represents states for each request.
+// Values represents states for each request. Started is set once the status
+// of the response was sent, after which it can no longer be changed.
 type Values struct {
 	TraceID    string
 	Now        time.Time
 	StatusCode int
+	Started    bool
 }
 
 // GetValues returns the values from the context.
@@ -47,3 +49,10 @@ func SetStatusCode(ctx context.Context, statusCode int) error {
 	values.StatusCode = statusCode
 	return nil
 }
+
+// setStarted records in the context that the response has started.
+func setStarted(ctx context.Context) {
+	if values, err := GetValues(ctx); err == nil {
+		values.Started = true
+	}
+}
@@ -0,0 +1,100 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Page is the standard envelope for responses that return a list of items.
+// Offset based lists set Page, cursor based lists set NextCursor.
+type Page[T any] struct {
+	Items       []T    `json:"items"`
+	Page        int    `json:"page,omitempty"`
+	RowsPerPage int    `json:"rows_per_page"`
+	Total       int    `json:"total"`
+	NextCursor  string `json:"next_cursor,omitempty"`
+}
+
+// NewPage constructs a Page for an offset based list.
+func NewPage[T any](items []T, page int, rowsPerPage int, total int) Page[T] {
+	if items == nil {
+		items = []T{}
+	}
+
+	return Page[T]{
+		Items:       items,
+		Page:        page,
+		RowsPerPage: rowsPerPage,
+		Total:       total,
+	}
+}
+
+// NewCursorPage constructs a Page for a cursor based list. The next cursor is
+// empty when there are no more pages.
+func NewCursorPage[T any](items []T, rowsPerPage int, total int, nextCursor string) Page[T] {
+	if items == nil {
+		items = []T{}
+	}
+
+	return Page[T]{
+		Items:       items,
+		RowsPerPage: rowsPerPage,
+		Total:       total,
+		NextCursor:  nextCursor,
+	}
+}
+
+// RespondPage sends the page back to the client along with a Link header
+// (RFC 8288) describing how to navigate to the neighbouring pages. The links
+// are built from the request URL by replacing the "page" or "cursor" query
+// string parameter.
+func RespondPage[T any](ctx context.Context, w http.ResponseWriter, r *http.Request, p Page[T], statusCode int) error {
+	if links := pageLinks(r.URL, p.Page, p.RowsPerPage, p.Total, p.NextCursor); len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+
+	return Respond(ctx, w, p, statusCode)
+}
+
+// pageLinks returns the set of link values for the specified page.
+func pageLinks(u *url.URL, page int, rowsPerPage int, total int, nextCursor string) []string {
+	link := func(rel string, set func(url.Values)) string {
+		q := u.Query()
+		set(q)
+		ref := url.URL{Path: u.Path, RawQuery: q.Encode()}
+		return fmt.Sprintf("<%s>; rel=\"%s\"", ref.String(), rel)
+	}
+
+	var links []string
+
+	switch {
+	case page > 0:
+		setPage := func(n int) func(url.Values) {
+			return func(q url.Values) { q.Set("page", strconv.Itoa(n)) }
+		}
+
+		links = append(links, link("first", setPage(1)))
+		if page > 1 {
+			links = append(links, link("prev", setPage(page-1)))
+		}
+		if page*rowsPerPage < total {
+			links = append(links, link("next", setPage(page+1)))
+		}
+		if rowsPerPage > 0 && total > 0 {
+			last := (total + rowsPerPage - 1) / rowsPerPage
+			links = append(links, link("last", setPage(last)))
+		}
+
+	default:
+		links = append(links, link("first", func(q url.Values) { q.Del("cursor") }))
+		if nextCursor != "" {
+			links = append(links, link("next", func(q url.Values) { q.Set("cursor", nextCursor) }))
+		}
+	}
+
+	return links
+}
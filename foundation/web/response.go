@@ -3,16 +3,25 @@ package web
 import (
 	"context"
 	"encoding/json"
+	"io"
 	"net/http"
 )
 
+// Respond converts a Go value to JSON and sends it to the client.
 func Respond(ctx context.Context, w http.ResponseWriter, data any, statusCode int) error {
+	return RespondAs(ctx, w, "application/json", data, statusCode)
+}
+
+// RespondAs converts a Go value to JSON and sends it to the client with the
+// content type, for media types based on JSON.
+func RespondAs(ctx context.Context, w http.ResponseWriter, contentType string, data any, statusCode int) error {
 
 	// Set status code for request logger middleware.
 	_ = SetStatusCode(ctx, statusCode)
 
 	// If there is nothing to marshal then set status code and return.
 	if statusCode == http.StatusNoContent {
+		setStarted(ctx)
 		w.WriteHeader(statusCode)
 		return nil
 	}
@@ -24,9 +33,10 @@ func Respond(ctx context.Context, w http.ResponseWriter, data any, statusCode in
 	}
 
 	// Set the content type and headers once we know marshaling has succeeded.
-	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Type", contentType)
 
 	// Write the status code to the response.
+	setStarted(ctx)
 	w.WriteHeader(statusCode)
 
 	// Send the result back to the client.
@@ -36,3 +46,31 @@ func Respond(ctx context.Context, w http.ResponseWriter, data any, statusCode in
 
 	return nil
 }
+
+// Redirect replies to the request with a redirect to the url.
+func Redirect(ctx context.Context, w http.ResponseWriter, r *http.Request, url string, statusCode int) error {
+
+	// Set status code for request logger middleware.
+	_ = SetStatusCode(ctx, statusCode)
+
+	setStarted(ctx)
+	http.Redirect(w, r, url, statusCode)
+
+	return nil
+}
+
+// RespondStream sends the status code and content type and then lets fn
+// write the body, so large responses don't have to be held in memory. Once
+// the status is sent it can't be changed, so the response is marked as
+// started and an error from fn only cuts the body short.
+func RespondStream(ctx context.Context, w http.ResponseWriter, contentType string, statusCode int, fn func(io.Writer) error) error {
+
+	// Set status code for request logger middleware.
+	_ = SetStatusCode(ctx, statusCode)
+
+	w.Header().Set("Content-Type", contentType)
+	setStarted(ctx)
+	w.WriteHeader(statusCode)
+
+	return fn(w)
+}